@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"blog/internal/data"
+	"blog/internal/data/validator"
+)
+
+func (app *application) listAuthorPostsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	author, err := app.models.Users.GetAuthor(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		data.PostQuery
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Title = app.readString(qs, "title", "")
+	input.AuthorID = author.ID
+	input.Statuses = app.readPostStatuses(qs, v)
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-published_at")
+	input.Filters.SortSafelist = []string{"id", "title", "published_at", "-id", "-title", "-published_at"}
+	app.readCursor(qs, &input.Filters, v)
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Authors may list their own unpublished posts; everyone else needs to be an editor.
+	if len(input.Statuses) > 0 && app.contextGetUser(r).ID != author.ID {
+		allowed, err := app.userIsEditor(r)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !allowed {
+			app.notPermittedResponse(w, r)
+			return
+		}
+	}
+
+	posts, metadata, err := app.models.Posts.GetAllWithFeaturedImages(input.PostQuery, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.renderPosts(r, posts...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.encodeCursors(&metadata)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"author": author, "posts": posts, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
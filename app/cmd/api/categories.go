@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"blog/internal/data"
+	"blog/internal/data/validator"
+)
+
+func (app *application) listCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	categories, err := app.models.Categories.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"categories": categories}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ParentID    *int64 `json:"parent_id"`
+		Name        string `json:"name"`
+		Slug        string `json:"slug"`
+		Description string `json:"description"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	category := &data.Category{
+		ParentID:    input.ParentID,
+		Name:        input.Name,
+		Slug:        input.Slug,
+		Description: input.Description,
+	}
+	if category.Slug == "" {
+		category.Slug = data.Slugify(category.Name)
+	}
+
+	v := validator.New()
+	if data.ValidateCategory(v, category); v.Valid() {
+		err = app.checkCategoryParent(v, category)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Categories.Insert(category)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", "a category with this slug already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/categories/%s", category.Slug))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"category": category}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	category, err := app.models.Categories.GetBySlug(app.readSlugParam(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"category": category}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	category, err := app.models.Categories.GetBySlug(app.readSlugParam(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// parent_id is an optionalInt64 so that an explicit null, which moves the category
+	// to the top level, can be told apart from the field being left out.
+	var input struct {
+		ParentID    optionalInt64 `json:"parent_id"`
+		Name        *string       `json:"name"`
+		Slug        *string       `json:"slug"`
+		Description *string       `json:"description"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.ParentID.Set {
+		category.ParentID = input.ParentID.Value
+	}
+	if input.Name != nil {
+		category.Name = *input.Name
+	}
+	if input.Slug != nil {
+		category.Slug = *input.Slug
+	}
+	if input.Description != nil {
+		category.Description = *input.Description
+	}
+
+	v := validator.New()
+	if data.ValidateCategory(v, category); v.Valid() {
+		err = app.checkCategoryParent(v, category)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Categories.Update(category)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", "a category with this slug already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrCategoryCycle):
+			v.AddError("parent_id", "must not be one of the category's own subcategories")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"category": category}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	category, err := app.models.Categories.GetBySlug(app.readSlugParam(r))
+	if err == nil {
+		err = app.models.Categories.Delete(category.ID)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "category successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listCategoryPostsHandler(w http.ResponseWriter, r *http.Request) {
+	category, err := app.models.Categories.GetBySlug(app.readSlugParam(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.listTermPosts(w, r, data.PostQuery{Category: category.Slug}, envelope{"category": category})
+}
+
+// checkCategoryParent adds a validation error if the category's parent doesn't exist.
+func (app *application) checkCategoryParent(v *validator.Validator, category *data.Category) error {
+	if category.ParentID == nil {
+		return nil
+	}
+
+	_, err := app.models.Categories.Get(*category.ParentID)
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		v.AddError("parent_id", "must be an existing category")
+		return nil
+	default:
+		return err
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"blog/internal/data"
+	"blog/internal/data/validator"
+	"blog/internal/spam"
+
+	"github.com/tomasen/realip"
+)
+
+// readCommentablePost reads the post named in the URL, which must be public for its
+// comments to be read or added to. It writes an error response and returns false if
+// there is no such post.
+func (app *application) readCommentablePost(w http.ResponseWriter, r *http.Request) (*data.Post, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	post, err := app.models.Posts.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if !post.IsPublic() {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	return post, true
+}
+
+// listPostCommentsHandler lists the approved comments on a post as threads.
+func (app *application) listPostCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	post, ok := app.readCommentablePost(w, r)
+	if !ok {
+		return
+	}
+
+	comments, err := app.models.Comments.GetThreads(post.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"comments": comments}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createCommentHandler adds a comment to a post, or a reply to one of its approved
+// comments. Signed in users comment under their own name; anyone else gives a name
+// and email address. Comments wait for moderation unless a moderator wrote them, and
+// those the spam checks catch go straight to spam, though they're shown as pending.
+func (app *application) createCommentHandler(w http.ResponseWriter, r *http.Request) {
+	post, ok := app.readCommentablePost(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		ParentID    *int64 `json:"parent_id"`
+		AuthorName  string `json:"author_name"`
+		AuthorEmail string `json:"author_email"`
+		Content     string `json:"content"`
+		// Website is a honeypot, and FormToken the token from GET /v1/forms/token.
+		Website   string `json:"website"`
+		FormToken string `json:"form_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	comment := &data.Comment{
+		PostID:      post.ID,
+		ParentID:    input.ParentID,
+		AuthorName:  input.AuthorName,
+		AuthorEmail: input.AuthorEmail,
+		Content:     input.Content,
+		Status:      data.CommentStatusPending,
+		IPAddress:   realip.FromRequest(r),
+	}
+
+	user := app.contextGetUser(r)
+	if !user.IsAnonymous() {
+		comment.UserID = &user.ID
+		comment.AuthorName = user.Name
+		comment.AuthorEmail = user.Email
+	}
+
+	v := validator.New()
+	if data.ValidateComment(v, comment); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if comment.ParentID != nil {
+		parent, err := app.models.Comments.Get(*comment.ParentID)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if parent == nil || parent.PostID != post.ID || parent.Status != data.CommentStatusApproved {
+			v.AddError("parent_id", "must be an approved comment on the same post")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
+	permissions, err := app.userPermissions(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if permissions.Include("comments:moderate") {
+		comment.Status = data.CommentStatusApproved
+	} else {
+		isSpam, err := app.isSpam(r, &spam.Submission{
+			Kind:      spam.KindComment,
+			Name:      comment.AuthorName,
+			Email:     comment.AuthorEmail,
+			Content:   comment.Content,
+			IP:        comment.IPAddress,
+			Honeypot:  input.Website,
+			FormToken: input.FormToken,
+		})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if isSpam {
+			comment.Status = data.CommentStatusSpam
+		}
+	}
+
+	err = app.models.Comments.Insert(comment)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Telling a spammer their comment was caught only helps them get around the
+	// checks, so it looks like any other comment awaiting moderation.
+	if comment.Status == data.CommentStatusSpam {
+		shown := *comment
+		shown.Status = data.CommentStatusPending
+		comment = &shown
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"comment": comment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listCommentsHandler lists comments for moderators, by default the oldest pending
+// ones first.
+func (app *application) listCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.CommentQuery
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Status = app.readString(qs, "status", data.CommentStatusPending)
+	input.PostID = int64(app.readInt(qs, "post_id", 0, v))
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "created_at")
+	input.Filters.SortSafelist = []string{"id", "created_at", "-id", "-created_at"}
+
+	if input.Status == "all" {
+		input.Status = ""
+	}
+	v.Check(input.Status == "" || validator.PermittedValue(input.Status, data.CommentStatuses...), "status", "invalid status value")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	comments, metadata, err := app.models.Comments.GetAll(input.CommentQuery, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"comments": comments, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// moderateCommentHandler moves a comment to the status given, such as approved or
+// spam. Approving a comment or marking it as spam teaches the Bayes spam filter.
+func (app *application) moderateCommentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	comment, err := app.models.Comments.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Status string `json:"status"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if v.Check(validator.PermittedValue(input.Status, data.CommentStatuses...), "status", "invalid status value"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !comment.CanTransitionTo(input.Status) {
+		app.invalidStatusTransitionResponse(w, r, "comment", comment.Status, input.Status)
+		return
+	}
+
+	comment.Status = input.Status
+	err = app.models.Comments.UpdateStatus(comment)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.trainSpamFilter(r, comment)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"comment": comment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// bulkModerateCommentsHandler moves many comments to the same status at once, such
+// as when clearing out the moderation queue. Comments which can't move to that status
+// are skipped, and their ids returned as skipped.
+func (app *application) bulkModerateCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		IDs    []int64 `json:"ids"`
+		Status string  `json:"status"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.IDs) > 0, "ids", "must contain at least one id")
+	v.Check(len(input.IDs) <= 100, "ids", "must not contain more than 100 ids")
+	v.Check(validator.Unique(input.IDs), "ids", "must not contain duplicate values")
+	v.Check(validator.PermittedValue(input.Status, data.CommentStatuses...), "status", "invalid status value")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	comments, err := app.models.Comments.SetStatuses(input.IDs, input.Status)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	moved := make(map[int64]bool, len(comments))
+	for _, comment := range comments {
+		moved[comment.ID] = true
+		app.trainSpamFilter(r, comment)
+	}
+	skipped := []int64{}
+	for _, id := range input.IDs {
+		if !moved[id] {
+			skipped = append(skipped, id)
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"comments": comments, "skipped": skipped}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// cachedDocument is a generated feed or sitemap, as it was sent.
+type cachedDocument struct {
+	contentType  string
+	lastModified string
+	body         []byte
+	expires      time.Time
+}
+
+// maxCachedDocuments bounds how many documents the document cache holds. Each feed
+// narrowed by a tag or author is a document of its own.
+const maxCachedDocuments = 1000
+
+// documentCache keeps the feeds and sitemaps generated lately by their URL, until a
+// post changes or ttl passes. Post events only reach the instance which raised them,
+// so ttl bounds how long other instances go on serving an old document.
+type documentCache struct {
+	mu   sync.Mutex
+	ttl  time.Duration
+	docs map[string]*cachedDocument
+}
+
+// newDocumentCache returns a documentCache keeping documents for up to ttl. A ttl of
+// zero or less disables caching.
+func newDocumentCache(ttl time.Duration) *documentCache {
+	return &documentCache{ttl: ttl, docs: make(map[string]*cachedDocument)}
+}
+
+func (c *documentCache) get(key string) (*cachedDocument, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	doc, ok := c.docs[key]
+	if !ok || time.Now().After(doc.expires) {
+		delete(c.docs, key)
+		return nil, false
+	}
+	return doc, true
+}
+
+func (c *documentCache) put(key string, doc *cachedDocument) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if _, ok := c.docs[key]; !ok && len(c.docs) >= maxCachedDocuments {
+		for k, d := range c.docs {
+			if now.After(d.expires) {
+				delete(c.docs, k)
+			}
+		}
+		// Documents still fresh stay, and this one goes uncached until some expire.
+		if len(c.docs) >= maxCachedDocuments {
+			return
+		}
+	}
+
+	doc.expires = now.Add(c.ttl)
+	c.docs[key] = doc
+}
+
+// purge drops every document, so that each is generated afresh when next asked for.
+func (c *documentCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.docs = make(map[string]*cachedDocument)
+}
+
+// cacheDocument serves the feed or sitemap generated by next from the document cache,
+// generating and caching it if it isn't there. Only successful responses are cached.
+// documentKey names the document r asks for, from only the query string parameters
+// which change it, and reports false if r isn't one to cache.
+//
+// Links in documents are made absolute against the public URL, so nothing is cached
+// unless it is configured; otherwise each Host a request was made to would make a
+// document of its own.
+func (app *application) cacheDocument(documentKey func(*http.Request) (string, bool), next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, ok := documentKey(r)
+		if !ok || app.config.publicURL == "" {
+			next(w, r)
+			return
+		}
+
+		doc, ok := app.documents.get(key)
+		if !ok {
+			dw := &documentWriter{ResponseWriter: w}
+			next(dw, r)
+			if dw.status != http.StatusOK {
+				w.WriteHeader(dw.status)
+				w.Write(dw.body.Bytes())
+				return
+			}
+
+			doc = &cachedDocument{
+				contentType:  w.Header().Get("Content-Type"),
+				lastModified: w.Header().Get("Last-Modified"),
+				body:         dw.body.Bytes(),
+			}
+			app.documents.put(key, doc)
+		}
+
+		if doc.lastModified != "" {
+			w.Header().Set("Last-Modified", doc.lastModified)
+		}
+		w.Header().Set("Content-Type", doc.contentType)
+		w.WriteHeader(http.StatusOK)
+		w.Write(doc.body)
+	}
+}
+
+// documentWriter holds back the status and body of a response, so that they can be
+// cached before being sent.
+type documentWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (dw *documentWriter) WriteHeader(status int) {
+	if dw.status == 0 {
+		dw.status = status
+	}
+}
+
+func (dw *documentWriter) Write(b []byte) (int, error) {
+	if dw.status == 0 {
+		dw.status = http.StatusOK
+	}
+	return dw.body.Write(b)
+}
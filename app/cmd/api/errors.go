@@ -59,6 +59,27 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
+// preconditionFailedResponse is sent when an If-Match header doesn't match the
+// resource, which has changed since the client fetched it.
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the record has changed since it was fetched, please fetch it again and retry"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+// invalidStatusTransitionResponse is sent when a post or comment, as given by kind,
+// can't move from its status to the one asked for.
+func (app *application) invalidStatusTransitionResponse(w http.ResponseWriter, r *http.Request, kind, from, to string) {
+	message := fmt.Sprintf("a %s %s cannot be moved to %s", from, kind, to)
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+// spamRejectedResponse is sent when a registration is caught by the spam checks. It
+// doesn't say which, so as not to help spammers around them.
+func (app *application) spamRejectedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the request was rejected as spam"
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, message)
+}
+
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded"
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
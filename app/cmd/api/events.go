@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"blog/internal/data"
+	"blog/internal/events"
+)
+
+// subscribeEventHandlers wires up the handlers which react to application events.
+func (app *application) subscribeEventHandlers() {
+	for _, name := range []string{events.PostPublished, events.PostUpdated, events.PostDeleted} {
+		app.events.Subscribe(name, app.purgePostCaches)
+	}
+
+	for _, url := range app.config.webhooks.urls {
+		app.events.Subscribe(events.PostPublished, events.Webhook(url, app.config.webhooks.secret))
+	}
+}
+
+// purgePostCaches drops the renders of every version of the post an event is about,
+// along with the generated feeds and sitemaps, which are regenerated the next time
+// they're asked for.
+func (app *application) purgePostCaches(ctx context.Context, e events.Event) error {
+	payload, ok := e.Data.(envelope)
+	if !ok {
+		return fmt.Errorf("unexpected %s payload %T", e.Name, e.Data)
+	}
+	id, ok := payload["id"].(int64)
+	if !ok {
+		return fmt.Errorf("%s payload has no post id", e.Name)
+	}
+
+	app.markdown.Purge(fmt.Sprintf("%d:", id))
+	app.documents.purge()
+	return nil
+}
+
+// emit runs every handler subscribed to the event in the background, so a slow or
+// failing handler never holds up the request or job which raised it.
+func (app *application) emit(e events.Event) {
+	for _, handler := range app.events.Handlers(e.Name) {
+		handler := handler
+		app.background(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			err := handler(ctx, e)
+			if err != nil {
+				app.logger.Error(ctx, "event handler failed",
+					"event", e.Name,
+					"error", err.Error(),
+				)
+			}
+		})
+	}
+}
+
+// emitPostPublished announces that a post has just gone live.
+func (app *application) emitPostPublished(post *data.Post) {
+	app.logger.Info(context.Background(), "post published",
+		"post_id", post.ID,
+		"slug", post.Slug,
+	)
+
+	app.emit(events.New(events.PostPublished, envelope{
+		"id":           post.ID,
+		"slug":         post.Slug,
+		"title":        post.Title,
+		"excerpt":      post.Excerpt,
+		"author_id":    post.AuthorID,
+		"published_at": post.PublishedAt,
+	}))
+}
+
+// emitPostUpdated announces a change to a post other than its publication.
+func (app *application) emitPostUpdated(post *data.Post) {
+	app.emit(events.New(events.PostUpdated, envelope{
+		"id":     post.ID,
+		"slug":   post.Slug,
+		"status": post.Status,
+	}))
+}
+
+// emitPostDeleted announces that a post has been deleted.
+func (app *application) emitPostDeleted(post *data.Post) {
+	app.emit(events.New(events.PostDeleted, envelope{
+		"id":   post.ID,
+		"slug": post.Slug,
+	}))
+}
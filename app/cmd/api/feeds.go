@@ -0,0 +1,465 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"blog/internal/data"
+	"blog/internal/data/validator"
+)
+
+// feed holds what the RSS, Atom and JSON feeds are built from: the latest public
+// posts, optionally only those with a tag or by an author.
+type feed struct {
+	title       string
+	description string
+	// site is the origin links are made absolute against, link the page of the
+	// blog the feed follows, and self the URL of the feed itself.
+	site string
+	link string
+	self string
+	// updated is the last time any post in the feed was updated or a post was
+	// removed from the site, and is zero if neither has happened.
+	updated time.Time
+	posts   []*data.Post
+}
+
+// readFeed loads the posts of the feed requested by r, narrowed by the tag and
+// author query string parameters. It writes an error response and returns false if
+// the feed can't be built.
+func (app *application) readFeed(w http.ResponseWriter, r *http.Request) (*feed, bool) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	var q data.PostQuery
+	q.Tag = app.readString(qs, "tag", "")
+	authorID := app.readInt(qs, "author", 0, v)
+	v.Check(authorID >= 0, "author", "must be a positive integer")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	site := app.publicURL(r)
+	f := &feed{
+		title:       app.config.feeds.title,
+		description: app.config.feeds.description,
+		site:        site,
+		link:        site + "/blog",
+		self:        site + feedURI(r.URL.Path, q.Tag, authorID),
+	}
+
+	if q.Tag != "" {
+		tag, err := app.models.Tags.GetBySlug(q.Tag)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return nil, false
+		}
+		f.title += ": " + tag.Name
+	}
+
+	if authorID > 0 {
+		author, err := app.models.Users.GetAuthor(int64(authorID))
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return nil, false
+		}
+		q.AuthorID = author.ID
+		f.title += ": " + author.Name
+	}
+
+	filters := data.Filters{
+		Page:         1,
+		PageSize:     app.config.feeds.size,
+		Sort:         "-published_at",
+		SortSafelist: []string{"-published_at"},
+	}
+
+	posts, _, err := app.models.Posts.GetAllWithFeaturedImages(q, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	// A post leaving the feed changes it without updating any post still in it.
+	f.updated, err = app.models.Posts.LastRemoval()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	for _, post := range posts {
+		if app.config.feeds.content == "full" {
+			err := app.renderPost(post)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return nil, false
+			}
+		}
+		if post.UpdatedAt.After(f.updated) {
+			f.updated = post.UpdatedAt
+		}
+	}
+	f.posts = posts
+
+	return f, true
+}
+
+// feedURI returns the path and query string of a feed narrowed by tag and author,
+// with the parameters in a fixed order and those left empty dropped.
+func feedURI(path, tag string, author int) string {
+	qs := url.Values{}
+	if tag != "" {
+		qs.Set("tag", tag)
+	}
+	if author > 0 {
+		qs.Set("author", strconv.Itoa(author))
+	}
+	if len(qs) == 0 {
+		return path
+	}
+	return path + "?" + qs.Encode()
+}
+
+// feedDocumentKey keys the document cache on the feed r asks for, leaving out any
+// query string parameters readFeed doesn't read. Requests readFeed would reject
+// aren't cached.
+func feedDocumentKey(r *http.Request) (string, bool) {
+	qs := r.URL.Query()
+
+	author := 0
+	if s := qs.Get("author"); s != "" {
+		var err error
+		author, err = strconv.Atoi(s)
+		if err != nil || author < 0 {
+			return "", false
+		}
+	}
+	return feedURI(r.URL.Path, qs.Get("tag"), author), true
+}
+
+// postID returns the id of a post in feeds. It is the post's API URL rather than its
+// page, which changes if the post's slug does.
+func (f *feed) postID(post *data.Post) string {
+	return f.site + "/v1/posts/" + strconv.FormatInt(post.ID, 10)
+}
+
+// postLink returns the URL of a post's page on the blog.
+func (f *feed) postLink(post *data.Post) string {
+	return f.link + "/" + url.PathEscape(post.Slug)
+}
+
+// imageURL returns the absolute URL of an image.
+func (f *feed) imageURL(img *data.Image) string {
+	return f.site + "/v1/images/" + url.PathEscape(img.Filename)
+}
+
+// writeFeed sends a feed document, with the time the feed was last updated as its
+// Last-Modified. The conditionalGET middleware gives it an ETag.
+func (app *application) writeFeed(w http.ResponseWriter, f *feed, contentType string, body []byte) {
+	if !f.updated.IsZero() {
+		w.Header().Set("Last-Modified", f.updated.UTC().Format(http.TimeFormat))
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+type rssFeed struct {
+	XMLName   xml.Name   `xml:"rss"`
+	Version   string     `xml:"version,attr"`
+	ContentNS string     `xml:"xmlns:content,attr"`
+	DCNS      string     `xml:"xmlns:dc,attr"`
+	AtomNS    string     `xml:"xmlns:atom,attr"`
+	Channel   rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	Self          atomLink  `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	GUID        rssGUID       `xml:"guid"`
+	PubDate     string        `xml:"pubDate,omitempty"`
+	Creator     string        `xml:"dc:creator,omitempty"`
+	Categories  []string      `xml:"category"`
+	Description string        `xml:"description"`
+	Content     string        `xml:"content:encoded,omitempty"`
+	Enclosure   *rssEnclosure `xml:"enclosure"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// rssFeedHandler serves the feed as RSS 2.0. Posts' full content, when feeds carry
+// it, is given in content:encoded and their excerpt in description.
+func (app *application) rssFeedHandler(w http.ResponseWriter, r *http.Request) {
+	f, ok := app.readFeed(w, r)
+	if !ok {
+		return
+	}
+
+	doc := rssFeed{
+		Version:   "2.0",
+		ContentNS: "http://purl.org/rss/1.0/modules/content/",
+		DCNS:      "http://purl.org/dc/elements/1.1/",
+		AtomNS:    "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:       f.title,
+			Link:        f.link,
+			Description: f.description,
+			Self:        atomLink{Href: f.self, Rel: "self", Type: "application/rss+xml"},
+			Items:       []rssItem{},
+		},
+	}
+	if doc.Channel.Description == "" {
+		doc.Channel.Description = f.title
+	}
+	if !f.updated.IsZero() {
+		doc.Channel.LastBuildDate = f.updated.UTC().Format(time.RFC1123Z)
+	}
+
+	for _, post := range f.posts {
+		item := rssItem{
+			Title:       post.Title,
+			Link:        f.postLink(post),
+			GUID:        rssGUID{Value: f.postID(post)},
+			Categories:  post.Tags,
+			Description: post.Excerpt,
+			Content:     post.ContentHTML,
+		}
+		if post.PublishedAt != nil {
+			item.PubDate = post.PublishedAt.UTC().Format(time.RFC1123Z)
+		}
+		if post.Author != nil {
+			item.Creator = post.Author.Name
+		}
+		if img := post.FeaturedImage; img != nil {
+			item.Enclosure = &rssEnclosure{URL: f.imageURL(img), Length: img.FileSize, Type: img.MimeType}
+		}
+		doc.Channel.Items = append(doc.Channel.Items, item)
+	}
+
+	body, err := xml.MarshalIndent(doc, "", "\t")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeFeed(w, f, "application/rss+xml; charset=utf-8", append([]byte(xml.Header), body...))
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	ID       string      `xml:"id"`
+	Updated  string      `xml:"updated"`
+	Author   atomPerson  `xml:"author"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published,omitempty"`
+	Authors    []atomPerson   `xml:"author"`
+	Links      []atomLink     `xml:"link"`
+	Categories []atomCategory `xml:"category"`
+	Summary    *atomText      `xml:"summary"`
+	Content    *atomText      `xml:"content"`
+}
+
+type atomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// atomFeedHandler serves the feed as Atom. Entries without an author fall back on
+// the feed's, which is its title.
+func (app *application) atomFeedHandler(w http.ResponseWriter, r *http.Request) {
+	f, ok := app.readFeed(w, r)
+	if !ok {
+		return
+	}
+
+	// An empty feed has never been updated, as far as anyone can tell.
+	updated := f.updated
+	if updated.IsZero() {
+		updated = time.Unix(0, 0)
+	}
+
+	doc := atomFeed{
+		Title:    f.title,
+		Subtitle: f.description,
+		ID:       f.self,
+		Updated:  updated.UTC().Format(time.RFC3339),
+		Author:   atomPerson{Name: app.config.feeds.title},
+		Links: []atomLink{
+			{Href: f.self, Rel: "self", Type: "application/atom+xml"},
+			{Href: f.link, Rel: "alternate", Type: "text/html"},
+		},
+	}
+
+	for _, post := range f.posts {
+		entry := atomEntry{
+			Title:   post.Title,
+			ID:      f.postID(post),
+			Updated: post.UpdatedAt.UTC().Format(time.RFC3339),
+			Links:   []atomLink{{Href: f.postLink(post), Rel: "alternate", Type: "text/html"}},
+		}
+		if post.PublishedAt != nil {
+			entry.Published = post.PublishedAt.UTC().Format(time.RFC3339)
+		}
+		if post.Author != nil {
+			entry.Authors = []atomPerson{{Name: post.Author.Name}}
+		}
+		for _, tag := range post.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: tag})
+		}
+		if post.Excerpt != "" {
+			entry.Summary = &atomText{Type: "text", Body: post.Excerpt}
+		}
+		if post.ContentHTML != "" {
+			entry.Content = &atomText{Type: "html", Body: post.ContentHTML}
+		}
+		if img := post.FeaturedImage; img != nil {
+			entry.Links = append(entry.Links, atomLink{Href: f.imageURL(img), Rel: "enclosure", Type: img.MimeType, Length: img.FileSize})
+		}
+		doc.Entries = append(doc.Entries, entry)
+	}
+
+	body, err := xml.MarshalIndent(doc, "", "\t")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeFeed(w, f, "application/atom+xml; charset=utf-8", append([]byte(xml.Header), body...))
+}
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Description string         `json:"description,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url"`
+	Title         string           `json:"title"`
+	ContentHTML   string           `json:"content_html,omitempty"`
+	ContentText   string           `json:"content_text,omitempty"`
+	Summary       string           `json:"summary,omitempty"`
+	Image         string           `json:"image,omitempty"`
+	DatePublished string           `json:"date_published,omitempty"`
+	DateModified  string           `json:"date_modified"`
+	Authors       []jsonFeedAuthor `json:"authors,omitempty"`
+	Tags          []string         `json:"tags,omitempty"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+// jsonFeedHandler serves the feed as JSON Feed 1.1. Items need content, so when
+// feeds only carry excerpts the excerpt is given as content_text.
+func (app *application) jsonFeedHandler(w http.ResponseWriter, r *http.Request) {
+	f, ok := app.readFeed(w, r)
+	if !ok {
+		return
+	}
+
+	doc := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.title,
+		HomePageURL: f.link,
+		FeedURL:     f.self,
+		Description: f.description,
+		Items:       []jsonFeedItem{},
+	}
+
+	for _, post := range f.posts {
+		item := jsonFeedItem{
+			ID:           f.postID(post),
+			URL:          f.postLink(post),
+			Title:        post.Title,
+			ContentHTML:  post.ContentHTML,
+			Summary:      post.Excerpt,
+			DateModified: post.UpdatedAt.UTC().Format(time.RFC3339),
+			Tags:         post.Tags,
+		}
+		if item.ContentHTML == "" {
+			item.ContentText = post.Excerpt
+		}
+		if post.PublishedAt != nil {
+			item.DatePublished = post.PublishedAt.UTC().Format(time.RFC3339)
+		}
+		if post.Author != nil {
+			item.Authors = []jsonFeedAuthor{{Name: post.Author.Name}}
+		}
+		if post.FeaturedImage != nil {
+			item.Image = f.imageURL(post.FeaturedImage)
+		}
+		doc.Items = append(doc.Items, item)
+	}
+
+	body, err := json.MarshalIndent(doc, "", "\t")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeFeed(w, f, "application/feed+json; charset=utf-8", append(body, '\n'))
+}
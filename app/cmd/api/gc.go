@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"strings"
+	"time"
+
+	"blog/internal/data"
+	"blog/internal/storage"
+)
+
+// gcReport lists what a garbage collection run found, and in delete mode removed.
+type gcReport struct {
+	DryRun bool      `json:"dry_run"`
+	Cutoff time.Time `json:"cutoff"`
+	// OrphanedFiles and OrphanedVariants are stored files which no blob or variant row
+	// records, such as those left behind by an upload which crashed before its row was
+	// inserted.
+	OrphanedFiles    []string `json:"orphaned_files"`
+	OrphanedVariants []string `json:"orphaned_variants"`
+	// MissingFiles are blob and variant rows whose file is gone from storage. Missing
+	// variants are deleted, to be made again, but blobs are only reported: the files
+	// may only look missing because storage is misconfigured, and the images using
+	// them are users' own, so restoring or removing them is left to an administrator.
+	MissingFiles []gcMissingFile `json:"missing_files"`
+	// UnusedBlobs are blobs which no image is attached to any more.
+	UnusedBlobs []int64 `json:"unused_blobs"`
+	// StaleUploads are resumable uploads, finished or abandoned, which haven't been
+	// touched since the cutoff.
+	StaleUploads []string `json:"stale_uploads"`
+	Errors       int      `json:"errors"`
+}
+
+type gcMissingFile struct {
+	Kind     string `json:"kind"`
+	ID       int64  `json:"id"`
+	FilePath string `json:"file_path"`
+}
+
+// startGC launches the worker which reconciles stored files with the database every
+// gc.interval. It runs until app.done is closed during shutdown.
+func (app *application) startGC() {
+	app.background(func() {
+		ticker := time.NewTicker(app.config.gc.interval)
+		defer ticker.Stop()
+
+		app.logger.Info(context.Background(), "garbage collector started",
+			"interval", app.config.gc.interval.String(),
+			"grace", app.config.gc.grace.String(),
+			"dry_run", !app.config.gc.delete,
+		)
+
+		for {
+			report, err := app.collectGarbage(context.Background(), app.config.gc.grace, app.config.gc.delete)
+			if err != nil {
+				app.logger.Error(context.Background(), "garbage collection failed",
+					"error", err.Error(),
+				)
+			} else {
+				app.logger.Info(context.Background(), "garbage collection finished",
+					"dry_run", report.DryRun,
+					"orphaned_files", len(report.OrphanedFiles),
+					"orphaned_variants", len(report.OrphanedVariants),
+					"missing_files", len(report.MissingFiles),
+					"unused_blobs", len(report.UnusedBlobs),
+					"stale_uploads", len(report.StaleUploads),
+					"errors", report.Errors,
+				)
+			}
+
+			select {
+			case <-app.done:
+				app.logger.Info(context.Background(), "garbage collector stopped")
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// gcCommand runs the garbage collector once from the command line and prints its
+// report as JSON. It only reports unless -delete is given.
+func (app *application) gcCommand(args []string) error {
+	fs := flag.NewFlagSet("gc", flag.ContinueOnError)
+	del := fs.Bool("delete", app.config.gc.delete, "Delete garbage rather than only reporting it")
+	grace := fs.Duration("grace", app.config.gc.grace, "Minimum age of files and rows to consider")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	report, err := app.collectGarbage(context.Background(), *grace, *del)
+	if err != nil {
+		return err
+	}
+
+	js, err := json.MarshalIndent(report, "", "\t")
+	if err != nil {
+		return err
+	}
+	js = append(js, '\n')
+
+	_, err = os.Stdout.Write(js)
+	return err
+}
+
+// collectGarbage compares the files in storage with the blob and variant rows which
+// record them. Anything created within grace of now is left alone, since an upload
+// stores its file before inserting its row. Unless del is set nothing is changed and
+// the report only says what would be deleted.
+func (app *application) collectGarbage(ctx context.Context, grace time.Duration, del bool) (*gcReport, error) {
+	report := &gcReport{
+		DryRun:           !del,
+		Cutoff:           time.Now().Add(-grace).UTC(),
+		OrphanedFiles:    []string{},
+		OrphanedVariants: []string{},
+		MissingFiles:     []gcMissingFile{},
+		UnusedBlobs:      []int64{},
+		StaleUploads:     []string{},
+	}
+
+	// Read the rows before listing storage, so that a file stored after the rows were
+	// read is too new to be taken for an orphan.
+	files, err := app.models.Blobs.StoredFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	recorded := make(map[string]*data.StoredFile, len(files))
+	for _, file := range files {
+		recorded[file.FilePath] = file
+	}
+
+	found := make(map[string]bool, len(files))
+	err = app.storage.List(ctx, imageDir+"/", func(obj *storage.ObjectInfo) error {
+		if _, ok := recorded[obj.Key]; ok {
+			found[obj.Key] = true
+			return nil
+		}
+		if !obj.ModTime.Before(report.Cutoff) {
+			return nil
+		}
+
+		if strings.HasPrefix(obj.Key, variantDir+"/") {
+			report.OrphanedVariants = append(report.OrphanedVariants, obj.Key)
+		} else {
+			report.OrphanedFiles = append(report.OrphanedFiles, obj.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		if found[file.FilePath] || !file.CreatedAt.Before(report.Cutoff) {
+			continue
+		}
+
+		// The file may have been stored since the listing passed it, or the row deleted
+		// along with its file, so check again before believing it's missing.
+		_, err := app.storage.Stat(ctx, file.FilePath)
+		if !errors.Is(err, storage.ErrNotFound) {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		report.MissingFiles = append(report.MissingFiles, gcMissingFile{
+			Kind:     file.Kind,
+			ID:       file.ID,
+			FilePath: file.FilePath,
+		})
+	}
+
+	report.UnusedBlobs, err = app.models.Blobs.GetUnused(report.Cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.findStaleUploads(ctx, report)
+	if err != nil {
+		return nil, err
+	}
+
+	if del {
+		app.deleteGarbage(ctx, report)
+	}
+
+	return report, nil
+}
+
+// findStaleUploads adds to the report the uploads which haven't been touched since the
+// cutoff, and any stored chunks of uploads which no longer exist.
+func (app *application) findStaleUploads(ctx context.Context, report *gcReport) error {
+	uploads, err := app.models.Uploads.GetAll()
+	if err != nil {
+		return err
+	}
+
+	exists := make(map[string]bool, len(uploads))
+	for _, upload := range uploads {
+		exists[upload.ID] = true
+		if upload.UpdatedAt.Before(report.Cutoff) {
+			report.StaleUploads = append(report.StaleUploads, upload.ID)
+		}
+	}
+
+	// Chunks are stored under a directory named after their upload's id.
+	return app.storage.List(ctx, uploadChunkDir+"/", func(obj *storage.ObjectInfo) error {
+		id, _, _ := strings.Cut(strings.TrimPrefix(obj.Key, uploadChunkDir+"/"), "/")
+		if !exists[id] && obj.ModTime.Before(report.Cutoff) {
+			report.OrphanedFiles = append(report.OrphanedFiles, obj.Key)
+		}
+		return nil
+	})
+}
+
+// deleteGarbage removes everything listed in a report, logging and counting errors
+// rather than stopping at the first.
+func (app *application) deleteGarbage(ctx context.Context, report *gcReport) {
+	logError := func(err error, args ...any) {
+		report.Errors++
+		app.logger.Error(ctx, "garbage collector failed to delete",
+			append([]any{"error", err.Error()}, args...)...,
+		)
+	}
+
+	for _, keys := range [][]string{report.OrphanedFiles, report.OrphanedVariants} {
+		for _, key := range keys {
+			err := app.storage.Delete(ctx, key)
+			if err != nil {
+				logError(err, "file_path", key)
+			}
+		}
+	}
+
+	for _, missing := range report.MissingFiles {
+		// Variants are made again the next time they're asked for.
+		if missing.Kind == "variant" {
+			err := app.models.Variants.Delete(missing.ID)
+			if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+				logError(err, "variant_id", missing.ID)
+			}
+		}
+	}
+
+	for _, id := range report.StaleUploads {
+		app.deleteUpload(id)
+	}
+
+	for _, id := range report.UnusedBlobs {
+		err := app.models.Blobs.DeleteUnused(id, func(paths []string) error {
+			return app.removeStoredFiles(ctx, paths)
+		})
+		if err != nil {
+			logError(err, "blob_id", id)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,13 +12,38 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
+	"blog/internal/data"
 	"blog/internal/data/validator"
 	"github.com/julienschmidt/httprouter"
 )
 
 type envelope map[string]any
 
+// optionalInt64 is a nullable JSON integer which also records whether the field was
+// present at all, for PATCH inputs where null and "leave unchanged" differ.
+type optionalInt64 struct {
+	Set   bool
+	Value *int64
+}
+
+func (o *optionalInt64) UnmarshalJSON(b []byte) error {
+	o.Set = true
+	if string(b) == "null" {
+		o.Value = nil
+		return nil
+	}
+
+	var n int64
+	err := json.Unmarshal(b, &n)
+	if err != nil {
+		return err
+	}
+	o.Value = &n
+	return nil
+}
+
 func (app *application) readIDParam(r *http.Request) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
@@ -26,6 +53,16 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// readVersionParam reads the named URL parameter as a post version number.
+func (app *application) readVersionParam(r *http.Request, name string) (int32, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	version, err := strconv.ParseInt(params.ByName(name), 10, 32)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid %s parameter", name)
+	}
+	return int32(version), nil
+}
+
 func (app *application) readSlugParam(r *http.Request) string {
 	params := httprouter.ParamsFromContext(r.Context())
 	return params.ByName("slug")
@@ -39,11 +76,10 @@ func (app *application) readFilenameParam(r *http.Request) string {
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
 
 	// Encode the data to JSON, returning the error if there was one.
-	js, err := json.MarshalIndent(data, "", "\t")
+	js, err := encodeJSON(data)
 	if err != nil {
 		return err
 	}
-	js = append(js, '\n')
 
 	for key, value := range headers {
 		w.Header()[key] = value
@@ -54,6 +90,102 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data envelo
 	w.Write(js)
 	return nil
 }
+
+// encodeJSON encodes a response body the way writeJSON sends it.
+func encodeJSON(data envelope) ([]byte, error) {
+	js, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append(js, '\n'), nil
+}
+
+// strongETag returns the ETag of a response body: a hash of its bytes, so that the
+// same tag always means the same body.
+func strongETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether any of the comma separated entity tags in header,
+// such as an If-None-Match or If-Match header, matches etag. "*" matches anything.
+// Weak comparison ignores the W/ prefix of weak tags; strong comparison never matches
+// them.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch reports whether the request's If-Match header matches the ETag the
+// resource would be sent with by a GET now, given the data of that response. Requests
+// without If-Match always pass.
+func (app *application) checkIfMatch(r *http.Request, data envelope) (bool, error) {
+	ifMatch := strings.Join(r.Header.Values("If-Match"), ",")
+	if ifMatch == "" {
+		return true, nil
+	}
+
+	js, err := encodeJSON(data)
+	if err != nil {
+		return false, err
+	}
+	return etagMatches(ifMatch, strongETag(js), false), nil
+}
+
+// lastModified returns headers giving the latest of the times as Last-Modified.
+func lastModified(times ...time.Time) http.Header {
+	var latest time.Time
+	for _, t := range times {
+		if t.After(latest) {
+			latest = t
+		}
+	}
+
+	headers := make(http.Header)
+	headers.Set("Last-Modified", latest.UTC().Format(http.TimeFormat))
+	return headers
+}
+
+// postLastModified returns headers giving the last time a post or any of its images
+// was updated as Last-Modified.
+func postLastModified(post *data.Post) http.Header {
+	times := []time.Time{post.UpdatedAt}
+	for _, img := range post.Images {
+		times = append(times, img.UpdatedAt)
+	}
+	return lastModified(times...)
+}
+
+// publicURL returns the origin of the public site, such as https://example.com, for
+// building absolute links. Unless it is configured with -public-url it is the origin
+// the request was made to, and a proxy in front of the API can say the request came
+// over HTTPS with X-Forwarded-Proto.
+func (app *application) publicURL(r *http.Request) string {
+	if app.config.publicURL != "" {
+		return app.config.publicURL
+	}
+
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
 	maxBytes := 1_048_576
 	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
@@ -149,6 +281,52 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// readCursor switches the filters to cursor mode if the query string has a cursor or
+// a limit. A cursor carries the sort it was made for, which overrides ?sort=.
+func (app *application) readCursor(qs url.Values, filters *data.Filters, v *validator.Validator) {
+	if s := qs.Get("cursor"); s != "" {
+		cursor, err := data.DecodeCursor(s, app.config.cursor.key)
+		if err != nil {
+			v.AddError("cursor", "invalid cursor")
+		} else {
+			filters.Cursor = cursor
+			filters.Sort = cursor.Sort
+		}
+	}
+
+	if filters.Cursor != nil || qs.Get("limit") != "" {
+		filters.Limit = app.readInt(qs, "limit", 20, v)
+	}
+}
+
+// encodeCursors fills in the next and previous cursors of a cursor mode listing.
+func (app *application) encodeCursors(metadata *data.Metadata) error {
+	var err error
+	if metadata.Next != nil {
+		metadata.NextCursor, err = data.EncodeCursor(metadata.Next, app.config.cursor.key)
+		if err != nil {
+			return err
+		}
+	}
+	if metadata.Prev != nil {
+		metadata.PrevCursor, err = data.EncodeCursor(metadata.Prev, app.config.cursor.key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The userPermissions() helper returns the permission codes held by the user making
+// the request. Anonymous users hold none, so we skip the database for them.
+func (app *application) userPermissions(r *http.Request) (data.Permissions, error) {
+	user := app.contextGetUser(r)
+	if user.IsAnonymous() {
+		return data.Permissions{}, nil
+	}
+	return app.models.Permissions.GetAllForUser(user.ID)
+}
+
 // The background() helper accepts an arbitrary function as a parameter.
 func (app *application) background(fn func()) {
 	// Increment the WaitGroup counter.
@@ -2,11 +2,14 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"runtime"
 	"strings"
@@ -14,7 +17,13 @@ import (
 	"time"
 
 	"blog/internal/data"
+	"blog/internal/data/validator"
+	"blog/internal/events"
+	"blog/internal/imaging"
 	"blog/internal/logger"
+	"blog/internal/markdown"
+	"blog/internal/spam"
+	"blog/internal/storage"
 	"blog/internal/vcs"
 	_ "github.com/lib/pq"
 )
@@ -40,13 +49,102 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	scheduler struct {
+		enabled  bool
+		interval time.Duration
+	}
+	gc struct {
+		enabled  bool
+		interval time.Duration
+		// grace is how old a file or row must be before the garbage collector touches
+		// it, so that uploads in progress aren't mistaken for garbage.
+		grace time.Duration
+		// delete makes the garbage collector delete what it finds rather than only
+		// reporting it.
+		delete bool
+	}
+	webhooks struct {
+		urls   []string
+		secret string
+	}
+	markdown struct {
+		cacheSize int
+	}
+	// publicURL is the origin of the public site, such as https://blog.example.com,
+	// which the API is served under too. Links in feeds and the sitemap are made
+	// absolute against it.
+	publicURL string
+	robots    struct {
+		// content is served as robots.txt in place of the generated rules, if set.
+		content string
+	}
+	comments struct {
+		// limiter limits how often each client can submit comments, separately from
+		// the global limiter.
+		limiter struct {
+			rps     float64
+			burst   int
+			enabled bool
+		}
+	}
+	spam struct {
+		// checks names the spam checks comments and registrations go through, in order.
+		checks        []string
+		minTime       time.Duration
+		maxLinks      int
+		blocklistFile string
+		threshold     float64
+	}
+	feeds struct {
+		title       string
+		description string
+		// content is "full" to put the rendered content of each post in feeds, or
+		// "excerpt" to give only its excerpt.
+		content string
+		size    int
+		// cacheTTL is how long generated feeds and sitemaps are kept.
+		cacheTTL time.Duration
+	}
+	images struct {
+		variants []imaging.Variant
+	}
+	storage struct {
+		backend   string
+		localRoot string
+		s3        storage.S3Config
+		// signedURLs makes image requests redirect to a signed URL on the storage
+		// backend, where it supports them, rather than streaming through the API.
+		signedURLs bool
+	}
+	cursor struct {
+		// key signs pagination cursors, and form tokens are signed with a key
+		// derived from it. It is derived from the -cursor-secret flag, or generated
+		// at startup if none is given.
+		key []byte
+	}
 }
 
 type application struct {
 	config config
 	logger *logger.Logger
 	models data.Models
-	wg     sync.WaitGroup
+	events *events.Bus
+	// spamChecker decides whether comments and registrations are spam, and formTokens
+	// issues the form tokens its minimum time check expects.
+	spamChecker spam.SpamChecker
+	formTokens  spam.MinTime
+	// storage holds uploaded files.
+	storage storage.Storage
+	// markdown caches rendered post content by post id and version.
+	markdown *markdown.Cache
+	// documents caches generated feeds and sitemaps until a post changes.
+	documents *documentCache
+	// variantJobs bounds the resized copies of images being made at once.
+	variantJobs *variantJobs
+	wg          sync.WaitGroup
+	// done is closed when the server starts shutting down, telling long-running
+	// background workers such as the scheduler to return.
+	done chan struct{}
 }
 
 func main() {
@@ -64,11 +162,88 @@ func main() {
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
 
+	flag.Float64Var(&cfg.comments.limiter.rps, "comments-limiter-rps", 0.1, "Rate limiter maximum comment submissions per second")
+	flag.IntVar(&cfg.comments.limiter.burst, "comments-limiter-burst", 3, "Rate limiter maximum comment submission burst")
+	flag.BoolVar(&cfg.comments.limiter.enabled, "comments-limiter-enabled", true, "Enable comment submission rate limiter")
+
+	cfg.spam.checks = strings.Split(defaultSpamChecks, ",")
+	flag.Func("spam-checks", "Spam checks run on comments and registrations, of "+strings.Join(spamChecks, ", ")+" (comma separated, default \""+defaultSpamChecks+"\")", func(val string) error {
+		checks := strings.Split(val, ",")
+		if val == "" {
+			checks = nil
+		}
+		for _, check := range checks {
+			if !validator.PermittedValue(check, spamChecks...) {
+				return fmt.Errorf("unknown spam check %q", check)
+			}
+		}
+		cfg.spam.checks = checks
+		return nil
+	})
+	flag.DurationVar(&cfg.spam.minTime, "spam-min-time", 3*time.Second, "Minimum time between fetching a form token and submitting the form")
+	flag.IntVar(&cfg.spam.maxLinks, "spam-max-links", 2, "Maximum number of links in a comment")
+	flag.StringVar(&cfg.spam.blocklistFile, "spam-blocklist-file", "", "File of blocked words, domains and IP addresses, one on each line")
+	flag.Float64Var(&cfg.spam.threshold, "spam-threshold", 0.9, "Probability of spam above which the Bayes filter catches a comment")
+
 	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
 		cfg.cors.trustedOrigins = strings.Fields(val)
 		return nil
 	})
 
+	flag.BoolVar(&cfg.scheduler.enabled, "scheduler-enabled", true, "Enable the scheduled publishing worker")
+	flag.DurationVar(&cfg.scheduler.interval, "scheduler-interval", 30*time.Second, "How often the scheduler checks for due posts")
+
+	flag.BoolVar(&cfg.gc.enabled, "gc-enabled", false, "Enable the worker reconciling stored files with the database")
+	flag.DurationVar(&cfg.gc.interval, "gc-interval", 6*time.Hour, "How often the garbage collector runs")
+	flag.DurationVar(&cfg.gc.grace, "gc-grace", 24*time.Hour, "Minimum age of files and rows the garbage collector considers")
+	flag.BoolVar(&cfg.gc.delete, "gc-delete", false, "Delete garbage rather than only reporting it")
+
+	flag.Func("webhook-urls", "URLs to notify of events such as post.published (space separated)", func(val string) error {
+		cfg.webhooks.urls = strings.Fields(val)
+		return nil
+	})
+	flag.StringVar(&cfg.webhooks.secret, "webhook-secret", os.Getenv("TECHNOPRISE_WEBHOOK_SECRET"), "Secret used to sign webhook deliveries")
+
+	flag.IntVar(&cfg.markdown.cacheSize, "markdown-cache-size", 1000, "Number of rendered posts to keep in memory (0 disables the cache)")
+
+	publicURL := flag.String("public-url", os.Getenv("TECHNOPRISE_PUBLIC_URL"), "Public base URL of the site, such as https://blog.example.com (the host requested if empty)")
+	robotsFile := flag.String("robots-file", "", "File served as robots.txt in place of the generated one")
+
+	flag.StringVar(&cfg.feeds.title, "feed-title", "Technoprise Blog", "Title of the RSS, Atom and JSON feeds")
+	flag.StringVar(&cfg.feeds.description, "feed-description", "", "Description of the RSS, Atom and JSON feeds")
+	cfg.feeds.content = "full"
+	flag.Func("feed-content", "Post content given in feeds (full|excerpt, default \"full\")", func(val string) error {
+		if val != "full" && val != "excerpt" {
+			return errors.New("must be full or excerpt")
+		}
+		cfg.feeds.content = val
+		return nil
+	})
+	flag.IntVar(&cfg.feeds.size, "feed-size", 20, "Number of posts in each feed")
+	flag.DurationVar(&cfg.feeds.cacheTTL, "feed-cache-ttl", 5*time.Minute, "How long generated feeds and sitemaps are kept in memory (0 disables the cache)")
+
+	cfg.images.variants, _ = imaging.ParseVariants(imaging.DefaultVariants)
+	flag.Func("image-variants", "Image sizes generated on upload as name:max_width:quality (comma separated, default \""+imaging.DefaultVariants+"\")", func(val string) error {
+		variants, err := imaging.ParseVariants(val)
+		if err != nil {
+			return err
+		}
+		cfg.images.variants = variants
+		return nil
+	})
+
+	flag.StringVar(&cfg.storage.backend, "storage", "local", "Storage backend for uploads (local|s3)")
+	flag.StringVar(&cfg.storage.localRoot, "storage-local-root", "uploads", "Directory uploads are kept in by the local backend")
+	flag.StringVar(&cfg.storage.s3.Endpoint, "storage-s3-endpoint", os.Getenv("TECHNOPRISE_S3_ENDPOINT"), "S3-compatible endpoint URL")
+	flag.StringVar(&cfg.storage.s3.Region, "storage-s3-region", "us-east-1", "S3 region")
+	flag.StringVar(&cfg.storage.s3.Bucket, "storage-s3-bucket", os.Getenv("TECHNOPRISE_S3_BUCKET"), "S3 bucket")
+	flag.StringVar(&cfg.storage.s3.AccessKey, "storage-s3-access-key", os.Getenv("TECHNOPRISE_S3_ACCESS_KEY"), "S3 access key")
+	flag.StringVar(&cfg.storage.s3.SecretKey, "storage-s3-secret-key", os.Getenv("TECHNOPRISE_S3_SECRET_KEY"), "S3 secret key")
+	flag.BoolVar(&cfg.storage.s3.PathStyle, "storage-s3-path-style", true, "Put the bucket in the URL path rather than the host name")
+	flag.BoolVar(&cfg.storage.signedURLs, "storage-signed-urls", false, "Redirect image requests to signed URLs where the backend supports them")
+
+	cursorSecret := flag.String("cursor-secret", os.Getenv("TECHNOPRISE_CURSOR_SECRET"), "Secret used to sign pagination cursors and form tokens (required outside development)")
+
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 
 	flag.Parse()
@@ -77,6 +252,48 @@ func main() {
 		fmt.Printf("Version:\t%s\n", version)
 		os.Exit(0)
 	}
+
+	// A random key would break every cursor and form token on restart, and between
+	// instances behind a load balancer, so it's only good enough for development.
+	switch {
+	case *cursorSecret != "":
+		cfg.cursor.key = []byte(*cursorSecret)
+	case cfg.env != "development":
+		fmt.Printf("A -cursor-secret (or TECHNOPRISE_CURSOR_SECRET) is required in %s\n", cfg.env)
+		os.Exit(1)
+	default:
+		cfg.cursor.key = make([]byte, 32)
+		_, err := rand.Read(cfg.cursor.key)
+		if err != nil {
+			fmt.Printf("Failed to generate cursor key: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *publicURL != "" {
+		u, err := url.Parse(*publicURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fmt.Printf("Invalid public URL %q: must be an absolute http or https URL\n", *publicURL)
+			os.Exit(1)
+		}
+		cfg.publicURL = strings.TrimSuffix(*publicURL, "/")
+	}
+
+	if *robotsFile != "" {
+		robots, err := os.ReadFile(*robotsFile)
+		if err != nil {
+			fmt.Printf("Failed to read robots.txt: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.robots.content = string(robots)
+	}
+
+	store, err := openStorage(cfg)
+	if err != nil {
+		fmt.Printf("Failed to open storage: %v\n", err)
+		os.Exit(1)
+	}
+
 	db, err := openDB(cfg)
 	if err != nil {
 		fmt.Printf("Failed to open database: %v\n", err)
@@ -86,6 +303,32 @@ func main() {
 
 	log := logger.New(os.Stdout, logger.LevelInfo, "technoprise-api", nil)
 
+	// "api [flags] gc [-delete] [-grace=24h]" runs the garbage collector once, and
+	// "api [flags] placeholders [-batch=100]" fills in missing image placeholders.
+	// Either exits when done.
+	if command := flag.Arg(0); command == "gc" || command == "placeholders" {
+		app := &application{
+			config:  cfg,
+			logger:  log,
+			models:  data.NewModels(db),
+			storage: store,
+		}
+
+		var err error
+		switch command {
+		case "gc":
+			err = app.gcCommand(flag.Args()[1:])
+		case "placeholders":
+			err = app.placeholdersCommand(flag.Args()[1:])
+		}
+		if err != nil {
+			fmt.Printf("Command %s failed: %v\n", command, err)
+			db.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
 	ctx := context.Background()
 	log.Info(ctx, "application starting up",
 		"version", version,
@@ -107,11 +350,26 @@ func main() {
 	}))
 
 	app := &application{
-		config: cfg,
-		logger: log,
-		models: data.NewModels(db),
+		config:      cfg,
+		logger:      log,
+		models:      data.NewModels(db),
+		events:      events.NewBus(),
+		storage:     store,
+		markdown:    markdown.NewCache(cfg.markdown.cacheSize),
+		documents:   newDocumentCache(cfg.feeds.cacheTTL),
+		variantJobs: newVariantJobs(maxVariantJobs),
+		done:        make(chan struct{}),
 	}
 
+	err = app.setupSpamChecker()
+	if err != nil {
+		fmt.Printf("Failed to set up spam checks: %v\n", err)
+		db.Close()
+		os.Exit(1)
+	}
+
+	app.subscribeEventHandlers()
+
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.port),
 		Handler:      app.routes(),
@@ -139,23 +397,34 @@ func openDB(cfg config) (*sql.DB, error) {
 	if err != nil {
 		return nil, err
 	}
-	
+
 	db.SetMaxOpenConns(cfg.db.maxOpenConns)
 	db.SetMaxIdleConns(cfg.db.maxIdleConns)
-	
+
 	duration, err := time.ParseDuration(cfg.db.maxIdleTime)
 	if err != nil {
 		return nil, err
 	}
 	db.SetConnMaxIdleTime(duration)
-	
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	
+
 	err = db.PingContext(ctx)
 	if err != nil {
 		return nil, err
 	}
-	
+
 	return db, nil
 }
+
+func openStorage(cfg config) (storage.Storage, error) {
+	switch cfg.storage.backend {
+	case "local":
+		return storage.NewLocal(cfg.storage.localRoot)
+	case "s3":
+		return storage.NewS3(cfg.storage.s3)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.storage.backend)
+	}
+}
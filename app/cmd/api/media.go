@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"blog/internal/data"
+	"blog/internal/data/validator"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// listMediaHandler lists the media library, newest first, searching the alt text and
+// captions of images if q is given.
+func (app *application) listMediaHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	search := app.readString(qs, "q", "")
+
+	var filters data.Filters
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-created_at")
+	filters.SortSafelist = []string{"created_at", "id", "-created_at", "-id"}
+	app.readCursor(qs, &filters, v)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	images, metadata, err := app.models.Images.GetAll(search, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.encodeCursors(&metadata)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.setImageSrcsets(images...)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"images": images, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createMediaHandler uploads an image to the media library without attaching it to
+// any post.
+func (app *application) createMediaHandler(w http.ResponseWriter, r *http.Request) {
+	upload, ok := app.readImageUpload(w, r)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+	image, err := app.saveImage(r.Context(), v, *upload)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.setImageSrcsets(image)
+
+	headers := make(http.Header)
+	headers.Set("Location", "/v1/media/"+strconv.FormatInt(image.ID, 10))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"image": image}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showMediaHandler returns an image from the media library, with the posts it's
+// attached to.
+func (app *application) showMediaHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	image, err := app.models.Images.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.setImageSrcsets(image)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"image": image}, lastModified(image.UpdatedAt))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// attachMediaHandler attaches an image from the media library to a post.
+func (app *application) attachMediaHandler(w http.ResponseWriter, r *http.Request) {
+	post, ok := app.readManagedPost(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		ImageID    int64 `json:"image_id"`
+		IsFeatured bool  `json:"is_featured"`
+		SortOrder  int   `json:"sort_order"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.ImageID > 0, "image_id", "must be provided")
+	v.Check(input.SortOrder >= 0, "sort_order", "must not be negative")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	image, err := app.models.Images.Get(input.ImageID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("image_id", "must be an image in the media library")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	image.PostID = post.ID
+	image.IsFeatured = input.IsFeatured
+	image.SortOrder = input.SortOrder
+
+	err = app.models.Images.Attach(image)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrAlreadyAttached):
+			v.AddError("image_id", "is already attached to this post")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	image, err = app.models.Images.GetForPost(post.ID, image.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.setImageSrcsets(image)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"image": image}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// detachMediaHandler removes an image from a post. The image stays in the media
+// library, along with any other posts it's attached to.
+func (app *application) detachMediaHandler(w http.ResponseWriter, r *http.Request) {
+	post, ok := app.readManagedPost(w, r)
+	if !ok {
+		return
+	}
+
+	imageID, err := strconv.ParseInt(httprouter.ParamsFromContext(r.Context()).ByName("image_id"), 10, 64)
+	if err != nil || imageID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Images.Detach(post.ID, imageID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "image successfully detached"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readManagedPost fetches the post named in the URL, checking that the user making
+// the request may manage it. If it writes an error response it returns false.
+func (app *application) readManagedPost(w http.ResponseWriter, r *http.Request) (*data.Post, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	post, err := app.models.Posts.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	allowed, err := app.userCanManagePost(r, post)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+	if !allowed {
+		app.notPermittedResponse(w, r)
+		return nil, false
+	}
+
+	return post, true
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"net/http"
@@ -27,53 +28,80 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) rateLimit(next http.Handler) http.Handler {
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
-	}
+// clientLimiters keeps a token bucket rate limiter for each client IP address,
+// forgetting clients which haven't been seen for three minutes.
+type clientLimiters struct {
+	mu      sync.Mutex
+	rps     float64
+	burst   int
+	clients map[string]*clientLimiter
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
 
-	var (
-		mu      sync.Mutex
-		clients = make(map[string]*client)
-	)
+func newClientLimiters(rps float64, burst int) *clientLimiters {
+	l := &clientLimiters{rps: rps, burst: burst, clients: make(map[string]*clientLimiter)}
 
 	go func() {
 		for {
 			time.Sleep(time.Minute)
-			mu.Lock()
-			for ip, client := range clients {
+			l.mu.Lock()
+			for ip, client := range l.clients {
 				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
+					delete(l.clients, ip)
 				}
-				mu.Unlock()
 			}
+			l.mu.Unlock()
 		}
 	}()
 
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if app.config.limiter.enabled {
-			ip := realip.FromRequest(r)
+	return l
+}
 
-			mu.Lock()
-			if _, found := clients[ip]; !found {
-				clients[ip] = &client{
-					limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst),
-				}
-			}
-			clients[ip].lastSeen = time.Now()
+// allow reports whether the client making the request may make another one now.
+func (l *clientLimiters) allow(r *http.Request) bool {
+	ip := realip.FromRequest(r)
 
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
-				app.rateLimitExceededResponse(w, r)
-				return
-			}
-			mu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, found := l.clients[ip]; !found {
+		l.clients[ip] = &clientLimiter{limiter: rate.NewLimiter(rate.Limit(l.rps), l.burst)}
+	}
+	l.clients[ip].lastSeen = time.Now()
+
+	return l.clients[ip].limiter.Allow()
+}
+
+func (app *application) rateLimit(next http.Handler) http.Handler {
+	limiters := newClientLimiters(app.config.limiter.rps, app.config.limiter.burst)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.config.limiter.enabled && !limiters.allow(r) {
+			app.rateLimitExceededResponse(w, r)
+			return
 		}
 		next.ServeHTTP(w, r)
 	})
 }
 
+// rateLimitComments limits how often each client can submit comments, on top of the
+// global rate limit.
+func (app *application) rateLimitComments(next http.HandlerFunc) http.HandlerFunc {
+	limiters := newClientLimiters(app.config.comments.limiter.rps, app.config.comments.limiter.burst)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.config.comments.limiter.enabled && !limiters.allow(r) {
+			app.rateLimitExceededResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Authorization")
@@ -165,10 +193,12 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 			for i := range app.config.cors.trustedOrigins {
 				if origin == app.config.cors.trustedOrigins[i] {
 					w.Header().Set("Access-Control-Allow-Origin", origin)
+					// Let browser clients read validators and the tus headers of resumable uploads.
+					w.Header().Set("Access-Control-Expose-Headers", "Location, ETag, Last-Modified, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Upload-Offset, Upload-Length, Upload-Metadata, Image-Id")
 
 					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
-						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE, HEAD")
+						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match, Tus-Resumable, Upload-Length, Upload-Offset, Upload-Metadata")
 						w.WriteHeader(http.StatusOK)
 						return
 					}
@@ -181,3 +211,103 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 	})
 }
 
+// conditionalGET gives successful JSON, feed and sitemap responses to GET requests a
+// strong ETag, and answers If-None-Match, or failing that If-Modified-Since against
+// any Last-Modified the handler set, with 304 Not Modified when the client's copy is
+// still current.
+func (app *application) conditionalGET(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &conditionalWriter{ResponseWriter: w}
+		next.ServeHTTP(cw, r)
+		if !cw.buffering {
+			return
+		}
+
+		etag := strongETag(cw.body.Bytes())
+		w.Header().Set("ETag", etag)
+
+		if notModified(r, etag, w.Header().Get("Last-Modified")) {
+			w.Header().Del("Content-Type")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(cw.body.Bytes())
+	})
+}
+
+// notModified reports whether a GET request's validators show that the client already
+// has the response with the given ETag and Last-Modified.
+func notModified(r *http.Request, etag, lastModified string) bool {
+	if ifNoneMatch := strings.Join(r.Header.Values("If-None-Match"), ","); ifNoneMatch != "" {
+		return etagMatches(ifNoneMatch, etag, true)
+	}
+
+	ifModifiedSince := r.Header.Get("If-Modified-Since")
+	if ifModifiedSince == "" || lastModified == "" {
+		return false
+	}
+	since, err := http.ParseTime(ifModifiedSince)
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(lastModified)
+	if err != nil {
+		return false
+	}
+	return !modified.After(since)
+}
+
+// conditionalWriter holds back the body of a successful JSON, feed or sitemap
+// response, so that its ETag can be worked out before anything is sent. Other
+// responses pass straight through.
+type conditionalWriter struct {
+	http.ResponseWriter
+	body        bytes.Buffer
+	wroteHeader bool
+	buffering   bool
+}
+
+func (cw *conditionalWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	if status == http.StatusOK && conditionalType(cw.Header().Get("Content-Type")) {
+		cw.buffering = true
+		return
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+// conditionalType reports whether responses of the content type are given ETags:
+// JSON, the RSS, Atom and JSON feeds, and the sitemap.
+func conditionalType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	switch mediaType {
+	case "application/json", "application/feed+json", "application/rss+xml", "application/atom+xml", "application/xml":
+		return true
+	}
+	return false
+}
+
+func (cw *conditionalWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.buffering {
+		return cw.body.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *conditionalWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"blog/internal/data"
+	"blog/internal/imaging"
+)
+
+// placeholdersCommand fills in the BlurHash and LQIP of images uploaded before they
+// were worked out on upload. Each stored file is read once, however many images share
+// it. Files which can't be read are logged and skipped, so it can be run again.
+func (app *application) placeholdersCommand(args []string) error {
+	fs := flag.NewFlagSet("placeholders", flag.ContinueOnError)
+	batch := fs.Int("batch", 100, "Number of stored files to read per database query")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var afterBlobID, updated int64
+	failed := 0
+
+	for {
+		images, err := app.models.Images.GetMissingPlaceholders(afterBlobID, *batch)
+		if err != nil {
+			return err
+		}
+		if len(images) == 0 {
+			break
+		}
+
+		for _, img := range images {
+			afterBlobID = img.BlobID
+
+			n, err := app.backfillPlaceholders(ctx, img)
+			if err != nil {
+				failed++
+				app.logger.Error(ctx, "failed to make image placeholders",
+					"error", err.Error(),
+					"image_id", img.ID,
+					"file_path", img.FilePath,
+				)
+				continue
+			}
+			updated += n
+		}
+	}
+
+	app.logger.Info(ctx, "image placeholders backfilled",
+		"images_updated", updated,
+		"files_failed", failed,
+	)
+	return nil
+}
+
+// backfillPlaceholders works out the placeholders of an image's file and records them
+// for every image sharing it, returning how many images were updated.
+func (app *application) backfillPlaceholders(ctx context.Context, img *data.Image) (int64, error) {
+	src, err := app.decodeImage(ctx, img)
+	if err != nil {
+		return 0, err
+	}
+
+	blurHash, lqip, err := imaging.Placeholders(src, img.MimeType)
+	if err != nil {
+		return 0, err
+	}
+
+	return app.models.Images.SetPlaceholders(img.BlobID, blurHash, lqip)
+}
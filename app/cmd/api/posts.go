@@ -1,11 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"net/url"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -13,15 +18,18 @@ import (
 
 	"blog/internal/data"
 	"blog/internal/data/validator"
+	"blog/internal/imaging"
 )
 
 func (app *application) createPostHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title       string    `json:"title"`
-		Slug        string    `json:"slug"`
-		Content     string    `json:"content"`
-		Excerpt     string    `json:"excerpt"`
-		PublishedAt time.Time `json:"published_at"`
+		Title       string     `json:"title"`
+		Slug        string     `json:"slug"`
+		Content     string     `json:"content"`
+		Excerpt     string     `json:"excerpt"`
+		PublishedAt *time.Time `json:"published_at"`
+		Tags        []string   `json:"tags"`
+		Categories  []string   `json:"categories"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -30,27 +38,43 @@ func (app *application) createPostHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	user := app.contextGetUser(r)
+
 	post := &data.Post{
 		Title:       input.Title,
 		Slug:        input.Slug,
 		Content:     input.Content,
 		Excerpt:     input.Excerpt,
+		Status:      data.PostStatusDraft,
 		PublishedAt: input.PublishedAt,
-	}
-
-	if post.PublishedAt.IsZero() {
-		post.PublishedAt = time.Now()
+		AuthorID:    &user.ID,
+		Author:      user.Profile(),
+		Tags:        input.Tags,
+		Categories:  input.Categories,
 	}
 
 	v := validator.New()
-	if data.ValidatePost(v, post); !v.Valid() {
+	if data.ValidatePost(v, post); v.Valid() {
+		err = app.checkPostCategories(v, post)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
 	err = app.models.Posts.Insert(post)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", "a post with this slug already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -81,6 +105,22 @@ func (app *application) showPostHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	visible, err := app.userCanViewPost(r, post)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !visible {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.renderPosts(r, post)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"post": post}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -105,6 +145,22 @@ func (app *application) showPostBySlugHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	visible, err := app.userCanViewPost(r, post)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !visible {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.renderPosts(r, post)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"post": post}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -129,12 +185,34 @@ func (app *application) updatePostHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	allowed, err := app.userCanManagePost(r, post)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !allowed {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	matched, err := app.postMatches(r, id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !matched {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
 	var input struct {
 		Title       *string    `json:"title"`
 		Slug        *string    `json:"slug"`
 		Content     *string    `json:"content"`
 		Excerpt     *string    `json:"excerpt"`
 		PublishedAt *time.Time `json:"published_at"`
+		Tags        []string   `json:"tags"`
+		Categories  []string   `json:"categories"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -143,6 +221,21 @@ func (app *application) updatePostHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// Once a post is scheduled or published, when it goes out is part of the workflow,
+	// so only users who may publish posts can change it.
+	scheduled := post.Status == data.PostStatusScheduled || post.Status == data.PostStatusPublished
+	if input.PublishedAt != nil && scheduled {
+		permissions, err := app.userPermissions(r)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !permissions.Include("posts:publish") {
+			app.notPermittedResponse(w, r)
+			return
+		}
+	}
+
 	if input.Title != nil {
 		post.Title = *input.Title
 	}
@@ -156,26 +249,56 @@ func (app *application) updatePostHandler(w http.ResponseWriter, r *http.Request
 		post.Excerpt = *input.Excerpt
 	}
 	if input.PublishedAt != nil {
-		post.PublishedAt = *input.PublishedAt
+		post.PublishedAt = input.PublishedAt
+	}
+	if input.Tags != nil {
+		post.Tags = input.Tags
+	}
+	if input.Categories != nil {
+		post.Categories = input.Categories
 	}
 
 	v := validator.New()
-	if data.ValidatePost(v, post); !v.Valid() {
+	if input.PublishedAt != nil {
+		// Moving the date mustn't publish a scheduled post or hide a published one;
+		// that's what the publish and unpublish actions are for.
+		switch post.Status {
+		case data.PostStatusScheduled:
+			v.Check(input.PublishedAt.After(time.Now()), "published_at", "must be in the future for a scheduled post")
+		case data.PostStatusPublished:
+			v.Check(!input.PublishedAt.After(time.Now()), "published_at", "must not be in the future for a published post")
+		}
+	}
+	if data.ValidatePost(v, post); v.Valid() {
+		err = app.checkPostCategories(v, post)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Posts.Update(post)
+	err = app.models.Posts.Update(post, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", "a post with this slug already exists")
+			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
+	app.emitPostUpdated(post)
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"post": post}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -189,7 +312,7 @@ func (app *application) deletePostHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.models.Posts.Delete(id)
+	post, err := app.models.Posts.Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -200,206 +323,257 @@ func (app *application) deletePostHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "post successfully deleted"}, nil)
+	allowed, err := app.userCanManagePost(r, post)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
 	}
-}
-
-func (app *application) listPostsHandler(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		Title string
-		data.Filters
-	}
-
-	v := validator.New()
-	qs := r.URL.Query()
-
-	input.Title = app.readString(qs, "title", "")
-	input.Filters.Page = app.readInt(qs, "page", 1, v)
-	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
-	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "title", "published_at", "-id", "-title", "-published_at"}
-
-	if data.ValidateFilters(v, input.Filters); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+	if !allowed {
+		app.notPermittedResponse(w, r)
 		return
 	}
 
-	posts, metadata, err := app.models.Posts.GetAll(input.Title, input.Filters)
+	// The post's images stay in the media library; only their attachments go.
+	err = app.models.Posts.Delete(id)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"posts": posts, "metadata": metadata}, nil)
+	app.emitPostDeleted(post)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "post successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-func (app *application) uploadPostImageHandler(w http.ResponseWriter, r *http.Request) {
-	postID, err := app.readIDParam(r)
-	if err != nil {
-		app.notFoundResponse(w, r)
-		return
+// postMatches reports whether the request's If-Match header, if it has one, matches
+// the post as GET /v1/posts/:id would return it now without format or include, so
+// that the outcome doesn't depend on the query string of the update.
+func (app *application) postMatches(r *http.Request, id int64) (bool, error) {
+	if r.Header.Get("If-Match") == "" {
+		return true, nil
 	}
 
-	// Check if post exists
-	_, err = app.models.Posts.Get(postID)
+	post, err := app.models.Posts.GetWithImages(id)
 	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return false, nil
 		}
-		return
+		return false, err
 	}
 
-	// Parse multipart form (10MB max)
-	err = r.ParseMultipartForm(10 << 20)
-	if err != nil {
-		app.badRequestResponse(w, r, fmt.Errorf("failed to parse multipart form: %v", err))
-		return
+	app.setPostSrcsets(post)
+
+	return app.checkIfMatch(r, envelope{"post": post})
+}
+
+// userCanManagePost reports whether the user making the request wrote the post, or
+// holds the posts:manage_all permission which lets editors change anyone's posts.
+func (app *application) userCanManagePost(r *http.Request, post *data.Post) (bool, error) {
+	user := app.contextGetUser(r)
+	if post.OwnedBy(user.ID) {
+		return true, nil
 	}
 
-	file, header, err := r.FormFile("image")
+	permissions, err := app.userPermissions(r)
 	if err != nil {
-		app.badRequestResponse(w, r, fmt.Errorf("failed to get image file: %v", err))
-		return
+		return false, err
 	}
-	defer file.Close()
-
-	// Get optional metadata
-	altText := r.FormValue("alt_text")
-	caption := r.FormValue("caption")
-	isFeatured := r.FormValue("is_featured") == "true"
-	sortOrder, _ := strconv.Atoi(r.FormValue("sort_order"))
-
-	// Validate file
-	v := validator.New()
-	v.Check(header.Size <= 10*1024*1024, "image", "must not be larger than 10MB")
-	v.Check(header.Size > 0, "image", "must not be empty")
+	return permissions.Include("posts:manage_all"), nil
+}
 
-	ext := strings.ToLower(filepath.Ext(header.Filename))
-	v.Check(validator.PermittedValue(ext, ".jpg", ".jpeg", ".png", ".gif", ".webp"), "image", "must be a valid image format")
+// hideImageMetadata clears what was kept of the images' EXIF unless the user making
+// the request can write posts. The capture date and camera are for editors, not readers.
+func (app *application) hideImageMetadata(r *http.Request, images ...*data.Image) error {
+	permissions, err := app.userPermissions(r)
+	if err != nil {
+		return err
+	}
+	if permissions.Include("posts:write") {
+		return nil
+	}
 
-	if !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
-		return
+	for _, image := range images {
+		image.Metadata = nil
 	}
+	return nil
+}
 
-	// Generate unique filename
-	filename := fmt.Sprintf("%d_%d%s", postID, time.Now().Unix(), ext)
-	filePath := fmt.Sprintf("uploads/images/%s", filename)
+// userCanManageImage reports whether the user making the request uploaded the image,
+// or holds the posts:manage_all permission which lets editors change anyone's media.
+func (app *application) userCanManageImage(r *http.Request, image *data.Image) (bool, error) {
+	user := app.contextGetUser(r)
+	if image.OwnedBy(user.ID) {
+		return true, nil
+	}
 
-	// Ensure directory exists
-	err = os.MkdirAll("uploads/images", 0755)
+	permissions, err := app.userPermissions(r)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
+		return false, err
 	}
+	return permissions.Include("posts:manage_all"), nil
+}
 
-	// Save file
-	dst, err := os.Create(filePath)
+// userIsEditor reports whether the user making the request can see posts in every
+// status, which is the case for anyone who can publish or manage all posts.
+func (app *application) userIsEditor(r *http.Request) (bool, error) {
+	permissions, err := app.userPermissions(r)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
+		return false, err
 	}
-	defer dst.Close()
+	return permissions.Include("posts:publish") || permissions.Include("posts:manage_all"), nil
+}
 
-	_, err = io.Copy(dst, file)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
+// userCanViewPost reports whether the user making the request may read the post.
+// Public posts are visible to everyone, anything else only to its author and editors.
+func (app *application) userCanViewPost(r *http.Request, post *data.Post) (bool, error) {
+	if post.IsPublic() || post.OwnedBy(app.contextGetUser(r).ID) {
+		return true, nil
 	}
+	return app.userIsEditor(r)
+}
 
-	// Create image record
-	image := &data.Image{
-		PostID:           postID,
-		Filename:         filename,
-		OriginalFilename: header.Filename,
-		FilePath:         filePath,
-		FileSize:         header.Size,
-		MimeType:         header.Header.Get("Content-Type"),
-		IsFeatured:       isFeatured,
-		SortOrder:        sortOrder,
+// checkPostCategories adds a validation error if any of the post's categories don't
+// exist. Unlike tags, categories have to be created before posts can be filed under
+// them.
+func (app *application) checkPostCategories(v *validator.Validator, post *data.Post) error {
+	if len(post.Categories) == 0 {
+		return nil
 	}
 
-	if altText != "" {
-		image.AltText = &altText
+	missing, err := app.models.Categories.MissingSlugs(post.Categories)
+	if err != nil {
+		return err
 	}
-	if caption != "" {
-		image.Caption = &caption
+	if len(missing) > 0 {
+		v.AddError("categories", fmt.Sprintf("unknown category %q", missing[0]))
 	}
+	return nil
+}
 
-	// Validate image data
-	if data.ValidateImage(v, image); !v.Valid() {
-		os.Remove(filePath) // Clean up file on validation error
-		app.failedValidationResponse(w, r, v.Errors)
-		return
+// wantsHTML reports whether the client asked for rendered post content, either with
+// ?format=html or by listing content_html in ?include=.
+func (app *application) wantsHTML(r *http.Request) bool {
+	qs := r.URL.Query()
+	if app.readString(qs, "format", "") == "html" {
+		return true
 	}
+	return validator.PermittedValue("content_html", app.readCSV(qs, "include", nil)...)
+}
 
-	// If this is set as featured, remove featured flag from other images
-	if isFeatured {
-		err = app.models.Images.SetFeatured(postID, 0) // This will clear all featured flags first
-		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
-			os.Remove(filePath)
-			app.serverErrorResponse(w, r, err)
-			return
+// renderPosts fills in the rendered HTML and table of contents of each post if the
+// client asked for them.
+func (app *application) renderPosts(r *http.Request, posts ...*data.Post) error {
+	if !app.wantsHTML(r) {
+		return nil
+	}
+
+	for _, post := range posts {
+		err := app.renderPost(post)
+		if err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	err = app.models.Images.Insert(image)
+// renderPost fills in the rendered HTML and table of contents of the post.
+func (app *application) renderPost(post *data.Post) error {
+	doc, err := app.markdown.Render(fmt.Sprintf("%d:%d", post.ID, post.Version), post.Content)
 	if err != nil {
-		os.Remove(filePath) // Clean up file on database error
-		app.serverErrorResponse(w, r, err)
+		return err
+	}
+	post.ContentHTML = doc.HTML
+	post.TOC = doc.TOC
+	return nil
+}
+
+// readPostStatuses reads the comma-separated status filter from the query string.
+func (app *application) readPostStatuses(qs url.Values, v *validator.Validator) []string {
+	statuses := app.readCSV(qs, "status", nil)
+	for _, status := range statuses {
+		v.Check(validator.PermittedValue(status, data.PostStatuses...), "status", "invalid status value")
+	}
+	return statuses
+}
+
+func (app *application) listPostsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.PostQuery
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Title = app.readString(qs, "title", "")
+	input.Tag = app.readString(qs, "tag", "")
+	input.Category = app.readString(qs, "category", "")
+	input.Statuses = app.readPostStatuses(qs, v)
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "title", "published_at", "-id", "-title", "-published_at"}
+	app.readCursor(qs, &input.Filters, v)
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	// If this image was set as featured, update the flag now that we have the ID
-	if isFeatured {
-		err = app.models.Images.SetFeatured(postID, image.ID)
+	// Only editors may list posts which aren't public yet.
+	if len(input.Statuses) > 0 {
+		allowed, err := app.userIsEditor(r)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
+		if !allowed {
+			app.notPermittedResponse(w, r)
+			return
+		}
 	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"image": image}, nil)
+	posts, metadata, err := app.models.Posts.GetAll(input.PostQuery, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
 	}
-}
 
-func (app *application) getPostImagesHandler(w http.ResponseWriter, r *http.Request) {
-	postID, err := app.readIDParam(r)
+	err = app.renderPosts(r, posts...)
 	if err != nil {
-		app.notFoundResponse(w, r)
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	images, err := app.models.Images.GetByPostID(postID)
+	err = app.encodeCursors(&metadata)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"images": images}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"posts": posts, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-func (app *application) updateImageHandler(w http.ResponseWriter, r *http.Request) {
-	imageID, err := app.readIDParam(r)
+func (app *application) uploadPostImageHandler(w http.ResponseWriter, r *http.Request) {
+	postID, err := app.readIDParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
 		return
 	}
 
-	image, err := app.models.Images.Get(imageID)
+	// Check if post exists
+	post, err := app.models.Posts.Get(postID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -410,68 +584,475 @@ func (app *application) updateImageHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	var input struct {
-		AltText    *string `json:"alt_text"`
-		Caption    *string `json:"caption"`
-		IsFeatured *bool   `json:"is_featured"`
-		SortOrder  *int    `json:"sort_order"`
-	}
-
-	err = app.readJSON(w, r, &input)
+	allowed, err := app.userCanManagePost(r, post)
 	if err != nil {
-		app.badRequestResponse(w, r, err)
+		app.serverErrorResponse(w, r, err)
 		return
 	}
-
-	if input.AltText != nil {
-		image.AltText = input.AltText
-	}
-	if input.Caption != nil {
-		image.Caption = input.Caption
-	}
-	if input.SortOrder != nil {
-		image.SortOrder = *input.SortOrder
+	if !allowed {
+		app.notPermittedResponse(w, r)
+		return
 	}
 
-	// Handle featured image change
-	if input.IsFeatured != nil && *input.IsFeatured != image.IsFeatured {
-		if *input.IsFeatured {
-			// Set this as featured image
-			err = app.models.Images.SetFeatured(image.PostID, image.ID)
-			if err != nil {
-				app.serverErrorResponse(w, r, err)
-				return
-			}
-			image.IsFeatured = true
-		} else {
-			// Remove featured flag
-			image.IsFeatured = false
-		}
+	upload, ok := app.readImageUpload(w, r)
+	if !ok {
+		return
 	}
+	upload.PostID = postID
 
 	v := validator.New()
-	if data.ValidateImage(v, image); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+	image, err := app.saveImage(r.Context(), v, *upload)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 		return
 	}
-
-	err = app.models.Images.Update(image)
-	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrEditConflict):
-			app.editConflictResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"image": image}, nil)
+	app.setImageSrcsets(image)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"image": image}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// readImageUpload reads an image uploaded as the "image" field of a multipart form,
+// with its alt_text, caption, is_featured and sort_order fields, on behalf of the user
+// making the request. If it writes an error response it returns false.
+func (app *application) readImageUpload(w http.ResponseWriter, r *http.Request) (*imageUpload, bool) {
+	// Parse multipart form (10MB max)
+	err := r.ParseMultipartForm(10 << 20)
+	if err != nil {
+		app.badRequestResponse(w, r, fmt.Errorf("failed to parse multipart form: %v", err))
+		return nil, false
+	}
+
+	file, header, err := r.FormFile("image")
+	if err != nil {
+		app.badRequestResponse(w, r, fmt.Errorf("failed to get image file: %v", err))
+		return nil, false
+	}
+	defer file.Close()
+
+	// Validate file
+	v := validator.New()
+	v.Check(header.Size <= maxImageSize, "image", "must not be larger than 10MB")
+	v.Check(header.Size > 0, "image", "must not be empty")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	content, err := io.ReadAll(io.LimitReader(file, maxImageSize))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	// Get optional metadata
+	sortOrder, _ := strconv.Atoi(r.FormValue("sort_order"))
+
+	return &imageUpload{
+		UserID:     app.contextGetUser(r).ID,
+		Filename:   header.Filename,
+		Content:    content,
+		AltText:    r.FormValue("alt_text"),
+		Caption:    r.FormValue("caption"),
+		IsFeatured: r.FormValue("is_featured") == "true",
+		SortOrder:  sortOrder,
+	}, true
+}
+
+// imageUpload is an image file uploaded by a user, along with the details given for
+// it. PostID is the post it's uploaded to, or zero for an upload to the media library.
+type imageUpload struct {
+	UserID     int64
+	PostID     int64
+	Filename   string
+	Content    []byte
+	AltText    string
+	Caption    string
+	IsFeatured bool
+	SortOrder  int
+}
+
+// saveImage checks an uploaded image and adds it to the media library, attached to its
+// post if it has one, storing the file unless the same content has been uploaded
+// before. Problems with the upload are added to v, in which case nothing is saved and
+// the returned image is nil.
+func (app *application) saveImage(ctx context.Context, v *validator.Validator, upload imageUpload) (*data.Image, error) {
+	v.Check(len(upload.Content) <= maxImageSize, "image", "must not be larger than 10MB")
+	v.Check(len(upload.Content) > 0, "image", "must not be empty")
+
+	ext := strings.ToLower(filepath.Ext(upload.Filename))
+	v.Check(validator.PermittedValue(ext, ".jpg", ".jpeg", ".png", ".gif", ".webp"), "image", "must be a valid image format")
+
+	if !v.Valid() {
+		return nil, nil
+	}
+
+	// Work out what the file really is from its contents, rather than trusting the
+	// extension and Content-Type the client sent.
+	info, err := imaging.Inspect(upload.Content, ext)
+	if err != nil {
+		switch {
+		case errors.Is(err, imaging.ErrUnsupportedFormat):
+			v.AddError("image", "must be a valid image format")
+		case errors.Is(err, imaging.ErrFormatMismatch):
+			v.AddError("image", "file contents do not match its extension")
+		case errors.Is(err, imaging.ErrPolyglot):
+			v.AddError("image", "must not contain anything but the image")
+		case errors.Is(err, imaging.ErrTooLarge):
+			v.AddError("image", "must not be larger than 50 megapixels")
+		default:
+			v.AddError("image", "could not be decoded")
+		}
+		return nil, nil
+	}
+
+	// Strip the EXIF and other metadata, which can give away where a photo was taken,
+	// keeping only the capture date and camera.
+	content, metadata, err := imaging.Clean(upload.Content, info)
+	if err != nil {
+		if errors.Is(err, imaging.ErrCorrupt) {
+			v.AddError("image", "could not be decoded")
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	// Work out placeholders for clients to show until the image has loaded.
+	src, err := imaging.Decode(bytes.NewReader(content))
+	if err != nil {
+		v.AddError("image", "could not be decoded")
+		return nil, nil
+	}
+	blurHash, lqip, err := imaging.Placeholders(src, info.MimeType)
+	if err != nil {
+		return nil, err
+	}
+
+	// Uploads are stored once per distinct content, named after its SHA-256.
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	filename := hash + imaging.Extensions[info.MimeType][0]
+	filePath := path.Join(imageDir, filename)
+
+	// Create image record
+	image := &data.Image{
+		PostID:           upload.PostID,
+		Filename:         filename,
+		OriginalFilename: upload.Filename,
+		FilePath:         filePath,
+		FileSize:         int64(len(content)),
+		MimeType:         info.MimeType,
+		Width:            &info.Width,
+		Height:           &info.Height,
+		IsFeatured:       upload.IsFeatured,
+		SortOrder:        upload.SortOrder,
+		SHA256:           &hash,
+		UserID:           &upload.UserID,
+		Metadata:         metadata,
+		BlurHash:         &blurHash,
+		LQIP:             &lqip,
+	}
+
+	// Library uploads aren't in any post's gallery.
+	if upload.PostID == 0 {
+		image.IsFeatured = false
+		image.SortOrder = 0
+	}
+
+	if upload.AltText != "" {
+		image.AltText = &upload.AltText
+	}
+	if upload.Caption != "" {
+		image.Caption = &upload.Caption
+	}
+
+	// Validate image data
+	if data.ValidateImage(v, image); !v.Valid() {
+		return nil, nil
+	}
+
+	// The file is only stored if the same content hasn't been uploaded before. If the
+	// upload fails after storing it, the garbage collector removes it as an orphan.
+	stored := false
+	err = app.models.Images.Insert(image, func() error {
+		stored = true
+		return app.storage.Put(ctx, filePath, bytes.NewReader(content), int64(len(content)), info.MimeType)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Content seen before already has its variants.
+	if stored {
+		app.background(func() {
+			app.generateVariants(context.Background(), image)
+		})
+	}
+
+	return image, nil
+}
+
+func (app *application) getPostImagesHandler(w http.ResponseWriter, r *http.Request) {
+	postID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	post, err := app.models.Posts.Get(postID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	visible, err := app.userCanViewPost(r, post)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !visible {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	var filters data.Filters
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "sort_order")
+	filters.SortSafelist = []string{"sort_order", "created_at", "id", "-sort_order", "-created_at", "-id"}
+	app.readCursor(qs, &filters, v)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Without any paging parameters every image is returned, as before paging existed.
+	if !filters.UsesCursor() && qs.Get("page") == "" && qs.Get("page_size") == "" {
+		images, err := app.models.Images.GetByPostID(postID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.hideImageMetadata(r, images...)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.setImageSrcsets(images...)
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"images": images}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	images, metadata, err := app.models.Images.GetPageByPostID(postID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.encodeCursors(&metadata)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.hideImageMetadata(r, images...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.setImageSrcsets(images...)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"images": images, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateImageHandler(w http.ResponseWriter, r *http.Request) {
+	imageID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	image, err := app.models.Images.Get(imageID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// If-Match is checked against the image as GET /v1/media/:id returns it.
+	app.setImageSrcsets(image)
+	matched, err := app.checkIfMatch(r, envelope{"image": image})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !matched {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		AltText *string `json:"alt_text"`
+		Caption *string `json:"caption"`
+		// PostID is the post is_featured and sort_order apply to. It can be left out if
+		// the image is attached to only one post.
+		PostID     *int64 `json:"post_id"`
+		IsFeatured *bool  `json:"is_featured"`
+		SortOrder  *int   `json:"sort_order"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	// The image's place in a post is up to whoever can manage the post, while the
+	// image itself belongs to whoever uploaded it.
+	if input.IsFeatured != nil || input.SortOrder != nil {
+		var postID int64
+		switch {
+		case input.PostID != nil:
+			postID = *input.PostID
+		case len(image.PostIDs) == 1:
+			postID = image.PostIDs[0]
+		}
+
+		post, attached, err := app.readAttachment(postID, image)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if attached == nil {
+			v.AddError("post_id", "must be a post the image is attached to")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		allowed, err := app.userCanManagePost(r, post)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !allowed {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		attached.PostIDs = image.PostIDs
+		image = attached
+	}
+
+	if input.AltText != nil || input.Caption != nil || image.PostID == 0 {
+		allowed, err := app.userCanManageImage(r, image)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !allowed {
+			app.notPermittedResponse(w, r)
+			return
+		}
+	}
+
+	if input.AltText != nil {
+		image.AltText = input.AltText
+	}
+	if input.Caption != nil {
+		image.Caption = input.Caption
+	}
+	if input.SortOrder != nil {
+		image.SortOrder = *input.SortOrder
+	}
+
+	// Update un-features the post's other images along with saving this one.
+	if input.IsFeatured != nil {
+		image.IsFeatured = *input.IsFeatured
+	}
+
+	if data.ValidateImage(v, image); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Images.Update(image)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.setImageSrcsets(image)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"image": image}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readAttachment returns a post and the image as one of the post's, or a nil image if
+// either the post doesn't exist or the image isn't attached to it.
+func (app *application) readAttachment(postID int64, image *data.Image) (*data.Post, *data.Image, error) {
+	post, err := app.models.Posts.Get(postID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	attached, err := app.models.Images.GetForPost(postID, image.ID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	return post, attached, nil
+}
+
+// deleteImageHandler deletes an image from the media library, and so from every post
+// it's attached to.
 func (app *application) deleteImageHandler(w http.ResponseWriter, r *http.Request) {
 	imageID, err := app.readIDParam(r)
 	if err != nil {
@@ -490,6 +1071,16 @@ func (app *application) deleteImageHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	allowed, err := app.userCanManageImage(r, image)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !allowed {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
 	// Delete from database first
 	err = app.models.Images.Delete(imageID)
 	if err != nil {
@@ -502,18 +1093,9 @@ func (app *application) deleteImageHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Delete file from filesystem
-	err = os.Remove(image.FilePath)
-	if err != nil && !os.IsNotExist(err) {
-		// Log error but don't fail the request since database deletion succeeded
-		ctx := r.Context()
-		app.logger.Error(ctx, "failed to delete image file from filesystem",
-			"error", err.Error(),
-			"image_id", imageID,
-			"file_path", image.FilePath,
-			"operation", "delete_image_file",
-		)
-	}
+	// Delete the file from storage if no other image shares it. Errors are logged but
+	// don't fail the request since database deletion succeeded.
+	app.releaseBlobs(image.BlobID)
 
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": "image successfully deleted"}, nil)
 	if err != nil {
@@ -540,18 +1122,46 @@ func (app *application) serveImageHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Check if file exists
-	if _, err := os.Stat(image.FilePath); os.IsNotExist(err) {
-		app.notFoundResponse(w, r)
-		return
-	}
+	filePath, mimeType := image.FilePath, image.MimeType
+
+	// ?w= and ?fmt= ask for a resized or converted copy of the image.
+	qs := r.URL.Query()
+	if qs.Has("w") || qs.Has("fmt") {
+		v := validator.New()
 
-	// Set headers
-	w.Header().Set("Content-Type", image.MimeType)
-	w.Header().Set("Cache-Control", "public, max-age=31536000") // Cache for 1 year
+		// Without w, a converted copy is as wide as the image, up to the maximum.
+		width := maxVariantWidth
+		if image.Width != nil && *image.Width < width {
+			width = *image.Width
+		}
+		width = app.readInt(qs, "w", width, v)
+		format := app.readString(qs, "fmt", "")
+
+		v.Check(width > 0, "w", "must be greater than zero")
+		v.Check(width <= maxVariantWidth, "w", fmt.Sprintf("must be a maximum of %d", maxVariantWidth))
+		variantType := imaging.OutputType(image.MimeType)
+		if format != "" {
+			var ok bool
+			variantType, ok = imaging.Formats[format]
+			v.Check(ok, "fmt", "must be one of jpeg or png")
+		}
+
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		variant, err := app.resizedImage(r.Context(), image, width, variantType)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if variant != nil {
+			filePath, mimeType = variant.FilePath, variant.MimeType
+		}
+	}
 
-	// Serve file
-	http.ServeFile(w, r, image.FilePath)
+	app.serveStoredFile(w, r, filePath, mimeType)
 }
 
 func (app *application) setFeaturedImageHandler(w http.ResponseWriter, r *http.Request) {
@@ -561,6 +1171,27 @@ func (app *application) setFeaturedImageHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	post, err := app.models.Posts.Get(postID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	allowed, err := app.userCanManagePost(r, post)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !allowed {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
 	var input struct {
 		ImageID int64 `json:"image_id"`
 	}
@@ -571,7 +1202,8 @@ func (app *application) setFeaturedImageHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	// Validate that the image belongs to the post
+	// Any image in the media library can be featured, and is attached to the post if
+	// it isn't already.
 	image, err := app.models.Images.Get(input.ImageID)
 	if err != nil {
 		switch {
@@ -583,18 +1215,265 @@ func (app *application) setFeaturedImageHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	if image.PostID != postID {
-		app.badRequestResponse(w, r, fmt.Errorf("image does not belong to this post"))
+	image.PostID = postID
+	image.IsFeatured = true
+	err = app.models.Images.Attach(image)
+	if errors.Is(err, data.ErrAlreadyAttached) {
+		err = app.models.Images.SetFeatured(postID, image.ID)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.models.Images.SetFeatured(postID, input.ImageID)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "featured image updated successfully"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// imageItemError reports what was wrong with one of the images in a bulk request.
+type imageItemError struct {
+	Index  int               `json:"index"`
+	ID     int64             `json:"id"`
+	Errors map[string]string `json:"errors"`
+}
+
+// reorderPostImagesHandler puts a post's gallery in the order of the image ids given,
+// all at once.
+func (app *application) reorderPostImagesHandler(w http.ResponseWriter, r *http.Request) {
+	postID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "featured image updated successfully"}, nil)
+	post, err := app.models.Posts.Get(postID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	allowed, err := app.userCanManagePost(r, post)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !allowed {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		ImageIDs []int64 `json:"image_ids"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	images, err := app.models.Images.GetByPostID(postID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	inPost := make(map[int64]bool, len(images))
+	for _, image := range images {
+		inPost[image.ID] = true
+	}
+
+	v := validator.New()
+	v.Check(input.ImageIDs != nil, "image_ids", "must be provided")
+	v.Check(validator.Unique(input.ImageIDs), "image_ids", "must not contain duplicate values")
+	for _, id := range input.ImageIDs {
+		v.Check(inPost[id], "image_ids", fmt.Sprintf("image %d does not belong to this post", id))
+	}
+	v.Check(len(input.ImageIDs) == len(images), "image_ids", "must list every image of the post")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	orders := make([]data.ImageOrder, len(input.ImageIDs))
+	for n, id := range input.ImageIDs {
+		orders[n] = data.ImageOrder{ID: id, Order: n}
+	}
+
+	err = app.models.Images.UpdateSortOrder(postID, orders)
+	if err != nil {
+		switch {
+		// An image was deleted or moved since the list was checked.
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	images, err = app.models.Images.GetByPostID(postID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.setImageSrcsets(images...)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"images": images}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updatePostImagesHandler updates the alt text and captions of several of a post's
+// images in one transaction. If any image can't be updated none are, and the response
+// lists the problem with each one that failed.
+func (app *application) updatePostImagesHandler(w http.ResponseWriter, r *http.Request) {
+	postID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	post, err := app.models.Posts.Get(postID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	allowed, err := app.userCanManagePost(r, post)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !allowed {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Images []struct {
+			ID      int64   `json:"id"`
+			AltText *string `json:"alt_text"`
+			Caption *string `json:"caption"`
+			// Version, if given, must match the image's current version.
+			Version *int32 `json:"version"`
+		} `json:"images"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.Images) > 0, "images", "must contain at least 1 image")
+	v.Check(len(input.Images) <= 100, "images", "must not contain more than 100 images")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	postImages, err := app.models.Images.GetByPostID(postID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	byID := make(map[int64]*data.Image, len(postImages))
+	for _, image := range postImages {
+		byID[image.ID] = image
+	}
+
+	// Alt text and captions belong to the image, wherever it's used, so they can only
+	// be changed by whoever uploaded it and editors.
+	permissions, err := app.userPermissions(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	user := app.contextGetUser(r)
+	manageAll := permissions.Include("posts:manage_all")
+
+	images := make([]*data.Image, len(input.Images))
+	itemErrors := []imageItemError{}
+	seen := make(map[int64]bool, len(input.Images))
+
+	for n, item := range input.Images {
+		v := validator.New()
+
+		image, ok := byID[item.ID]
+		v.Check(ok, "id", "must be an image of this post")
+		v.Check(!seen[item.ID], "id", "must not appear more than once")
+		seen[item.ID] = true
+
+		if ok && !manageAll && !image.OwnedBy(user.ID) {
+			v.AddError("id", "you do not have permission to edit this image")
+			ok = false
+		}
+
+		if ok {
+			if item.AltText != nil {
+				image.AltText = item.AltText
+			}
+			if item.Caption != nil {
+				image.Caption = item.Caption
+			}
+			if item.Version != nil {
+				image.Version = *item.Version
+			}
+			data.ValidateImage(v, image)
+			images[n] = image
+		}
+
+		if !v.Valid() {
+			itemErrors = append(itemErrors, imageItemError{Index: n, ID: item.ID, Errors: v.Errors})
+		}
+	}
+
+	if len(itemErrors) > 0 {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, envelope{"images": itemErrors})
+		return
+	}
+
+	errs, err := app.models.Images.UpdateMetadata(images)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for n, err := range errs {
+		if err != nil {
+			itemErrors = append(itemErrors, imageItemError{
+				Index:  n,
+				ID:     images[n].ID,
+				Errors: map[string]string{"version": "unable to update the image due to an edit conflict, please try again"},
+			})
+		}
+	}
+
+	if len(itemErrors) > 0 {
+		app.errorResponse(w, r, http.StatusConflict, envelope{"images": itemErrors})
+		return
+	}
+
+	app.setImageSrcsets(images...)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"images": images}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -619,7 +1498,24 @@ func (app *application) showPostWithImagesHandler(w http.ResponseWriter, r *http
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"post": post}, nil)
+	visible, err := app.userCanViewPost(r, post)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !visible {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.renderPosts(r, post)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.setPostSrcsets(post)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"post": post}, postLastModified(post))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -643,7 +1539,24 @@ func (app *application) showPostBySlugWithImagesHandler(w http.ResponseWriter, r
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"post": post}, nil)
+	visible, err := app.userCanViewPost(r, post)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !visible {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.renderPosts(r, post)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.setPostSrcsets(post)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"post": post}, postLastModified(post))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -657,7 +1570,7 @@ func (app *application) listPostsWithImagesHandler(w http.ResponseWriter, r *htt
 	)
 	
 	var input struct {
-		Title string
+		data.PostQuery
 		data.Filters
 	}
 
@@ -665,17 +1578,34 @@ func (app *application) listPostsWithImagesHandler(w http.ResponseWriter, r *htt
 	qs := r.URL.Query()
 
 	input.Title = app.readString(qs, "title", "")
+	input.Tag = app.readString(qs, "tag", "")
+	input.Category = app.readString(qs, "category", "")
+	input.Statuses = app.readPostStatuses(qs, v)
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
 	input.Filters.SortSafelist = []string{"id", "title", "published_at", "-id", "-title", "-published_at"}
+	app.readCursor(qs, &input.Filters, v)
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	posts, metadata, err := app.models.Posts.GetAllWithFeaturedImages(input.Title, input.Filters)
+	// Only editors may list posts which aren't public yet.
+	if len(input.Statuses) > 0 {
+		allowed, err := app.userIsEditor(r)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !allowed {
+			app.notPermittedResponse(w, r)
+			return
+		}
+	}
+
+	posts, metadata, err := app.models.Posts.GetAllWithFeaturedImages(input.PostQuery, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -687,6 +1617,19 @@ func (app *application) listPostsWithImagesHandler(w http.ResponseWriter, r *htt
 		"page_size", input.Filters.PageSize,
 	)
 
+	err = app.renderPosts(r, posts...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.setPostSrcsets(posts...)
+
+	err = app.encodeCursors(&metadata)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"posts": posts, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"blog/internal/data"
+	"blog/internal/data/validator"
+	"blog/internal/diff"
+)
+
+// revisionDiffContext is the number of unchanged lines shown around each change in a
+// revision diff.
+const revisionDiffContext = 3
+
+// postForRevisions fetches the post named in the URL and checks the user making the
+// request may see its history, which is limited to the people who can edit it and to
+// editors. It sends the error response itself and returns nil if anything is wrong.
+func (app *application) postForRevisions(w http.ResponseWriter, r *http.Request) *data.Post {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+
+	post, err := app.models.Posts.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+
+	allowed, err := app.userCanManagePost(r, post)
+	if err == nil && !allowed {
+		allowed, err = app.userIsEditor(r)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil
+	}
+	if !allowed {
+		app.notPermittedResponse(w, r)
+		return nil
+	}
+
+	return post
+}
+
+// readRevision fetches the revision of the post whose version is in the named URL
+// parameter, sending a 404 if there is no such revision.
+func (app *application) readRevision(w http.ResponseWriter, r *http.Request, post *data.Post, param string) *data.Revision {
+	version, err := app.readVersionParam(r, param)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+
+	revision, err := app.models.Revisions.Get(post.ID, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+
+	return revision
+}
+
+func (app *application) listPostRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	post := app.postForRevisions(w, r)
+	if post == nil {
+		return
+	}
+
+	revisions, err := app.models.Revisions.GetAllForPost(post.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"revisions": revisions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showPostRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	post := app.postForRevisions(w, r)
+	if post == nil {
+		return
+	}
+
+	revision := app.readRevision(w, r, post, "version")
+	if revision == nil {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"revision": revision}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// diffPostRevisionsHandler returns a unified diff of the content of two revisions of
+// a post, going from the first version in the URL to the second.
+func (app *application) diffPostRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	post := app.postForRevisions(w, r)
+	if post == nil {
+		return
+	}
+
+	from := app.readRevision(w, r, post, "version")
+	if from == nil {
+		return
+	}
+	to := app.readRevision(w, r, post, "other")
+	if to == nil {
+		return
+	}
+
+	unified := diff.Unified(
+		fmt.Sprintf("v%d", from.Version),
+		fmt.Sprintf("v%d", to.Version),
+		from.Content,
+		to.Content,
+		revisionDiffContext,
+	)
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"from": from.Version, "to": to.Version, "diff": unified}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// restorePostRevisionHandler puts the title, slug, content and excerpt of an earlier
+// revision back on the post. The restore is saved as a new version, so it can itself
+// be undone.
+func (app *application) restorePostRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	post, err := app.models.Posts.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	allowed, err := app.userCanManagePost(r, post)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !allowed {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	revision := app.readRevision(w, r, post, "version")
+	if revision == nil {
+		return
+	}
+
+	post.Title = revision.Title
+	post.Slug = revision.Slug
+	post.Content = revision.Content
+	post.Excerpt = revision.Excerpt
+
+	v := validator.New()
+	if data.ValidatePost(v, post); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Posts.Update(post, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", "a post with this slug already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.emitPostUpdated(post)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"post": post}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
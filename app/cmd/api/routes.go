@@ -21,21 +21,96 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodGet, "/v1/posts/:id", app.showPostWithImagesHandler)
 	router.HandlerFunc(http.MethodGet, "/v1/slug/:slug", app.showPostBySlugWithImagesHandler)
 	router.HandlerFunc(http.MethodGet, "/v1/images/:filename", app.serveImageHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/authors/:id/posts", app.listAuthorPostsHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/search", app.searchPostsHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/search/suggest", app.suggestHandler)
+
+	// Feed endpoints
+	router.HandlerFunc(http.MethodGet, "/feed.xml", app.cacheDocument(feedDocumentKey, app.rssFeedHandler))
+	router.HandlerFunc(http.MethodGet, "/atom.xml", app.cacheDocument(feedDocumentKey, app.atomFeedHandler))
+	router.HandlerFunc(http.MethodGet, "/feed.json", app.cacheDocument(feedDocumentKey, app.jsonFeedHandler))
+
+	// Crawler endpoints
+	router.HandlerFunc(http.MethodGet, "/sitemap.xml", app.cacheDocument(sitemapDocumentKey, app.sitemapHandler))
+	router.HandlerFunc(http.MethodGet, "/robots.txt", app.robotsHandler)
 
 	// Post management endpoints
-	router.HandlerFunc(http.MethodPost, "/v1/posts", app.createPostHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/posts/:id", app.updatePostHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/posts/:id", app.deletePostHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/posts", app.requirePermission("posts:write", app.createPostHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/posts/:id", app.requirePermission("posts:write", app.updatePostHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/posts/:id", app.requirePermission("posts:write", app.deletePostHandler))
+
+	// Post workflow endpoints
+	router.HandlerFunc(http.MethodPost, "/v1/posts/:id/submit", app.requirePermission("posts:write", app.submitPostHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/posts/:id/publish", app.requirePermission("posts:publish", app.publishPostHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/posts/:id/schedule", app.requirePermission("posts:publish", app.schedulePostHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/posts/:id/unpublish", app.requirePermission("posts:publish", app.unpublishPostHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/posts/:id/archive", app.requirePermission("posts:publish", app.archivePostHandler))
+
+	// Post revision endpoints
+	router.HandlerFunc(http.MethodGet, "/v1/posts/:id/revisions", app.requirePermission("posts:write", app.listPostRevisionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/posts/:id/revisions/:version", app.requirePermission("posts:write", app.showPostRevisionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/posts/:id/revisions/:version/diff/:other", app.requirePermission("posts:write", app.diffPostRevisionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/posts/:id/revisions/:version/restore", app.requirePermission("posts:write", app.restorePostRevisionHandler))
 
 	// Image management endpoints
-	router.HandlerFunc(http.MethodPost, "/v1/posts/:id/images", app.uploadPostImageHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/posts/:id/images", app.requirePermission("posts:write", app.uploadPostImageHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/posts/:id/images", app.getPostImagesHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/images/:id", app.updateImageHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/images/:id", app.deleteImageHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/posts/:id/featured-image", app.setFeaturedImageHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/posts/:id/images", app.requirePermission("posts:write", app.updatePostImagesHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/posts/:id/images/order", app.requirePermission("posts:write", app.reorderPostImagesHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/images/:id", app.requirePermission("posts:write", app.updateImageHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/images/:id", app.requirePermission("posts:write", app.deleteImageHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/posts/:id/featured-image", app.requirePermission("posts:write", app.setFeaturedImageHandler))
+
+	// Media library endpoints
+	router.HandlerFunc(http.MethodGet, "/v1/media", app.requirePermission("posts:write", app.listMediaHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/media", app.requirePermission("posts:write", app.createMediaHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/media/:id", app.requirePermission("posts:write", app.showMediaHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/posts/:id/media", app.requirePermission("posts:write", app.attachMediaHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/posts/:id/media/:image_id", app.requirePermission("posts:write", app.detachMediaHandler))
+
+	// Resumable upload endpoints (tus protocol)
+	router.HandlerFunc(http.MethodOptions, "/v1/uploads", app.tusResumable(app.uploadOptionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/uploads", app.tusResumable(app.requirePermission("posts:write", app.createUploadHandler)))
+	router.HandlerFunc(http.MethodHead, "/v1/uploads/:id", app.tusResumable(app.requirePermission("posts:write", app.headUploadHandler)))
+	router.HandlerFunc(http.MethodPatch, "/v1/uploads/:id", app.tusResumable(app.requirePermission("posts:write", app.patchUploadHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/uploads/:id", app.tusResumable(app.requirePermission("posts:write", app.deleteUploadHandler)))
+
+	// Comment endpoints
+	router.HandlerFunc(http.MethodGet, "/v1/posts/:id/comments", app.listPostCommentsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/posts/:id/comments", app.rateLimitComments(app.createCommentHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/comments", app.requirePermission("comments:moderate", app.listCommentsHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/comments/:id", app.requirePermission("comments:moderate", app.moderateCommentHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/comments/moderate", app.requirePermission("comments:moderate", app.bulkModerateCommentsHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/forms/token", app.formTokenHandler)
+
+	// Taxonomy endpoints
+	router.HandlerFunc(http.MethodGet, "/v1/tags", app.listTagsHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/tags/:slug", app.showTagHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/tags/:slug/posts", app.listTagPostsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tags", app.requirePermission("posts:manage_all", app.createTagHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/tags/:slug", app.requirePermission("posts:manage_all", app.updateTagHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/tags/:slug", app.requirePermission("posts:manage_all", app.deleteTagHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/categories", app.listCategoriesHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/categories/:slug", app.showCategoryHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/categories/:slug/posts", app.listCategoryPostsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/categories", app.requirePermission("posts:manage_all", app.createCategoryHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/categories/:slug", app.requirePermission("posts:manage_all", app.updateCategoryHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/categories/:slug", app.requirePermission("posts:manage_all", app.deleteCategoryHandler))
+
+	// User endpoints
+	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/avatar", app.requireActivatedUser(app.updateUserAvatarHandler))
+
+	// Token endpoints
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
 
 	// Debug endpoint
 	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
 
-	return app.recoverPanic(app.enableCORS(app.rateLimit(router)))
+	return app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(app.conditionalGET(router)))))
 }
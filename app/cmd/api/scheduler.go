@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// schedulerBatchSize caps how many posts a single scheduler tick publishes.
+const schedulerBatchSize = 50
+
+// startScheduler launches the worker which publishes scheduled posts once their
+// published_at passes. It runs until app.done is closed during shutdown.
+func (app *application) startScheduler() {
+	app.background(func() {
+		ticker := time.NewTicker(app.config.scheduler.interval)
+		defer ticker.Stop()
+
+		app.logger.Info(context.Background(), "scheduler started",
+			"interval", app.config.scheduler.interval.String(),
+		)
+
+		for {
+			app.publishDuePosts()
+
+			select {
+			case <-app.done:
+				app.logger.Info(context.Background(), "scheduler stopped")
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// publishDuePosts claims every due scheduled post, in batches, and emits a
+// post.published event for each one.
+func (app *application) publishDuePosts() {
+	for {
+		posts, err := app.models.Posts.ClaimDueScheduled(schedulerBatchSize)
+		if err != nil {
+			app.logger.Error(context.Background(), "failed to claim scheduled posts",
+				"error", err.Error(),
+			)
+			return
+		}
+
+		for _, post := range posts {
+			app.emitPostPublished(post)
+		}
+
+		if len(posts) < schedulerBatchSize {
+			return
+		}
+	}
+}
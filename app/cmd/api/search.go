@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+
+	"blog/internal/data"
+	"blog/internal/data/validator"
+)
+
+// didYouMeanLimit is the number of alternatives offered for a search with no results.
+const didYouMeanLimit = 3
+
+func (app *application) searchPostsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.SearchQuery
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Text = app.readString(qs, "q", "")
+	input.Language = app.readString(qs, "lang", data.SearchLanguage)
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	// Results are always ordered by rank.
+	input.Filters.Sort = "rank"
+	input.Filters.SortSafelist = []string{"rank"}
+
+	data.ValidateSearchQuery(v, input.SearchQuery)
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	results, metadata, err := app.models.Posts.Search(input.SearchQuery, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	posts := make([]*data.Post, len(results))
+	for i, result := range results {
+		posts[i] = result.Post
+	}
+	err = app.renderPosts(r, posts...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"results": results, "metadata": metadata}
+
+	// When nothing matches, offer titles and tags which look like what was typed in
+	// case it was misspelled.
+	if len(results) == 0 && input.Filters.Page == 1 {
+		didYouMean, err := app.models.Suggest.DidYouMean(input.Text, didYouMeanLimit)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		env["did_you_mean"] = didYouMean
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// suggestHandler returns post titles, tags and authors resembling the start of a
+// search, for autocompletion as the reader types.
+func (app *application) suggestHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	text := app.readString(qs, "q", "")
+	limit := app.readInt(qs, "limit", 5, v)
+
+	if data.ValidateSuggestQuery(v, text, limit); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suggestions, err := app.models.Suggest.Get(text, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"suggestions": suggestions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
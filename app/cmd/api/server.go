@@ -44,10 +44,19 @@ func (app *application) serve() error {
 			"addr", srv.Addr,
 		)
 		
+		close(app.done)
 		app.wg.Wait()
 		shutdownError <- nil
 	}()
 
+	if app.config.scheduler.enabled {
+		app.startScheduler()
+	}
+
+	if app.config.gc.enabled {
+		app.startGC()
+	}
+
 	app.logger.Info(context.Background(), "TECHNOPRISE server starting",
 		"addr", srv.Addr,
 		"env", app.config.env,
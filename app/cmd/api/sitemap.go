@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"blog/internal/data"
+	"blog/internal/data/validator"
+)
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	ImageNS string       `xml:"xmlns:image,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string         `xml:"loc"`
+	LastMod string         `xml:"lastmod,omitempty"`
+	Images  []sitemapImage `xml:"image:image"`
+}
+
+type sitemapImage struct {
+	Loc string `xml:"image:loc"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 sitemapindex"`
+	Sitemaps []sitemapRef `xml:"sitemap"`
+}
+
+type sitemapRef struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// sitemapDocumentKey keys the document cache on the sitemap r asks for by its page
+// alone. Requests sitemapHandler would reject aren't cached.
+func sitemapDocumentKey(r *http.Request) (string, bool) {
+	s := r.URL.Query().Get("page")
+	if s == "" {
+		return r.URL.Path, true
+	}
+
+	page, err := strconv.Atoi(s)
+	if err != nil || page < 0 {
+		return "", false
+	}
+	if page == 0 {
+		return r.URL.Path, true
+	}
+	return r.URL.Path + "?page=" + strconv.Itoa(page), true
+}
+
+// sitemapHandler serves the sitemap of published posts, with the images attached to
+// each. Once there are more posts than fit in one sitemap it serves a sitemap index
+// instead, pointing at each part as ?page=N.
+func (app *application) sitemapHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	page := app.readInt(r.URL.Query(), "page", 0, v)
+	v.Check(page >= 0, "page", "must be greater than zero")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	pages, err := app.models.Posts.SitemapPages(data.MaxSitemapURLs)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	site := app.publicURL(r)
+
+	var doc any
+	var updated time.Time
+	switch {
+	case page == 0 && len(pages) > 1:
+		index := sitemapIndex{}
+		for i, lastMod := range pages {
+			index.Sitemaps = append(index.Sitemaps, sitemapRef{
+				Loc:     site + "/sitemap.xml?page=" + strconv.Itoa(i+1),
+				LastMod: lastMod.UTC().Format(time.RFC3339),
+			})
+			if lastMod.After(updated) {
+				updated = lastMod
+			}
+		}
+		doc = index
+
+	case page == 0 && len(pages) == 0:
+		doc = sitemapURLSet{ImageNS: "http://www.google.com/schemas/sitemap-image/1.1"}
+
+	default:
+		if page == 0 {
+			page = 1
+		}
+		if page > len(pages) {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		entries, err := app.models.Posts.GetSitemapPage(page, data.MaxSitemapURLs)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		urlSet := sitemapURLSet{ImageNS: "http://www.google.com/schemas/sitemap-image/1.1"}
+		for _, entry := range entries {
+			u := sitemapURL{
+				Loc:     site + "/blog/" + url.PathEscape(entry.Slug),
+				LastMod: entry.UpdatedAt.UTC().Format(time.RFC3339),
+			}
+			for _, filename := range entry.Images {
+				u.Images = append(u.Images, sitemapImage{Loc: site + "/v1/images/" + url.PathEscape(filename)})
+			}
+			urlSet.URLs = append(urlSet.URLs, u)
+		}
+		doc = urlSet
+		updated = pages[page-1]
+	}
+
+	body, err := xml.MarshalIndent(doc, "", "\t")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !updated.IsZero() {
+		w.Header().Set("Last-Modified", updated.UTC().Format(http.TimeFormat))
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(append([]byte(xml.Header), body...))
+}
+
+// robotsHandler serves robots.txt: the file given with -robots-file, or else rules
+// keeping crawlers out of the API, except for images, and pointing them at the
+// sitemap.
+func (app *application) robotsHandler(w http.ResponseWriter, r *http.Request) {
+	robots := app.config.robots.content
+	if robots == "" {
+		var b strings.Builder
+		b.WriteString("User-agent: *\n")
+		b.WriteString("Allow: /v1/images/\n")
+		b.WriteString("Disallow: /v1/\n")
+		b.WriteString("Disallow: /debug/\n")
+		b.WriteString("\n")
+		b.WriteString("Sitemap: " + app.publicURL(r) + "/sitemap.xml\n")
+		robots = b.String()
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(robots))
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"net/http"
+	"os"
+	"time"
+
+	"blog/internal/data"
+	"blog/internal/spam"
+)
+
+// spamChecks lists the spam checks which can be turned on with -spam-checks. The
+// minimum time check is off by default, as clients have to fetch a form token for it.
+var spamChecks = []string{"honeypot", "min-time", "links", "blocklist", "bayes"}
+
+const defaultSpamChecks = "honeypot,links,blocklist,bayes"
+
+// spamMinMessages is how many comments moderators must have approved, and marked as
+// spam, before the Bayes filter starts catching anything.
+const spamMinMessages = 10
+
+// setupSpamChecker chains together the spam checks configured with -spam-checks.
+func (app *application) setupSpamChecker() error {
+	app.formTokens = spam.MinTime{
+		Key:    formTokenKey(app.config.cursor.key),
+		MinAge: app.config.spam.minTime,
+		MaxAge: 24 * time.Hour,
+		Used:   app.models.Spam,
+	}
+
+	var chain spam.Chain
+	for _, check := range app.config.spam.checks {
+		switch check {
+		case "honeypot":
+			chain = append(chain, spam.Honeypot{})
+		case "min-time":
+			chain = append(chain, app.formTokens)
+		case "links":
+			chain = append(chain, spam.Links{MaxLinks: app.config.spam.maxLinks})
+		case "blocklist":
+			if app.config.spam.blocklistFile == "" {
+				continue
+			}
+			f, err := os.Open(app.config.spam.blocklistFile)
+			if err != nil {
+				return err
+			}
+			blocklist, err := spam.ReadBlocklist(f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			chain = append(chain, blocklist)
+		case "bayes":
+			chain = append(chain, spam.Bayes{
+				Model:       app.models.Spam,
+				Threshold:   app.config.spam.threshold,
+				MinMessages: spamMinMessages,
+			})
+		}
+	}
+
+	app.spamChecker = chain
+	return nil
+}
+
+// formTokenKey derives the key form tokens are signed with from the cursor key, so
+// that -cursor-secret serves for both without either key signing the other's values.
+func formTokenKey(cursorKey []byte) []byte {
+	mac := hmac.New(sha256.New, cursorKey)
+	mac.Write([]byte("form tokens"))
+	return mac.Sum(nil)
+}
+
+// isSpam runs the spam checks on a submission, logging anything they catch.
+func (app *application) isSpam(r *http.Request, s *spam.Submission) (bool, error) {
+	verdict, err := app.spamChecker.Check(r.Context(), s)
+	if err != nil {
+		return false, err
+	}
+
+	if verdict.Spam {
+		app.logger.Info(r.Context(), "spam caught",
+			"kind", s.Kind,
+			"reason", verdict.Reason,
+			"ip_address", s.IP,
+		)
+	}
+	return verdict.Spam, nil
+}
+
+// trainSpamFilter teaches the Bayes filter a moderator's decision about a comment:
+// approved comments are ham, and spam is spam. Other decisions teach it nothing. The
+// decision stands even if the filter can't be taught, so failures are only logged.
+func (app *application) trainSpamFilter(r *http.Request, comment *data.Comment) {
+	var class string
+	switch comment.Status {
+	case data.CommentStatusApproved:
+		class = data.SpamClassHam
+	case data.CommentStatusSpam:
+		class = data.SpamClassSpam
+	default:
+		return
+	}
+
+	err := app.models.Spam.TrainComment(comment.ID, class, spam.Tokens(comment.Content))
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// formTokenHandler issues a form token, which clients fetch when they show a comment
+// or registration form and send back with it. Each token is good for one submission.
+func (app *application) formTokenHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := app.formTokens.NewFormToken()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"form_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
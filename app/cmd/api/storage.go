@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"blog/internal/storage"
+)
+
+const (
+	// imageDir is the storage directory uploaded images are kept in.
+	imageDir = "images"
+	// maxImageSize is the largest image file which may be uploaded.
+	maxImageSize = 10 << 20
+	// signedURLExpiry is how long a redirect to a signed storage URL stays valid.
+	signedURLExpiry = 15 * time.Minute
+	// storageReadTimeout bounds reading a whole stored file into memory. Files
+	// streamed to clients aren't bounded, as a slow client may take longer.
+	storageReadTimeout = time.Minute
+)
+
+// serveStoredFile writes the stored file under key to the response, or redirects to
+// a signed URL for it if that's enabled and the backend supports it.
+func (app *application) serveStoredFile(w http.ResponseWriter, r *http.Request, key, contentType string) {
+	if app.config.storage.signedURLs {
+		url, err := app.storage.SignedURL(r.Context(), key, signedURLExpiry)
+		switch {
+		case err == nil:
+			// The URL expires, so the redirect mustn't be cached for longer than it lasts.
+			w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(int(signedURLExpiry.Seconds()/2)))
+			http.Redirect(w, r, url, http.StatusFound)
+			return
+		case !errors.Is(err, storage.ErrNotSupported):
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	obj, info, err := app.storage.Get(r.Context(), key)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer obj.Close()
+
+	// Set headers
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=31536000") // Cache for 1 year
+
+	// Files which can be seeked get range request support from ServeContent.
+	if rs, ok := obj.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", info.ModTime, rs)
+		return
+	}
+
+	if !info.ModTime.IsZero() {
+		w.Header().Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	}
+	if info.Size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	io.Copy(w, obj)
+}
+
+// releaseBlobs deletes the stored files of blobs, and of their variants, once no
+// image is attached to them any more. Errors are logged rather than returned since it
+// is only ever cleanup; a blob whose files couldn't all be deleted is kept for the
+// garbage collector to try again.
+func (app *application) releaseBlobs(blobIDs ...int64) {
+	for _, id := range blobIDs {
+		err := app.models.Blobs.DeleteUnused(id, func(paths []string) error {
+			return app.removeStoredFiles(context.Background(), paths)
+		})
+		if err != nil {
+			app.logger.Error(context.Background(), "failed to release image blob",
+				"error", err.Error(),
+				"blob_id", id,
+			)
+		}
+	}
+}
+
+// removeStoredFiles deletes the stored files, stopping at the first which can't be.
+func (app *application) removeStoredFiles(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		err := app.storage.Delete(ctx, key)
+		if err != nil {
+			return fmt.Errorf("delete %s: %w", key, err)
+		}
+	}
+	return nil
+}
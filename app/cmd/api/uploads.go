@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"blog/internal/data"
+	"blog/internal/data/validator"
+	"blog/internal/storage"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Resumable uploads speak version 1.0.0 of the tus protocol (https://tus.io), with the
+// creation and termination extensions.
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination"
+	// uploadChunkDir is the storage directory the chunks of uploads in progress are
+	// kept in, under a directory per upload.
+	uploadChunkDir = "tus"
+	// minUploadChunkSize is the smallest chunk kept on its own. A smaller last chunk is
+	// merged with the next one sent, so that an upload sent in tiny pieces is still
+	// stored in at most a few hundred chunks.
+	minUploadChunkSize = 64 << 10
+)
+
+// tusResumable sets the Tus-Resumable header on every response, and turns away
+// requests for a protocol version other than the one we speak. OPTIONS requests are
+// exempt, since they're how clients find out which versions we speak.
+func (app *application) tusResumable(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+
+		if r.Method != http.MethodOptions && r.Header.Get("Tus-Resumable") != tusVersion {
+			w.Header().Set("Tus-Version", tusVersion)
+			app.errorResponse(w, r, http.StatusPreconditionFailed, "unsupported tus protocol version")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// uploadOptionsHandler tells clients what the server supports.
+func (app *application) uploadOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.Itoa(maxImageSize))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// createUploadHandler starts a resumable upload of an image. The Upload-Metadata
+// header must give the post_id of the post the image is for and its filename, and may
+// give its alt_text, caption, is_featured and sort_order as the form fields of a
+// direct upload would.
+func (app *application) createUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		app.badRequestResponse(w, r, errors.New("the Upload-Defer-Length header is not supported"))
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		app.badRequestResponse(w, r, errors.New("the Upload-Length header must be a non-negative integer"))
+		return
+	}
+	if length > maxImageSize {
+		app.errorResponse(w, r, http.StatusRequestEntityTooLarge, "the upload must not be larger than 10MB")
+		return
+	}
+
+	rawMetadata := r.Header.Get("Upload-Metadata")
+	metadata, err := parseUploadMetadata(rawMetadata)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(length > 0, "image", "must not be empty")
+
+	postID, err := strconv.ParseInt(metadata["post_id"], 10, 64)
+	v.Check(err == nil && postID > 0, "post_id", "must be provided in Upload-Metadata")
+
+	ext := strings.ToLower(filepath.Ext(metadata["filename"]))
+	v.Check(metadata["filename"] != "", "filename", "must be provided in Upload-Metadata")
+	v.Check(validator.PermittedValue(ext, ".jpg", ".jpeg", ".png", ".gif", ".webp"), "filename", "must be a valid image format")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	post, err := app.models.Posts.Get(postID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("post_id", "must be an existing post")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	allowed, err := app.userCanManagePost(r, post)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !allowed {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	id := make([]byte, 16)
+	_, err = rand.Read(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	upload := &data.Upload{
+		ID:       hex.EncodeToString(id),
+		UserID:   app.contextGetUser(r).ID,
+		PostID:   postID,
+		Length:   length,
+		Metadata: rawMetadata,
+	}
+
+	err = app.models.Uploads.Insert(upload)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", "/v1/uploads/"+upload.ID)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// headUploadHandler reports how much of an upload the server has received.
+func (app *application) headUploadHandler(w http.ResponseWriter, r *http.Request) {
+	upload, ok := app.readUpload(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	app.writeUploadHeaders(w, upload)
+	w.WriteHeader(http.StatusOK)
+}
+
+// patchUploadHandler stores the next chunk of an upload. Whatever part of the chunk
+// arrives is kept even if the request is cut short, so the client can resume from
+// there, but a chunk which runs past the end of the upload is refused whole. The
+// request which brings the upload to its full length also attaches the image to its
+// post.
+func (app *application) patchUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		app.errorResponse(w, r, http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream")
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		app.badRequestResponse(w, r, errors.New("the Upload-Offset header must be a non-negative integer"))
+		return
+	}
+
+	upload, ok := app.readUpload(w, r)
+	if !ok {
+		return
+	}
+
+	if offset != upload.Offset {
+		app.errorResponse(w, r, http.StatusConflict, "Upload-Offset does not match the offset of the upload")
+		return
+	}
+
+	remaining := upload.Length - upload.Offset
+	if r.ContentLength > remaining {
+		app.errorResponse(w, r, http.StatusRequestEntityTooLarge, "the chunk runs past the end of the upload")
+		return
+	}
+
+	// One byte more than fits is read, to tell a chunk of unknown length which runs
+	// past the end apart from one which fills the upload exactly.
+	chunk, readErr := io.ReadAll(io.LimitReader(r.Body, remaining+1))
+	if int64(len(chunk)) > remaining {
+		app.errorResponse(w, r, http.StatusRequestEntityTooLarge, "the chunk runs past the end of the upload")
+		return
+	}
+
+	if len(chunk) > 0 {
+		size := int64(len(chunk))
+
+		// A last chunk smaller than the minimum is replaced by one holding it and this
+		// chunk together.
+		start := upload.Offset
+		lastKey := ""
+		if n := len(upload.ChunkKeys); n > 0 {
+			lastStart, ok := uploadChunkStart(upload.ChunkKeys[n-1])
+			if ok && upload.Offset-lastStart < minUploadChunkSize {
+				last, err := app.readUploadChunk(context.Background(), upload.ChunkKeys[n-1])
+				if err != nil {
+					app.serverErrorResponse(w, r, err)
+					return
+				}
+				chunk = append(last, chunk...)
+				start, lastKey = lastStart, upload.ChunkKeys[n-1]
+			}
+		}
+
+		// Chunks are named after their offset, plus a random suffix so that requests
+		// racing to write at the same offset don't overwrite each other's chunk.
+		suffix := make([]byte, 4)
+		_, err := rand.Read(suffix)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		key := path.Join(uploadChunkDir, upload.ID, fmt.Sprintf("%020d-%s", start, hex.EncodeToString(suffix)))
+
+		// The client may have gone away, but what it sent is still worth keeping.
+		err = app.storage.Put(context.Background(), key, bytes.NewReader(chunk), int64(len(chunk)), "application/octet-stream")
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if lastKey == "" {
+			err = app.models.Uploads.AppendChunk(upload, key, size)
+		} else {
+			err = app.models.Uploads.ReplaceLastChunk(upload, lastKey, key, size)
+		}
+		if err != nil {
+			// The chunk was never accepted. If it can't be deleted now it goes along
+			// with the rest of the upload's chunks.
+			app.storage.Delete(context.Background(), key)
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.errorResponse(w, r, http.StatusConflict, "Upload-Offset does not match the offset of the upload")
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		// Like any other, the replaced chunk goes along with the upload if it can't be
+		// deleted now.
+		if lastKey != "" {
+			app.storage.Delete(context.Background(), lastKey)
+		}
+	}
+
+	if readErr != nil {
+		app.badRequestResponse(w, r, fmt.Errorf("failed to read upload chunk: %v", readErr))
+		return
+	}
+
+	// A request which finds the upload full but not yet attached finishes it off, in
+	// case the one which filled it failed to.
+	if upload.Offset == upload.Length && upload.CompletedAt == nil {
+		ok := app.completeUpload(w, r, upload)
+		if !ok {
+			return
+		}
+	}
+
+	app.writeUploadHeaders(w, upload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// uploadChunkStart returns the offset in its upload of the chunk stored under key,
+// which its name begins with.
+func uploadChunkStart(key string) (int64, bool) {
+	offset, _, found := strings.Cut(path.Base(key), "-")
+	if !found {
+		return 0, false
+	}
+	start, err := strconv.ParseInt(offset, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return start, true
+}
+
+// readUploadChunk returns the contents of the chunk stored under key.
+func (app *application) readUploadChunk(ctx context.Context, key string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, storageReadTimeout)
+	defer cancel()
+
+	obj, _, err := app.storage.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	return io.ReadAll(obj)
+}
+
+// completeUpload puts together the chunks of an upload and attaches the image to its
+// post. If it writes an error response it returns false. An upload which turns out not
+// to be a valid image is deleted, since resuming it can't help.
+func (app *application) completeUpload(w http.ResponseWriter, r *http.Request, upload *data.Upload) bool {
+	ctx, cancel := context.WithTimeout(r.Context(), storageReadTimeout)
+	defer cancel()
+
+	var buf bytes.Buffer
+	buf.Grow(int(upload.Length))
+	for _, key := range upload.ChunkKeys {
+		obj, _, err := app.storage.Get(ctx, key)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return false
+		}
+		_, err = io.Copy(&buf, obj)
+		obj.Close()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return false
+		}
+	}
+
+	content := buf.Bytes()
+	if int64(len(content)) != upload.Length {
+		app.serverErrorResponse(w, r, fmt.Errorf("upload %s has %d bytes stored, expected %d", upload.ID, len(content), upload.Length))
+		return false
+	}
+
+	// The metadata was checked when the upload was created.
+	metadata, _ := parseUploadMetadata(upload.Metadata)
+	sortOrder, _ := strconv.Atoi(metadata["sort_order"])
+
+	v := validator.New()
+	image, err := app.saveImage(r.Context(), v, imageUpload{
+		UserID:     upload.UserID,
+		PostID:     upload.PostID,
+		Filename:   metadata["filename"],
+		Content:    content,
+		AltText:    metadata["alt_text"],
+		Caption:    metadata["caption"],
+		IsFeatured: metadata["is_featured"] == "true",
+		SortOrder:  sortOrder,
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if !v.Valid() {
+		app.deleteUpload(upload.ID)
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	err = app.models.Uploads.Complete(upload, image.ID)
+	if err != nil {
+		// Another request completed the upload first, so this image is a duplicate.
+		if errors.Is(err, data.ErrEditConflict) {
+			if app.models.Images.Delete(image.ID) == nil {
+				app.releaseBlobs(image.BlobID)
+			}
+			app.errorResponse(w, r, http.StatusConflict, "the upload has already been completed")
+			return false
+		}
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	app.background(func() {
+		app.removeUploadChunks(context.Background(), upload.ID)
+	})
+
+	return true
+}
+
+// deleteUploadHandler abandons an upload, deleting whatever has been received of it.
+// The image made from a completed upload is not affected.
+func (app *application) deleteUploadHandler(w http.ResponseWriter, r *http.Request) {
+	upload, ok := app.readUpload(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Uploads.Delete(upload.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.removeUploadChunks(r.Context(), upload.ID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readUpload fetches the upload named in the URL. Uploads can only be seen by the user
+// who created them; to anyone else they don't exist. If it writes an error response it
+// returns false.
+func (app *application) readUpload(w http.ResponseWriter, r *http.Request) (*data.Upload, bool) {
+	id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+
+	upload, err := app.models.Uploads.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if upload.UserID != app.contextGetUser(r).ID {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	return upload, true
+}
+
+// writeUploadHeaders describes the state of an upload in the response headers. Once
+// the upload is complete, Image-Id gives the id of the image made from it.
+func (app *application) writeUploadHeaders(w http.ResponseWriter, upload *data.Upload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if upload.Metadata != "" {
+		w.Header().Set("Upload-Metadata", upload.Metadata)
+	}
+	if upload.ImageID != nil {
+		w.Header().Set("Image-Id", strconv.FormatInt(*upload.ImageID, 10))
+	}
+}
+
+// deleteUpload deletes an upload along with its chunks, logging rather than returning
+// any error.
+func (app *application) deleteUpload(id string) {
+	err := app.models.Uploads.Delete(id)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.logger.Error(context.Background(), "failed to delete upload",
+			"error", err.Error(),
+			"upload_id", id,
+		)
+		return
+	}
+
+	app.removeUploadChunks(context.Background(), id)
+}
+
+// removeUploadChunks deletes every chunk stored for an upload, logging rather than
+// returning any error.
+func (app *application) removeUploadChunks(ctx context.Context, id string) {
+	err := app.storage.List(ctx, path.Join(uploadChunkDir, id)+"/", func(obj *storage.ObjectInfo) error {
+		return app.storage.Delete(ctx, obj.Key)
+	})
+	if err != nil {
+		app.logger.Error(ctx, "failed to delete upload chunks from storage",
+			"error", err.Error(),
+			"upload_id", id,
+		)
+	}
+}
+
+// parseUploadMetadata parses an Upload-Metadata header: comma separated pairs of a key
+// and, optionally, a base64 encoded value.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("the Upload-Metadata header contains an empty key")
+		}
+		if _, ok := metadata[key]; ok {
+			return nil, fmt.Errorf("the Upload-Metadata header contains the key %q more than once", key)
+		}
+
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("the Upload-Metadata value of %q is not valid base64", key)
+		}
+		metadata[key] = string(value)
+	}
+
+	return metadata, nil
+}
@@ -6,6 +6,9 @@ import (
 
 	"blog/internal/data"
 	"blog/internal/data/validator"
+	"blog/internal/spam"
+
+	"github.com/tomasen/realip"
 )
 
 func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Request) {
@@ -15,6 +18,9 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		Name     string `json:"name"`
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		// Website is a honeypot, and FormToken the token from GET /v1/forms/token.
+		Website   string `json:"website"`
+		FormToken string `json:"form_token"`
 	}
 	// Parse the request body into the anonymous struct.
 	err := app.readJSON(w, r, &input)
@@ -46,6 +52,23 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
+	// Turn away registrations the spam checks catch before they take up an account.
+	isSpam, err := app.isSpam(r, &spam.Submission{
+		Kind:      spam.KindRegistration,
+		Name:      user.Name,
+		Email:     user.Email,
+		IP:        realip.FromRequest(r),
+		Honeypot:  input.Website,
+		FormToken: input.FormToken,
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if isSpam {
+		app.spamRejectedResponse(w, r)
+		return
+	}
 	// Insert the user data into the database.
 	err = app.models.Users.Insert(user)
 	if err != nil {
@@ -202,3 +225,63 @@ func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// updateUserAvatarHandler sets the avatar shown on the user's author profile to an
+// image from the media library, or clears it when image_id is null. The image must be
+// one the user uploaded, unless they can manage everyone's media.
+func (app *application) updateUserAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ImageID *int64 `json:"image_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	user.AvatarImage = nil
+
+	if input.ImageID != nil {
+		v := validator.New()
+
+		image, err := app.models.Images.Get(*input.ImageID)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("image_id", "image does not exist")
+		case err != nil:
+			app.serverErrorResponse(w, r, err)
+			return
+		default:
+			allowed, err := app.userCanManageImage(r, image)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			v.Check(allowed, "image_id", "must be an image you uploaded")
+			user.AvatarImage = &image.Filename
+		}
+
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
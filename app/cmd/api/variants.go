@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"path"
+	"strings"
+	"sync"
+
+	"blog/internal/data"
+	"blog/internal/imaging"
+	"blog/internal/storage"
+)
+
+const (
+	// variantDir is the storage directory resized copies of uploaded images are kept in.
+	variantDir = "images/variants"
+	// variantWidthStep is what widths asked for on the fly are rounded up to, so that
+	// clients can't fill the disk with a copy of an image at every possible width.
+	variantWidthStep = 100
+	// maxVariantWidth is the widest image that may be asked for with ?w=.
+	maxVariantWidth = 4000
+	// maxVariantJobs is how many variants may be made at once. Each holds a decoded
+	// copy of its original, which can take a couple of hundred megabytes.
+	maxVariantJobs = 2
+)
+
+// variantJobs limits how many variants are made at once, and makes sure that however
+// many requests ask for the same missing variant at the same time, it is only made
+// once.
+type variantJobs struct {
+	slots chan struct{}
+	mu    sync.Mutex
+	calls map[string]*variantCall
+}
+
+type variantCall struct {
+	done    chan struct{}
+	variant *data.ImageVariant
+	err     error
+}
+
+func newVariantJobs(n int) *variantJobs {
+	return &variantJobs{
+		slots: make(chan struct{}, n),
+		calls: make(map[string]*variantCall),
+	}
+}
+
+// acquire waits for a free slot to make variants in, returning the function which
+// frees it again.
+func (j *variantJobs) acquire(ctx context.Context) (func(), error) {
+	select {
+	case j.slots <- struct{}{}:
+		return func() { <-j.slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// do calls create once in a free slot and returns what it made, unless a call for the
+// same key is already under way, in which case it waits for and returns that result.
+func (j *variantJobs) do(ctx context.Context, key string, create func() (*data.ImageVariant, error)) (*data.ImageVariant, error) {
+	j.mu.Lock()
+	if call, ok := j.calls[key]; ok {
+		j.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.variant, call.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	call := &variantCall{done: make(chan struct{})}
+	j.calls[key] = call
+	j.mu.Unlock()
+
+	release, err := j.acquire(ctx)
+	if err == nil {
+		call.variant, call.err = create()
+		release()
+	} else {
+		call.err = err
+	}
+
+	j.mu.Lock()
+	delete(j.calls, key)
+	j.mu.Unlock()
+	close(call.done)
+
+	return call.variant, call.err
+}
+
+// setImageSrcsets fills in the srcset of each image from the configured variants.
+// Images uploaded before their dimensions were recorded get none.
+func (app *application) setImageSrcsets(images ...*data.Image) {
+	for _, img := range images {
+		if img == nil || img.Width == nil {
+			continue
+		}
+		img.Srcset = imaging.Srcset("/v1/images/"+img.Filename, *img.Width, app.config.images.variants)
+	}
+}
+
+// setPostSrcsets fills in the srcsets of the images attached to posts.
+func (app *application) setPostSrcsets(posts ...*data.Post) {
+	for _, post := range posts {
+		app.setImageSrcsets(post.FeaturedImage)
+		app.setImageSrcsets(post.Images...)
+	}
+}
+
+// generateVariants writes and records each configured variant narrower than the
+// image. It is run in the background after an upload; any variant it hasn't got to
+// yet is generated on demand when first requested.
+func (app *application) generateVariants(ctx context.Context, img *data.Image) {
+	release, err := app.variantJobs.acquire(ctx)
+	if err != nil {
+		app.logVariantError(img, err)
+		return
+	}
+	defer release()
+
+	src, err := app.decodeImage(ctx, img)
+	if err != nil {
+		app.logVariantError(img, err)
+		return
+	}
+
+	mimeType := imaging.OutputType(img.MimeType)
+	for _, preset := range app.config.images.variants {
+		if preset.MaxWidth >= src.Bounds().Dx() {
+			break
+		}
+
+		_, err := app.writeVariant(ctx, img, preset.Name, src, preset.MaxWidth, mimeType, preset.Quality)
+		if err != nil {
+			app.logVariantError(img, err)
+			return
+		}
+	}
+}
+
+// resizedImage returns a variant of the image at least width wide, but no wider than
+// width rounded up to the next variantWidthStep, in the given type. If no such variant
+// exists yet it is made and recorded, by one request at a time. It returns nil if the
+// original image should be served instead.
+func (app *application) resizedImage(ctx context.Context, img *data.Image, width int, mimeType string) (*data.ImageVariant, error) {
+	target := (width + variantWidthStep - 1) / variantWidthStep * variantWidthStep
+	if img.Width != nil && target >= *img.Width {
+		if mimeType == img.MimeType {
+			return nil, nil
+		}
+		// Converting to another type without resizing.
+		target = *img.Width
+		if width > target {
+			width = target
+		}
+	}
+
+	variant, err := app.models.Variants.Find(img.BlobID, mimeType, width, target)
+	switch {
+	case err == nil:
+		_, err := app.storage.Stat(ctx, variant.FilePath)
+		if err == nil {
+			return variant, nil
+		}
+		// If the file has gone missing, make it again.
+		if !errors.Is(err, storage.ErrNotFound) {
+			return nil, err
+		}
+	case !errors.Is(err, data.ErrRecordNotFound):
+		return nil, err
+	}
+
+	key := fmt.Sprintf("%d:%d:%s", img.BlobID, target, mimeType)
+	return app.variantJobs.do(ctx, key, func() (*data.ImageVariant, error) {
+		src, err := app.decodeImage(ctx, img)
+		if err != nil {
+			return nil, err
+		}
+
+		quality := imaging.QualityFor(app.config.images.variants, target)
+		name := fmt.Sprintf("w%d-%s", target, strings.TrimPrefix(imaging.Extension(mimeType), "."))
+		return app.writeVariant(ctx, img, name, src, target, mimeType, quality)
+	})
+}
+
+func (app *application) decodeImage(ctx context.Context, img *data.Image) (image.Image, error) {
+	ctx, cancel := context.WithTimeout(ctx, storageReadTimeout)
+	defer cancel()
+
+	obj, _, err := app.storage.Get(ctx, img.FilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	return imaging.Decode(obj)
+}
+
+// writeVariant resizes src to width, stores it under a name derived from the image's
+// file and the variant name, and records it against the image's blob.
+func (app *application) writeVariant(ctx context.Context, img *data.Image, name string, src image.Image, width int, mimeType string, quality int) (*data.ImageVariant, error) {
+	resized := imaging.Resize(src, width)
+	content, err := imaging.Encode(resized, mimeType, quality)
+	if err != nil {
+		return nil, err
+	}
+
+	base := path.Base(img.FilePath)
+	stem := strings.TrimSuffix(base, path.Ext(base))
+	filename := fmt.Sprintf("%s_%s%s", stem, name, imaging.Extension(mimeType))
+	filePath := path.Join(variantDir, filename)
+
+	err = app.storage.Put(ctx, filePath, bytes.NewReader(content), int64(len(content)), mimeType)
+	if err != nil {
+		return nil, err
+	}
+
+	variant := &data.ImageVariant{
+		BlobID:   img.BlobID,
+		Name:     name,
+		Filename: filename,
+		FilePath: filePath,
+		FileSize: int64(len(content)),
+		MimeType: mimeType,
+		Width:    resized.Bounds().Dx(),
+		Height:   resized.Bounds().Dy(),
+	}
+
+	err = app.models.Variants.Insert(variant)
+	if err != nil {
+		return nil, err
+	}
+
+	return variant, nil
+}
+
+func (app *application) logVariantError(img *data.Image, err error) {
+	app.logger.Error(context.Background(), "failed to generate image variants",
+		"error", err.Error(),
+		"image_id", img.ID,
+		"file_path", img.FilePath,
+	)
+}
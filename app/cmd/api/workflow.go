@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"blog/internal/data"
+	"blog/internal/data/validator"
+)
+
+// submitPostHandler sends a draft to the editors for review. Only the post's author
+// (or someone with posts:manage_all) may submit it.
+func (app *application) submitPostHandler(w http.ResponseWriter, r *http.Request) {
+	app.transitionPost(w, r, data.PostStatusInReview, nil)
+}
+
+// publishPostHandler publishes a post straight away, unless its published_at lies in
+// the future, in which case the post is scheduled instead.
+func (app *application) publishPostHandler(w http.ResponseWriter, r *http.Request) {
+	app.transitionPost(w, r, data.PostStatusPublished, nil)
+}
+
+// schedulePostHandler queues a post to be published by the scheduler at the given time.
+func (app *application) schedulePostHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		PublishedAt time.Time `json:"published_at"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(!input.PublishedAt.IsZero(), "published_at", "must be provided")
+	v.Check(input.PublishedAt.After(time.Now()), "published_at", "must be in the future")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.transitionPost(w, r, data.PostStatusScheduled, &input.PublishedAt)
+}
+
+// unpublishPostHandler takes a post back to draft, either pulling it off the site or
+// returning a submission to its author.
+func (app *application) unpublishPostHandler(w http.ResponseWriter, r *http.Request) {
+	app.transitionPost(w, r, data.PostStatusDraft, nil)
+}
+
+// archivePostHandler takes a published post off the site for good, keeping it for the
+// record.
+func (app *application) archivePostHandler(w http.ResponseWriter, r *http.Request) {
+	app.transitionPost(w, r, data.PostStatusArchived, nil)
+}
+
+// transitionPost moves the post named in the URL to the given status. For publishing,
+// publishAt optionally overrides the post's published_at, and whether the post ends up
+// published or scheduled depends on whether that time has passed.
+func (app *application) transitionPost(w http.ResponseWriter, r *http.Request, status string, publishAt *time.Time) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	post, err := app.models.Posts.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if status == data.PostStatusInReview {
+		allowed, err := app.userCanManagePost(r, post)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !allowed {
+			app.notPermittedResponse(w, r)
+			return
+		}
+	}
+
+	if status == data.PostStatusPublished || status == data.PostStatusScheduled {
+		if publishAt != nil {
+			post.PublishedAt = publishAt
+		}
+		now := time.Now()
+		if post.PublishedAt == nil {
+			post.PublishedAt = &now
+		}
+
+		status = data.PostStatusPublished
+		if post.PublishedAt.After(now) {
+			status = data.PostStatusScheduled
+		}
+	}
+
+	if !post.CanTransitionTo(status) {
+		app.invalidStatusTransitionResponse(w, r, "post", post.Status, status)
+		return
+	}
+
+	post.Status = status
+
+	v := validator.New()
+	if data.ValidatePost(v, post); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Posts.Update(post, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if post.Status == data.PostStatusPublished {
+		app.emitPostPublished(post)
+	} else {
+		app.emitPostUpdated(post)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"post": post}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,155 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Blob is a stored image file. Images with the same contents share one blob, which
+// counts how many images are attached to it.
+type Blob struct {
+	ID        int64
+	SHA256    *string
+	FilePath  string
+	FileSize  int64
+	MimeType  string
+	Width     *int
+	Height    *int
+	RefCount  int
+	CreatedAt time.Time
+}
+
+type BlobModel struct {
+	DB *sql.DB
+}
+
+// DeleteUnused deletes the blob if no images are attached to it any more, along with
+// its variants. remove is called with the paths of their files, which it should delete
+// from storage, while the blob is still locked: an upload of the same content waits
+// until the files are gone before recording a new blob and storing its own copy. If
+// remove fails the blob is kept.
+func (m BlobModel) DeleteUnused(id int64, remove func(paths []string) error) error {
+	// Allow for deleting the files from storage, which may be remote.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var path string
+	err = tx.QueryRowContext(ctx, `
+		SELECT file_path FROM image_blobs
+		WHERE id = $1 AND ref_count = 0
+		FOR UPDATE`, id).Scan(&path)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	// New variants can't be added while the blob is locked.
+	rows, err := tx.QueryContext(ctx, `SELECT file_path FROM image_variants WHERE blob_id = $1`, id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	paths := []string{path}
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return err
+		}
+		paths = append(paths, path)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	err = remove(paths)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM image_blobs WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// StoredFile is a file which the database expects to find in storage: either a blob's
+// or a variant's.
+type StoredFile struct {
+	// Kind is "blob" or "variant", and ID the id of the row in image_blobs or
+	// image_variants.
+	Kind      string
+	ID        int64
+	FilePath  string
+	CreatedAt time.Time
+}
+
+// StoredFiles returns every file recorded for blobs and their variants.
+func (m BlobModel) StoredFiles() ([]*StoredFile, error) {
+	query := `
+		SELECT 'blob', id, file_path, created_at FROM image_blobs
+		UNION ALL
+		SELECT 'variant', id, file_path, created_at FROM image_variants`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []*StoredFile{}
+	for rows.Next() {
+		var file StoredFile
+		err := rows.Scan(&file.Kind, &file.ID, &file.FilePath, &file.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, &file)
+	}
+
+	return files, rows.Err()
+}
+
+// GetUnused returns the ids of blobs created before the given time which no image is
+// attached to.
+func (m BlobModel) GetUnused(before time.Time) ([]int64, error) {
+	query := `
+		SELECT id FROM image_blobs
+		WHERE ref_count = 0 AND created_at < $1
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
@@ -0,0 +1,311 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"blog/internal/data/validator"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrCategoryCycle = errors.New("category cycle")
+)
+
+// Category is a node in the category tree. A post filed under a category also counts
+// as being in each of that category's ancestors, so PostCount includes the public
+// posts of every subcategory.
+type Category struct {
+	ID          int64     `json:"id"`
+	ParentID    *int64    `json:"parent_id"`
+	Name        string    `json:"name"`
+	Slug        string    `json:"slug"`
+	Description string    `json:"description"`
+	PostCount   int       `json:"post_count"`
+	CreatedAt   time.Time `json:"created_at"`
+	Version     int32     `json:"version"`
+}
+
+type CategoryModel struct {
+	DB *sql.DB
+}
+
+func ValidateCategory(v *validator.Validator, category *Category) {
+	v.Check(category.Name != "", "name", "must be provided")
+	v.Check(len(category.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(category.Slug != "", "slug", "must be provided")
+	v.Check(len(category.Slug) <= 100, "slug", "must not be more than 100 bytes long")
+	v.Check(validator.Matches(category.Slug, SlugRX), "slug", "must only contain lowercase letters, digits and hyphens")
+	v.Check(len(category.Description) <= 1000, "description", "must not be more than 1000 bytes long")
+	if category.ParentID != nil {
+		v.Check(*category.ParentID != category.ID, "parent_id", "must not be the category itself")
+	}
+}
+
+// categorySelect selects categories with their post counts. The recursive CTE pairs
+// each category matched by the %s condition with itself and all of its descendants;
+// UNION rather than UNION ALL stops it going round forever should the tree ever have
+// a cycle in it.
+const categorySelect = `
+	WITH RECURSIVE tree AS (
+		SELECT id AS root_id, id FROM categories c WHERE %s
+		UNION
+		SELECT tree.root_id, c.id FROM categories c JOIN tree ON c.parent_id = tree.id
+	)
+	SELECT c.id, c.parent_id, c.name, c.slug, c.description, count(DISTINCT p.id), c.created_at, c.version
+	FROM categories c
+	JOIN tree ON tree.root_id = c.id
+	LEFT JOIN post_categories pc ON pc.category_id = tree.id
+	LEFT JOIN posts p ON p.id = pc.post_id AND ` + publicPostCondition + `
+	GROUP BY c.id`
+
+func scanCategory(row interface{ Scan(...any) error }) (*Category, error) {
+	var category Category
+	err := row.Scan(
+		&category.ID,
+		&category.ParentID,
+		&category.Name,
+		&category.Slug,
+		&category.Description,
+		&category.PostCount,
+		&category.CreatedAt,
+		&category.Version,
+	)
+	return &category, err
+}
+
+func (m CategoryModel) Insert(category *Category) error {
+	query := `
+		INSERT INTO categories (parent_id, name, slug, description)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version`
+
+	args := []any{category.ParentID, category.Name, category.Slug, category.Description}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&category.ID, &category.CreatedAt, &category.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "categories_slug_key"`:
+			return ErrDuplicateSlug
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m CategoryModel) Get(id int64) (*Category, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := fmt.Sprintf(categorySelect, "c.id = $1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	category, err := scanCategory(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return category, nil
+}
+
+func (m CategoryModel) GetBySlug(slug string) (*Category, error) {
+	if slug == "" {
+		return nil, ErrRecordNotFound
+	}
+
+	query := fmt.Sprintf(categorySelect, "c.slug = $1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	category, err := scanCategory(m.DB.QueryRowContext(ctx, query, slug))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return category, nil
+}
+
+// GetAll returns every category ordered by name. Clients can rebuild the tree from
+// each category's parent_id.
+func (m CategoryModel) GetAll() ([]*Category, error) {
+	query := fmt.Sprintf(categorySelect, "true") + `
+	ORDER BY c.name, c.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []*Category{}
+	for rows.Next() {
+		category, err := scanCategory(rows)
+		if err != nil {
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+
+	return categories, rows.Err()
+}
+
+// MissingSlugs returns those of the given slugs which don't belong to any category.
+func (m CategoryModel) MissingSlugs(slugs []string) ([]string, error) {
+	query := `
+		SELECT ARRAY(
+			SELECT s.slug FROM unnest($1::text[]) AS s(slug)
+			WHERE NOT EXISTS (SELECT 1 FROM categories c WHERE c.slug = s.slug)
+		)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var missing []string
+	err := m.DB.QueryRowContext(ctx, query, pq.Array(slugs)).Scan(pq.Array(&missing))
+	if err != nil {
+		return nil, err
+	}
+
+	return missing, nil
+}
+
+// Update saves the category. It returns ErrCategoryCycle if the new parent is the
+// category itself or one of its descendants.
+func (m CategoryModel) Update(category *Category) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if category.ParentID != nil {
+		// Lock the category and the new parent's ancestors before checking them, so
+		// that two categories being moved under each other at once can't both pass
+		// the check: whichever goes second waits, then finds the cycle. Locking in
+		// order of id keeps the two from deadlocking.
+		_, err := tx.ExecContext(ctx, `
+			WITH RECURSIVE ancestors AS (
+				SELECT id, parent_id FROM categories WHERE id = $1
+				UNION
+				SELECT c.id, c.parent_id FROM categories c JOIN ancestors a ON c.id = a.parent_id
+			)
+			SELECT id FROM categories
+			WHERE id = $2 OR id IN (SELECT id FROM ancestors)
+			ORDER BY id
+			FOR UPDATE`, *category.ParentID, category.ID)
+		if err != nil {
+			return err
+		}
+
+		var cycle bool
+		err = tx.QueryRowContext(ctx, `
+			WITH RECURSIVE ancestors AS (
+				SELECT id, parent_id FROM categories WHERE id = $1
+				UNION
+				SELECT c.id, c.parent_id FROM categories c JOIN ancestors a ON c.id = a.parent_id
+			)
+			SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = $2)`, *category.ParentID, category.ID).Scan(&cycle)
+		if err != nil {
+			return err
+		}
+		if cycle {
+			return ErrCategoryCycle
+		}
+	}
+
+	query := `
+		UPDATE categories
+		SET parent_id = $1, name = $2, slug = $3, description = $4, version = version + 1
+		WHERE id = $5 AND version = $6
+		RETURNING version`
+
+	args := []any{category.ParentID, category.Name, category.Slug, category.Description, category.ID, category.Version}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&category.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "categories_slug_key"`:
+			return ErrDuplicateSlug
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes the category. Its subcategories move up to the top level.
+func (m CategoryModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM categories WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// setPostCategories replaces the categories of a post with those with the given
+// slugs. Slugs which don't match a category are ignored, so callers should check
+// them with MissingSlugs first.
+func setPostCategories(ctx context.Context, tx *sql.Tx, postID int64, slugs []string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM post_categories WHERE post_id = $1`, postID)
+	if err != nil {
+		return err
+	}
+
+	if len(slugs) == 0 {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO post_categories (post_id, category_id)
+		SELECT $1, id FROM categories WHERE slug = ANY($2)`, postID, pq.Array(slugs))
+	return err
+}
@@ -0,0 +1,343 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"blog/internal/data/validator"
+	"github.com/lib/pq"
+)
+
+// Comment statuses. Comments wait in pending until a moderator approves them or marks
+// them as spam; only approved comments are shown. Deleting a comment keeps it, so
+// that replies to it stay in place.
+const (
+	CommentStatusPending  = "pending"
+	CommentStatusApproved = "approved"
+	CommentStatusSpam     = "spam"
+	CommentStatusDeleted  = "deleted"
+)
+
+// CommentStatusRemoved is shown to readers in place of the status of a comment which
+// isn't approved but has approved replies. It is never stored.
+const CommentStatusRemoved = "removed"
+
+var CommentStatuses = []string{CommentStatusPending, CommentStatusApproved, CommentStatusSpam, CommentStatusDeleted}
+
+// commentTransitions maps each status to the statuses a comment may move to from it.
+var commentTransitions = map[string][]string{
+	CommentStatusPending:  {CommentStatusApproved, CommentStatusSpam, CommentStatusDeleted},
+	CommentStatusApproved: {CommentStatusPending, CommentStatusSpam, CommentStatusDeleted},
+	CommentStatusSpam:     {CommentStatusApproved, CommentStatusDeleted},
+	CommentStatusDeleted:  {CommentStatusApproved},
+}
+
+// CommentQuery holds the criteria used when listing comments for moderation. The zero
+// value matches every comment.
+type CommentQuery struct {
+	Status string
+	PostID int64
+}
+
+type Comment struct {
+	ID       int64  `json:"id"`
+	PostID   int64  `json:"post_id"`
+	ParentID *int64 `json:"parent_id,omitempty"`
+	// UserID is the user who wrote the comment, if they were signed in and still
+	// exist. Anonymous comments give their author's name and email instead.
+	UserID      *int64 `json:"user_id,omitempty"`
+	AuthorName  string `json:"author_name"`
+	AuthorEmail string `json:"author_email,omitempty"`
+	Content     string `json:"content"`
+	Status      string `json:"status"`
+	// IPAddress is where the comment was submitted from, for moderators.
+	IPAddress string    `json:"ip_address,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Version   int32     `json:"version"`
+	// Replies holds the answers to the comment when comments are listed as threads.
+	Replies []*Comment `json:"replies,omitempty"`
+}
+
+type CommentModel struct {
+	DB *sql.DB
+}
+
+// CanTransitionTo reports whether the comment may move from its current status to the
+// given one.
+func (c *Comment) CanTransitionTo(status string) bool {
+	return validator.PermittedValue(status, commentTransitions[c.Status]...)
+}
+
+// commentStatusesTo returns the statuses a comment may move to the given one from.
+func commentStatusesTo(status string) []string {
+	var from []string
+	for _, s := range CommentStatuses {
+		if validator.PermittedValue(status, commentTransitions[s]...) {
+			from = append(from, s)
+		}
+	}
+	return from
+}
+
+func ValidateComment(v *validator.Validator, comment *Comment) {
+	v.Check(comment.Content != "", "content", "must be provided")
+	v.Check(len(comment.Content) <= 10_000, "content", "must not be more than 10000 bytes long")
+	v.Check(comment.AuthorName != "", "author_name", "must be provided")
+	v.Check(len(comment.AuthorName) <= 100, "author_name", "must not be more than 100 bytes long")
+	if comment.UserID == nil {
+		v.Check(comment.AuthorEmail != "", "author_email", "must be provided")
+		v.Check(validator.Matches(comment.AuthorEmail, validator.EmailRX), "author_email", "must be a valid email address")
+	}
+}
+
+const commentColumns = `c.id, c.post_id, c.parent_id, c.user_id, c.author_name, c.author_email, c.content,
+		       c.status, c.ip_address, c.created_at, c.updated_at, c.version`
+
+func scanComment(row interface{ Scan(...any) error }) (*Comment, error) {
+	var comment Comment
+	err := row.Scan(
+		&comment.ID,
+		&comment.PostID,
+		&comment.ParentID,
+		&comment.UserID,
+		&comment.AuthorName,
+		&comment.AuthorEmail,
+		&comment.Content,
+		&comment.Status,
+		&comment.IPAddress,
+		&comment.CreatedAt,
+		&comment.UpdatedAt,
+		&comment.Version,
+	)
+	return &comment, err
+}
+
+func (m CommentModel) Insert(comment *Comment) error {
+	query := `
+		INSERT INTO comments (post_id, parent_id, user_id, author_name, author_email, content, status, ip_address)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at, updated_at, version`
+
+	args := []any{
+		comment.PostID, comment.ParentID, comment.UserID, comment.AuthorName,
+		comment.AuthorEmail, comment.Content, comment.Status, comment.IPAddress,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&comment.ID, &comment.CreatedAt, &comment.UpdatedAt, &comment.Version)
+}
+
+func (m CommentModel) Get(id int64) (*Comment, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT ` + commentColumns + `
+		FROM comments c
+		WHERE c.id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	comment, err := scanComment(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return comment, nil
+}
+
+// GetThreads returns the approved comments on a post as threads, oldest first, with
+// replies nested under the comments they answer. A reply to a comment which isn't
+// approved keeps its place under it, but the comment itself is shown with its author
+// and content blanked out and CommentStatusRemoved as its status, so readers can't
+// tell whether it is pending, spam or deleted.
+func (m CommentModel) GetThreads(postID int64) ([]*Comment, error) {
+	query := `
+		WITH RECURSIVE shown AS (
+			SELECT id, parent_id FROM comments WHERE post_id = $1 AND status = 'approved'
+			UNION
+			SELECT c.id, c.parent_id FROM comments c JOIN shown ON c.id = shown.parent_id
+		)
+		SELECT ` + commentColumns + `
+		FROM comments c
+		WHERE c.id IN (SELECT id FROM shown)
+		ORDER BY c.created_at, c.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	threads := []*Comment{}
+	byID := make(map[int64]*Comment)
+
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		// Readers only ever see the author's name and what they wrote.
+		comment.AuthorEmail = ""
+		comment.IPAddress = ""
+		if comment.Status != CommentStatusApproved {
+			comment.UserID = nil
+			comment.AuthorName = ""
+			comment.Content = ""
+			comment.Status = CommentStatusRemoved
+			comment.UpdatedAt = comment.CreatedAt
+		}
+
+		byID[comment.ID] = comment
+		// Replies are always newer than what they answer, so the parent has been seen.
+		if parent := commentParent(byID, comment); parent != nil {
+			parent.Replies = append(parent.Replies, comment)
+		} else {
+			threads = append(threads, comment)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return threads, nil
+}
+
+// commentParent returns the comment which the comment answers, if it has been read.
+func commentParent(byID map[int64]*Comment, comment *Comment) *Comment {
+	if comment.ParentID == nil {
+		return nil
+	}
+	return byID[*comment.ParentID]
+}
+
+// GetAll returns the comments matching the query, for moderators.
+func (m CommentModel) GetAll(q CommentQuery, filters Filters) ([]*Comment, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), `+commentColumns+`
+		FROM comments c
+		WHERE (c.status = $1 OR $1 = '')
+		AND (c.post_id = $2 OR $2 = 0)
+		ORDER BY c.%s %s, c.id ASC
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, q.Status, q.PostID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	comments := []*Comment{}
+
+	for rows.Next() {
+		var comment Comment
+		err := rows.Scan(
+			&totalRecords,
+			&comment.ID,
+			&comment.PostID,
+			&comment.ParentID,
+			&comment.UserID,
+			&comment.AuthorName,
+			&comment.AuthorEmail,
+			&comment.Content,
+			&comment.Status,
+			&comment.IPAddress,
+			&comment.CreatedAt,
+			&comment.UpdatedAt,
+			&comment.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		comments = append(comments, &comment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return comments, metadata, nil
+}
+
+// UpdateStatus saves the comment's status, failing with ErrEditConflict if the comment
+// has changed since it was read.
+func (m CommentModel) UpdateStatus(comment *Comment) error {
+	query := `
+		UPDATE comments
+		SET status = $1, updated_at = NOW(), version = version + 1
+		WHERE id = $2 AND version = $3
+		RETURNING updated_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, comment.Status, comment.ID, comment.Version).Scan(&comment.UpdatedAt, &comment.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetStatuses moves each of the comments with the given ids that may move to status
+// there, returning those which were moved. Comments which don't exist or can't make
+// the transition are left alone.
+func (m CommentModel) SetStatuses(ids []int64, status string) ([]*Comment, error) {
+	query := `
+		UPDATE comments c
+		SET status = $1, updated_at = NOW(), version = version + 1
+		WHERE c.id = ANY($2) AND c.status = ANY($3)
+		RETURNING ` + commentColumns
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, status, pq.Array(ids), pq.Array(commentStatusesTo(status)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []*Comment{}
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
@@ -0,0 +1,163 @@
+package data
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// Cursor marks a position in a listing for keyset pagination: the sort it was made
+// for, and the sort key and id of the row next to the position. Before is set on
+// cursors pointing at the page before the row rather than after it.
+type Cursor struct {
+	Sort   string `json:"s"`
+	Key    string `json:"k"`
+	ID     int64  `json:"i"`
+	Before bool   `json:"b,omitempty"`
+}
+
+// EncodeCursor serializes the cursor and signs it with the key, so that clients can't
+// hand back a cursor they've tampered with.
+func EncodeCursor(c *Cursor, key []byte) (string, error) {
+	js, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(js)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signCursor(payload, key)), nil
+}
+
+// DecodeCursor checks the signature on an encoded cursor and returns the cursor. It
+// returns ErrInvalidCursor if the cursor is malformed or wasn't signed with key.
+func DecodeCursor(s string, key []byte) (*Cursor, error) {
+	payload, sig, ok := strings.Cut(s, ".")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, signCursor(payload, key)) {
+		return nil, ErrInvalidCursor
+	}
+
+	js, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c Cursor
+	if err := json.Unmarshal(js, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+func signCursor(payload string, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// keyset holds what a listing query needs to page with a cursor rather than an
+// offset. keyExpr is the SQL expression for the sort column, and idExpr the one for
+// the id which breaks ties.
+type keyset struct {
+	filters Filters
+	keyExpr string
+	idExpr  string
+}
+
+// backward reports whether the query has to walk the listing in reverse, which is how
+// the page before a cursor is fetched.
+func (k keyset) backward() bool {
+	return k.filters.Cursor != nil && k.filters.Cursor.Before
+}
+
+func (k keyset) direction() string {
+	dir := k.filters.sortDirection()
+	if k.backward() {
+		if dir == "ASC" {
+			return "DESC"
+		}
+		return "ASC"
+	}
+	return dir
+}
+
+// where returns the condition selecting rows past the cursor, using parameters $n and
+// $n+1, along with their arguments. It returns an empty condition without a cursor.
+func (k keyset) where(n int) (string, []any) {
+	if k.filters.Cursor == nil {
+		return "", nil
+	}
+
+	op := ">"
+	if k.direction() == "DESC" {
+		op = "<"
+	}
+	cond := fmt.Sprintf("AND (%s, %s) %s ($%d, $%d)", k.keyExpr, k.idExpr, op, n, n+1)
+	return cond, []any{k.filters.Cursor.Key, k.filters.Cursor.ID}
+}
+
+func (k keyset) orderBy() string {
+	dir := k.direction()
+	return fmt.Sprintf("%s %s, %s %s", k.keyExpr, dir, k.idExpr, dir)
+}
+
+// limit is one more than the page size, so that we can tell whether there are more
+// rows after the page.
+func (k keyset) limit() int {
+	return k.filters.Limit + 1
+}
+
+// keysetPage trims the extra row fetched by limit() off rows and puts them back in
+// listing order if they were fetched backwards. It returns the trimmed rows and the
+// cursors either side of them, using sortKey to read the sort key and id of a row.
+func keysetPage[T any](k keyset, rows []T, sortKey func(T) (string, int64)) ([]T, Metadata) {
+	more := len(rows) > k.filters.Limit
+	if more {
+		rows = rows[:k.filters.Limit]
+	}
+	if k.backward() {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+
+	metadata := Metadata{PageSize: k.filters.Limit}
+	if len(rows) == 0 {
+		return rows, metadata
+	}
+
+	cursorAt := func(row T, before bool) *Cursor {
+		key, id := sortKey(row)
+		return &Cursor{Sort: k.filters.Sort, Key: key, ID: id, Before: before}
+	}
+
+	// Going forwards there's a previous page whenever we started from a cursor, and a
+	// next page if the extra row turned up. Going backwards it's the other way round.
+	if k.backward() {
+		metadata.Next = cursorAt(rows[len(rows)-1], false)
+		if more {
+			metadata.Prev = cursorAt(rows[0], true)
+		}
+	} else {
+		if more {
+			metadata.Next = cursorAt(rows[len(rows)-1], false)
+		}
+		if k.filters.Cursor != nil {
+			metadata.Prev = cursorAt(rows[0], true)
+		}
+	}
+
+	return rows, metadata
+}
@@ -12,6 +12,10 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	// Cursor and Limit switch a listing to keyset pagination, which stays fast however
+	// deep a client pages, in place of Page and PageSize.
+	Cursor *Cursor
+	Limit  int
 }
 
 // Define a new Metadata struct for holding the pagination metadata.
@@ -21,6 +25,11 @@ type Metadata struct {
 	FirstPage    int `json:"first_page,omitempty"`
 	LastPage     int `json:"last_page,omitempty"`
 	TotalRecords int `json:"total_records,omitempty"`
+	// In cursor mode the handlers encode Next and Prev into NextCursor and PrevCursor.
+	NextCursor string  `json:"next_cursor,omitempty"`
+	PrevCursor string  `json:"prev_cursor,omitempty"`
+	Next       *Cursor `json:"-"`
+	Prev       *Cursor `json:"-"`
 }
 
 // The calculateMetadata() function calculates the appropriate pagination metadata
@@ -61,6 +70,13 @@ func (f Filters) sortDirection() string {
 	}
 	return "ASC"
 }
+
+// UsesCursor reports whether the listing is paged with a cursor rather than by page
+// number.
+func (f Filters) UsesCursor() bool {
+	return f.Cursor != nil || f.Limit > 0
+}
+
 func (f Filters) limit() int {
 	return f.PageSize
 }
@@ -76,4 +92,9 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 
 	// Check that the sort parameter matches a value in the safelist.
 	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+
+	if f.UsesCursor() {
+		v.Check(f.Limit > 0, "limit", "must be greater than zero")
+		v.Check(f.Limit <= 100, "limit", "must be a maximum of 100")
+	}
 }
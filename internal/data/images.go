@@ -3,15 +3,28 @@ package data
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strconv"
 	"time"
 
 	"blog/internal/data/validator"
+	"blog/internal/imaging"
+
+	"github.com/lib/pq"
 )
 
+// ErrAlreadyAttached is returned when attaching an image to a post it's already
+// attached to.
+var ErrAlreadyAttached = errors.New("image already attached to post")
+
+// Image is an item of the media library. Images can be attached to any number of
+// posts; when an image is read as one of a post's, PostID, IsFeatured and SortOrder
+// describe its attachment to that post.
 type Image struct {
 	ID               int64     `json:"id"`
-	PostID           int64     `json:"post_id"`
+	PostID           int64     `json:"post_id,omitempty"`
 	Filename         string    `json:"filename"`
 	OriginalFilename string    `json:"original_filename"`
 	FilePath         string    `json:"file_path"`
@@ -26,57 +39,228 @@ type Image struct {
 	CreatedAt        time.Time `json:"created_at"`
 	UpdatedAt        time.Time `json:"updated_at"`
 	Version          int32     `json:"version"`
+	// BlobID is the stored file the image is an attachment of, and SHA256 the hash of
+	// its contents. Images uploaded before uploads were hashed have no SHA256.
+	BlobID int64   `json:"-"`
+	SHA256 *string `json:"sha256,omitempty"`
+	// UserID is the user who uploaded the image, if they still exist.
+	UserID *int64 `json:"user_id,omitempty"`
+	// Metadata is what was kept of the EXIF of the uploaded file, which isn't stored.
+	Metadata *imaging.Metadata `json:"metadata,omitempty"`
+	// BlurHash and LQIP are placeholders to show while the image loads: its BlurHash,
+	// and a tiny copy of it as a data URL.
+	BlurHash *string `json:"blurhash,omitempty"`
+	LQIP     *string `json:"lqip,omitempty"`
+	// PostIDs lists the posts the image is attached to. It is only filled in when the
+	// image is read from the library rather than as one of a post's.
+	PostIDs []int64 `json:"post_ids,omitempty"`
+	// Srcset offers the image in each configured variant width, for responsive <img>
+	// tags. It is filled in by the API when the image is returned.
+	Srcset string `json:"srcset,omitempty"`
+}
+
+// OwnedBy reports whether the image was uploaded by the user with the given id.
+func (image *Image) OwnedBy(userID int64) bool {
+	return image.UserID != nil && *image.UserID == userID
+}
+
+// imageColumns are the columns read for an image, from images i joined to its blob b.
+// libraryColumns add the posts it's attached to, and attachmentColumns the details of
+// its attachment to one post from post_images pi.
+const (
+	imageColumns = `
+		i.id, i.filename, i.original_filename, b.file_path, b.file_size, b.mime_type,
+		b.width, b.height, i.alt_text, i.caption, i.created_at, i.updated_at, i.version,
+		b.id, b.sha256, i.user_id, i.metadata, i.blurhash, i.lqip`
+	libraryColumns = imageColumns + `,
+		ARRAY(SELECT post_id FROM post_images WHERE image_id = i.id ORDER BY post_id)`
+	attachmentColumns = imageColumns + `,
+		pi.post_id, pi.is_featured, pi.sort_order`
+)
+
+// imageDest returns the destinations to scan imageColumns into.
+func imageDest(image *Image) []any {
+	return []any{
+		&image.ID, &image.Filename, &image.OriginalFilename, &image.FilePath,
+		&image.FileSize, &image.MimeType, &image.Width, &image.Height, &image.AltText,
+		&image.Caption, &image.CreatedAt, &image.UpdatedAt, &image.Version,
+		&image.BlobID, &image.SHA256, &image.UserID, jsonColumn{&image.Metadata},
+		&image.BlurHash, &image.LQIP,
+	}
+}
+
+// libraryDest returns the destinations to scan libraryColumns into.
+func libraryDest(image *Image) []any {
+	return append(imageDest(image), pq.Array(&image.PostIDs))
+}
+
+// attachmentDest returns the destinations to scan attachmentColumns into.
+func attachmentDest(image *Image) []any {
+	return append(imageDest(image), &image.PostID, &image.IsFeatured, &image.SortOrder)
+}
+
+// jsonColumn scans a JSON column into the value v points to, leaving it alone if the
+// column is NULL.
+type jsonColumn struct {
+	v any
+}
+
+func (c jsonColumn) Scan(src any) error {
+	switch src := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(src, c.v)
+	case string:
+		return json.Unmarshal([]byte(src), c.v)
+	default:
+		return fmt.Errorf("cannot scan %T into a JSON column", src)
+	}
 }
 
 type ImageModel struct {
 	DB *sql.DB
 }
 
-func (i ImageModel) Insert(image *Image) error {
+// Insert adds an image to the library, attaching it to image.PostID unless that is
+// zero, as the post's featured image in place of any other if image.IsFeatured. Its file is recorded as a blob, unless there's already a blob with the same
+// SHA-256, in which case the image shares that one and takes on its file details.
+// store is called to save the file only when a new blob is recorded, before it's
+// committed, so that the file is never missing while the blob can be seen; if store
+// fails nothing is inserted.
+func (i ImageModel) Insert(image *Image, store func() error) error {
+	// Allow for storing the file, which may be remote.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := i.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The no-op update makes RETURNING give back the existing blob on a conflict, and
+	// locks it so that it can't be deleted as unused before the image is attached.
+	// xmax is only zero for a row the statement inserted.
+	var created bool
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO image_blobs (sha256, file_path, file_size, mime_type, width, height)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (sha256) DO UPDATE SET sha256 = EXCLUDED.sha256
+		RETURNING id, file_path, file_size, mime_type, width, height, xmax = 0`,
+		image.SHA256, image.FilePath, image.FileSize, image.MimeType, image.Width, image.Height,
+	).Scan(&image.BlobID, &image.FilePath, &image.FileSize, &image.MimeType, &image.Width, &image.Height, &created)
+	if err != nil {
+		return err
+	}
+
+	if created {
+		err = store()
+		if err != nil {
+			return err
+		}
+	}
+
 	query := `
-		INSERT INTO images (post_id, filename, original_filename, file_path, file_size, 
-		                   mime_type, width, height, alt_text, caption, is_featured, sort_order)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		INSERT INTO images (blob_id, user_id, filename, original_filename, alt_text, caption,
+		                    metadata, blurhash, lqip)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 		RETURNING id, created_at, updated_at, version`
 
-	args := []interface{}{
-		image.PostID, image.Filename, image.OriginalFilename, image.FilePath,
-		image.FileSize, image.MimeType, image.Width, image.Height,
-		image.AltText, image.Caption, image.IsFeatured, image.SortOrder,
+	// lib/pq sends []byte as bytea, so the JSON goes as a string.
+	var metadata any
+	if image.Metadata != nil {
+		js, err := json.Marshal(image.Metadata)
+		if err != nil {
+			return err
+		}
+		metadata = string(js)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	args := []interface{}{
+		image.BlobID, image.UserID, image.Filename, image.OriginalFilename,
+		image.AltText, image.Caption, metadata, image.BlurHash, image.LQIP,
+	}
 
-	return i.DB.QueryRowContext(ctx, query, args...).Scan(
+	err = tx.QueryRowContext(ctx, query, args...).Scan(
 		&image.ID, &image.CreatedAt, &image.UpdatedAt, &image.Version,
 	)
+	if err != nil {
+		return err
+	}
+
+	if image.PostID != 0 {
+		if image.IsFeatured {
+			_, err = tx.ExecContext(ctx, `
+				UPDATE post_images SET is_featured = false
+				WHERE post_id = $1 AND is_featured = true`, image.PostID)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO post_images (post_id, image_id, is_featured, sort_order)
+			VALUES ($1, $2, $3, $4)`,
+			image.PostID, image.ID, image.IsFeatured, image.SortOrder)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
+// Get returns an image from the library, along with the posts it's attached to.
 func (i ImageModel) Get(id int64) (*Image, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
 	query := `
-		SELECT id, post_id, filename, original_filename, file_path, file_size,
-		       mime_type, width, height, alt_text, caption, is_featured, sort_order,
-		       created_at, updated_at, version
-		FROM images
-		WHERE id = $1`
+		SELECT ` + libraryColumns + `
+		FROM images i
+		JOIN image_blobs b ON b.id = i.blob_id
+		WHERE i.id = $1`
 
 	var image Image
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := i.DB.QueryRowContext(ctx, query, id).Scan(
-		&image.ID, &image.PostID, &image.Filename, &image.OriginalFilename,
-		&image.FilePath, &image.FileSize, &image.MimeType, &image.Width,
-		&image.Height, &image.AltText, &image.Caption, &image.IsFeatured,
-		&image.SortOrder, &image.CreatedAt, &image.UpdatedAt, &image.Version,
-	)
+	err := i.DB.QueryRowContext(ctx, query, id).Scan(libraryDest(&image)...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
 
+	return &image, nil
+}
+
+// GetForPost returns an image as one of the post's, or ErrRecordNotFound if it isn't
+// attached to the post.
+func (i ImageModel) GetForPost(postID, id int64) (*Image, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT ` + attachmentColumns + `
+		FROM post_images pi
+		JOIN images i ON i.id = pi.image_id
+		JOIN image_blobs b ON b.id = i.blob_id
+		WHERE pi.post_id = $1 AND pi.image_id = $2`
+
+	var image Image
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := i.DB.QueryRowContext(ctx, query, postID, id).Scan(attachmentDest(&image)...)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -89,14 +273,72 @@ func (i ImageModel) Get(id int64) (*Image, error) {
 	return &image, nil
 }
 
+// GetAll returns a page of the media library, optionally only the images whose alt
+// text or caption match a search, paged either by page number or with a cursor.
+func (i ImageModel) GetAll(search string, filters Filters) ([]*Image, Metadata, error) {
+	count := "count(*) OVER()"
+	clauses := fmt.Sprintf("ORDER BY i.%s %s, i.id ASC LIMIT $2 OFFSET $3", filters.sortColumn(), filters.sortDirection())
+	args := []any{search, filters.limit(), filters.offset()}
+
+	var ks *keyset
+	if filters.UsesCursor() {
+		ks = &keyset{filters: filters, keyExpr: "i." + filters.sortColumn(), idExpr: "i.id"}
+		where, keyArgs := ks.where(3)
+		count = "0"
+		clauses = fmt.Sprintf("%s ORDER BY %s LIMIT $2", where, ks.orderBy())
+		args = append([]any{search, ks.limit()}, keyArgs...)
+	}
+
+	// The search matches the expression of images_search_idx, so that it can use it.
+	query := fmt.Sprintf(`
+		SELECT %s, %s
+		FROM images i
+		JOIN image_blobs b ON b.id = i.blob_id
+		WHERE ($1 = '' OR to_tsvector('english', COALESCE(i.alt_text, '') || ' ' || COALESCE(i.caption, ''))
+		                  @@ plainto_tsquery('english', $1))
+		%s`, count, libraryColumns, clauses)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := i.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	images := []*Image{}
+	for rows.Next() {
+		var image Image
+		err := rows.Scan(append([]any{&totalRecords}, libraryDest(&image)...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		images = append(images, &image)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	if ks != nil {
+		images, metadata := keysetPage(*ks, images, imageSortKey(filters.sortColumn()))
+		return images, metadata, nil
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return images, metadata, nil
+}
+
 func (i ImageModel) GetByPostID(postID int64) ([]*Image, error) {
 	query := `
-		SELECT id, post_id, filename, original_filename, file_path, file_size,
-		       mime_type, width, height, alt_text, caption, is_featured, sort_order,
-		       created_at, updated_at, version
-		FROM images
-		WHERE post_id = $1
-		ORDER BY sort_order, created_at`
+		SELECT ` + attachmentColumns + `
+		FROM post_images pi
+		JOIN images i ON i.id = pi.image_id
+		JOIN image_blobs b ON b.id = i.blob_id
+		WHERE pi.post_id = $1
+		ORDER BY pi.sort_order, pi.created_at`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -110,12 +352,7 @@ func (i ImageModel) GetByPostID(postID int64) ([]*Image, error) {
 	images := []*Image{}
 	for rows.Next() {
 		var image Image
-		err := rows.Scan(
-			&image.ID, &image.PostID, &image.Filename, &image.OriginalFilename,
-			&image.FilePath, &image.FileSize, &image.MimeType, &image.Width,
-			&image.Height, &image.AltText, &image.Caption, &image.IsFeatured,
-			&image.SortOrder, &image.CreatedAt, &image.UpdatedAt, &image.Version,
-		)
+		err := rows.Scan(attachmentDest(&image)...)
 		if err != nil {
 			return nil, err
 		}
@@ -125,25 +362,100 @@ func (i ImageModel) GetByPostID(postID int64) ([]*Image, error) {
 	return images, rows.Err()
 }
 
+// attachmentSortColumns maps the sort columns of a post's images to their SQL: the
+// gallery order is the attachment's, the rest the image's.
+var attachmentSortColumns = map[string]string{
+	"sort_order": "pi.sort_order",
+	"created_at": "i.created_at",
+	"id":         "i.id",
+}
+
+// GetPageByPostID returns one page of a post's images, paged either by page number or
+// with a cursor.
+func (i ImageModel) GetPageByPostID(postID int64, filters Filters) ([]*Image, Metadata, error) {
+	column := attachmentSortColumns[filters.sortColumn()]
+	count := "count(*) OVER()"
+	clauses := fmt.Sprintf("ORDER BY %s %s, i.id ASC LIMIT $2 OFFSET $3", column, filters.sortDirection())
+	args := []any{postID, filters.limit(), filters.offset()}
+
+	var ks *keyset
+	if filters.UsesCursor() {
+		ks = &keyset{filters: filters, keyExpr: column, idExpr: "i.id"}
+		where, keyArgs := ks.where(3)
+		count = "0"
+		clauses = fmt.Sprintf("%s ORDER BY %s LIMIT $2", where, ks.orderBy())
+		args = append([]any{postID, ks.limit()}, keyArgs...)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s, %s
+		FROM post_images pi
+		JOIN images i ON i.id = pi.image_id
+		JOIN image_blobs b ON b.id = i.blob_id
+		WHERE pi.post_id = $1
+		%s`, count, attachmentColumns, clauses)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := i.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	images := []*Image{}
+	for rows.Next() {
+		var image Image
+		err := rows.Scan(append([]any{&totalRecords}, attachmentDest(&image)...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		images = append(images, &image)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	if ks != nil {
+		images, metadata := keysetPage(*ks, images, imageSortKey(filters.sortColumn()))
+		return images, metadata, nil
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return images, metadata, nil
+}
+
+// imageSortKey returns a function reading the value of the sort column, as text, from
+// an image, to be stored in a cursor.
+func imageSortKey(column string) func(*Image) (string, int64) {
+	return func(image *Image) (string, int64) {
+		switch column {
+		case "sort_order":
+			return strconv.Itoa(image.SortOrder), image.ID
+		case "created_at":
+			return image.CreatedAt.Format(time.RFC3339Nano), image.ID
+		default:
+			return strconv.FormatInt(image.ID, 10), image.ID
+		}
+	}
+}
+
 func (i ImageModel) GetFeaturedByPostID(postID int64) (*Image, error) {
 	query := `
-		SELECT id, post_id, filename, original_filename, file_path, file_size,
-		       mime_type, width, height, alt_text, caption, is_featured, sort_order,
-		       created_at, updated_at, version
-		FROM images
-		WHERE post_id = $1 AND is_featured = true`
+		SELECT ` + attachmentColumns + `
+		FROM post_images pi
+		JOIN images i ON i.id = pi.image_id
+		JOIN image_blobs b ON b.id = i.blob_id
+		WHERE pi.post_id = $1 AND pi.is_featured = true`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	var image Image
-	err := i.DB.QueryRowContext(ctx, query, postID).Scan(
-		&image.ID, &image.PostID, &image.Filename, &image.OriginalFilename,
-		&image.FilePath, &image.FileSize, &image.MimeType, &image.Width,
-		&image.Height, &image.AltText, &image.Caption, &image.IsFeatured,
-		&image.SortOrder, &image.CreatedAt, &image.UpdatedAt, &image.Version,
-	)
-
+	err := i.DB.QueryRowContext(ctx, query, postID).Scan(attachmentDest(&image)...)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -156,26 +468,32 @@ func (i ImageModel) GetFeaturedByPostID(postID int64) (*Image, error) {
 	return &image, nil
 }
 
+// Update saves an image's details, and if it was read as one of a post's, its
+// attachment to that post too, making it the post's featured image in place of any
+// other if image.IsFeatured. Nothing is saved if the image's version has moved on.
 func (i ImageModel) Update(image *Image) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := i.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
 		UPDATE images 
-		SET filename = $2, original_filename = $3, file_path = $4, file_size = $5,
-		    mime_type = $6, width = $7, height = $8, alt_text = $9, caption = $10,
-		    is_featured = $11, sort_order = $12, updated_at = NOW(), version = version + 1
-		WHERE id = $1 AND version = $13
+		SET filename = $2, original_filename = $3, alt_text = $4, caption = $5,
+		    updated_at = NOW(), version = version + 1
+		WHERE id = $1 AND version = $6
 		RETURNING version`
 
 	args := []interface{}{
-		image.ID, image.Filename, image.OriginalFilename, image.FilePath,
-		image.FileSize, image.MimeType, image.Width, image.Height,
-		image.AltText, image.Caption, image.IsFeatured, image.SortOrder,
-		image.Version,
+		image.ID, image.Filename, image.OriginalFilename,
+		image.AltText, image.Caption, image.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	err := i.DB.QueryRowContext(ctx, query, args...).Scan(&image.Version)
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&image.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -185,9 +503,40 @@ func (i ImageModel) Update(image *Image) error {
 		}
 	}
 
-	return nil
+	if image.PostID != 0 {
+		if image.IsFeatured {
+			_, err = tx.ExecContext(ctx, `
+				UPDATE post_images SET is_featured = false
+				WHERE post_id = $1 AND image_id <> $2 AND is_featured = true`,
+				image.PostID, image.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		result, err := tx.ExecContext(ctx, `
+			UPDATE post_images SET is_featured = $3, sort_order = $4
+			WHERE post_id = $1 AND image_id = $2`,
+			image.PostID, image.ID, image.IsFeatured, image.SortOrder)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		// The image was detached from the post in the meantime.
+		if rowsAffected == 0 {
+			return ErrEditConflict
+		}
+	}
+
+	return tx.Commit()
 }
 
+// SetFeatured makes the image the post's featured image, in place of any other. An
+// imageID of zero only clears the featured image, and returns ErrRecordNotFound.
 func (i ImageModel) SetFeatured(postID, imageID int64) error {
 	tx, err := i.DB.Begin()
 	if err != nil {
@@ -200,7 +549,7 @@ func (i ImageModel) SetFeatured(postID, imageID int64) error {
 
 	// Remove featured flag from all images for this post
 	_, err = tx.ExecContext(ctx, `
-		UPDATE images SET is_featured = false, updated_at = NOW(), version = version + 1
+		UPDATE post_images SET is_featured = false
 		WHERE post_id = $1 AND is_featured = true`, postID)
 	if err != nil {
 		return err
@@ -208,8 +557,8 @@ func (i ImageModel) SetFeatured(postID, imageID int64) error {
 
 	// Set new featured image
 	result, err := tx.ExecContext(ctx, `
-		UPDATE images SET is_featured = true, updated_at = NOW(), version = version + 1
-		WHERE id = $1 AND post_id = $2`, imageID, postID)
+		UPDATE post_images SET is_featured = true
+		WHERE image_id = $1 AND post_id = $2`, imageID, postID)
 	if err != nil {
 		return err
 	}
@@ -226,17 +575,57 @@ func (i ImageModel) SetFeatured(postID, imageID int64) error {
 	return tx.Commit()
 }
 
-func (i ImageModel) Delete(id int64) error {
-	if id < 1 {
-		return ErrRecordNotFound
+// Attach attaches a library image to the post image.PostID, with the image's
+// IsFeatured and SortOrder. It returns ErrAlreadyAttached if the image is already one
+// of the post's.
+func (i ImageModel) Attach(image *Image) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := i.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback()
 
-	query := `DELETE FROM images WHERE id = $1`
+	if image.IsFeatured {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE post_images SET is_featured = false
+			WHERE post_id = $1 AND is_featured = true`, image.PostID)
+		if err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO post_images (post_id, image_id, is_featured, sort_order)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (post_id, image_id) DO NOTHING`,
+		image.PostID, image.ID, image.IsFeatured, image.SortOrder)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrAlreadyAttached
+	}
+
+	return tx.Commit()
+}
+
+// Detach removes an image from a post. The image itself stays in the library.
+func (i ImageModel) Detach(postID, imageID int64) error {
+	query := `DELETE FROM post_images WHERE post_id = $1 AND image_id = $2`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := i.DB.ExecContext(ctx, query, id)
+	result, err := i.DB.ExecContext(ctx, query, postID, imageID)
 	if err != nil {
 		return err
 	}
@@ -253,10 +642,56 @@ func (i ImageModel) Delete(id int64) error {
 	return nil
 }
 
-func (i ImageModel) UpdateSortOrder(postID int64, imageOrders []struct {
+// Delete deletes an image from the library, detaching it from every post. Users
+// with the image as their avatar are left without one, unless another image still
+// has the same file.
+func (i ImageModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM images WHERE id = $1 RETURNING filename`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := i.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var filename string
+	err = tx.QueryRowContext(ctx, query, id).Scan(&filename)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users SET avatar_image = NULL, version = version + 1
+		WHERE avatar_image = $1 AND NOT EXISTS (SELECT 1 FROM images WHERE filename = $1)`, filename)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ImageOrder gives an image its position in its post's gallery.
+type ImageOrder struct {
 	ID    int64 `json:"id"`
 	Order int   `json:"order"`
-}) error {
+}
+
+// UpdateSortOrder sets the sort order of several of a post's images in one
+// transaction. If any of them isn't an image of the post nothing is changed and
+// ErrRecordNotFound is returned.
+func (i ImageModel) UpdateSortOrder(postID int64, imageOrders []ImageOrder) error {
 	if len(imageOrders) == 0 {
 		return nil
 	}
@@ -271,43 +706,96 @@ func (i ImageModel) UpdateSortOrder(postID int64, imageOrders []struct {
 	defer cancel()
 
 	stmt, err := tx.PrepareContext(ctx, `
-		UPDATE images SET sort_order = $1, updated_at = NOW(), version = version + 1
-		WHERE id = $2 AND post_id = $3`)
+		UPDATE post_images SET sort_order = $1
+		WHERE image_id = $2 AND post_id = $3`)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
 	for _, order := range imageOrders {
-		_, err = stmt.ExecContext(ctx, order.Order, order.ID, postID)
+		result, err := stmt.ExecContext(ctx, order.Order, order.ID, postID)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
 		if err != nil {
 			return err
 		}
+		if rowsAffected == 0 {
+			return ErrRecordNotFound
+		}
 	}
 
 	return tx.Commit()
 }
 
+// UpdateMetadata saves the alt text and caption of several images in one
+// transaction, checking each image's version as Update does. The returned slice holds
+// an error for each image which couldn't be saved, or nil; if there are any, nothing
+// is changed.
+func (i ImageModel) UpdateMetadata(images []*Image) ([]error, error) {
+	tx, err := i.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt, err := tx.PrepareContext(ctx, `
+		UPDATE images
+		SET alt_text = $2, caption = $3, updated_at = NOW(), version = version + 1
+		WHERE id = $1 AND version = $4
+		RETURNING updated_at, version`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	errs := make([]error, len(images))
+	failed := false
+	for n, image := range images {
+		err := stmt.QueryRowContext(ctx, image.ID, image.AltText, image.Caption, image.Version).Scan(
+			&image.UpdatedAt, &image.Version,
+		)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				errs[n] = ErrEditConflict
+				failed = true
+			default:
+				return nil, err
+			}
+		}
+	}
+
+	if failed {
+		return errs, nil
+	}
+
+	return errs, tx.Commit()
+}
+
+// GetByFilename returns an image from the library by its filename. Images with the
+// same contents share a filename, so it returns the first of them.
 func (i ImageModel) GetByFilename(filename string) (*Image, error) {
 	query := `
-		SELECT id, post_id, filename, original_filename, file_path, file_size,
-		       mime_type, width, height, alt_text, caption, is_featured, sort_order,
-		       created_at, updated_at, version
-		FROM images
-		WHERE filename = $1`
+		SELECT ` + imageColumns + `
+		FROM images i
+		JOIN image_blobs b ON b.id = i.blob_id
+		WHERE i.filename = $1
+		ORDER BY i.id
+		LIMIT 1`
 
 	var image Image
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := i.DB.QueryRowContext(ctx, query, filename).Scan(
-		&image.ID, &image.PostID, &image.Filename, &image.OriginalFilename,
-		&image.FilePath, &image.FileSize, &image.MimeType, &image.Width,
-		&image.Height, &image.AltText, &image.Caption, &image.IsFeatured,
-		&image.SortOrder, &image.CreatedAt, &image.UpdatedAt, &image.Version,
-	)
-
+	err := i.DB.QueryRowContext(ctx, query, filename).Scan(imageDest(&image)...)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -320,14 +808,60 @@ func (i ImageModel) GetByFilename(filename string) (*Image, error) {
 	return &image, nil
 }
 
-type Validator struct{}
+// GetMissingPlaceholders returns up to limit images without placeholders, one for
+// each blob with an id above afterBlobID, in order of blob.
+func (i ImageModel) GetMissingPlaceholders(afterBlobID int64, limit int) ([]*Image, error) {
+	query := `
+		SELECT DISTINCT ON (b.id) ` + imageColumns + `
+		FROM images i
+		JOIN image_blobs b ON b.id = i.blob_id
+		WHERE i.blurhash IS NULL AND b.id > $1
+		ORDER BY b.id, i.id
+		LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := i.DB.QueryContext(ctx, query, afterBlobID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-func (v Validator) Check(b bool, s string, s2 string) bool {
-	return true
+	images := []*Image{}
+	for rows.Next() {
+		var image Image
+		if err := rows.Scan(imageDest(&image)...); err != nil {
+			return nil, err
+		}
+		images = append(images, &image)
+	}
+
+	return images, rows.Err()
+}
+
+// SetPlaceholders fills in the placeholders of every image of the blob which doesn't
+// have them yet. Placeholders come from the file, so they're the same for all of them.
+// It returns the number of images updated.
+func (i ImageModel) SetPlaceholders(blobID int64, blurHash, lqip string) (int64, error) {
+	query := `
+		UPDATE images
+		SET blurhash = $2, lqip = $3
+		WHERE blob_id = $1 AND blurhash IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := i.DB.ExecContext(ctx, query, blobID, blurHash, lqip)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
 }
 
 func ValidateImage(v *validator.Validator, image *Image) {
-	v.Check(image.PostID > 0, "post_id", "must be a positive integer")
+	v.Check(image.PostID >= 0, "post_id", "must not be negative")
 	v.Check(image.Filename != "", "filename", "must be provided")
 	v.Check(len(image.Filename) <= 255, "filename", "must not be more than 255 bytes long")
 	v.Check(image.OriginalFilename != "", "original_filename", "must be provided")
@@ -337,7 +871,7 @@ func ValidateImage(v *validator.Validator, image *Image) {
 	v.Check(image.FileSize > 0, "file_size", "must be a positive integer")
 	v.Check(image.FileSize <= 10*1024*1024, "file_size", "must not be larger than 10MB")
 	v.Check(image.MimeType != "", "mime_type", "must be provided")
-	v.Check(PermittedValue(image.MimeType, "image/jpeg", "image/png", "image/gif", "image/webp"), "mime_type", "must be a valid image format")
+	v.Check(validator.PermittedValue(image.MimeType, "image/jpeg", "image/png", "image/gif", "image/webp"), "mime_type", "must be a valid image format")
 
 	if image.AltText != nil {
 		v.Check(len(*image.AltText) <= 500, "alt_text", "must not be more than 500 bytes long")
@@ -349,7 +883,3 @@ func ValidateImage(v *validator.Validator, image *Image) {
 
 	v.Check(image.SortOrder >= 0, "sort_order", "must not be negative")
 }
-
-func PermittedValue(mimeType string, s string, s2 string, s3 string, s4 string) bool {
-	return true
-}
@@ -12,10 +12,19 @@ var (
 
 // Create a Models struct which wraps the data models for our application.
 type Models struct {
+	Blobs       BlobModel
+	Categories  CategoryModel
+	Comments    CommentModel
 	Posts       PostModel
+	Revisions   RevisionModel
 	Images      ImageModel
+	Variants    ImageVariantModel
 	Permissions PermissionModel
+	Spam        SpamModel
+	Suggest     SuggestModel
+	Tags        TagModel
 	Tokens      TokenModel
+	Uploads     UploadModel
 	Users       UserModel
 }
 
@@ -23,10 +32,19 @@ type Models struct {
 // the initialized data models.
 func NewModels(db *sql.DB) Models {
 	return Models{
+		Blobs:       BlobModel{DB: db},
+		Categories:  CategoryModel{DB: db},
+		Comments:    CommentModel{DB: db},
 		Posts:       PostModel{DB: db},
+		Revisions:   RevisionModel{DB: db},
 		Images:      ImageModel{DB: db},
+		Variants:    ImageVariantModel{DB: db},
 		Permissions: PermissionModel{DB: db},
+		Spam:        SpamModel{DB: db},
+		Suggest:     SuggestModel{DB: db},
+		Tags:        TagModel{DB: db},
 		Tokens:      TokenModel{DB: db},
+		Uploads:     UploadModel{DB: db},
 		Users:       UserModel{DB: db},
 	}
 }
@@ -6,41 +6,179 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	"blog/internal/data/validator"
+	"blog/internal/markdown"
+	"github.com/lib/pq"
 )
 
+// Post statuses. A post moves draft -> in_review -> published -> archived, and can be
+// sent back to draft from any later state. Posts published with a future published_at
+// wait in scheduled until the scheduler publishes them.
+const (
+	PostStatusDraft     = "draft"
+	PostStatusInReview  = "in_review"
+	PostStatusScheduled = "scheduled"
+	PostStatusPublished = "published"
+	PostStatusArchived  = "archived"
+)
+
+var PostStatuses = []string{PostStatusDraft, PostStatusInReview, PostStatusScheduled, PostStatusPublished, PostStatusArchived}
+
+// postTransitions maps each status to the statuses a post may move to from it. Drafts
+// can't skip review, so they go out only after being submitted.
+var postTransitions = map[string][]string{
+	PostStatusDraft:     {PostStatusInReview},
+	PostStatusInReview:  {PostStatusDraft, PostStatusScheduled, PostStatusPublished},
+	PostStatusScheduled: {PostStatusDraft, PostStatusPublished},
+	PostStatusPublished: {PostStatusDraft, PostStatusArchived},
+	PostStatusArchived:  {PostStatusDraft},
+}
+
+// PostQuery holds the criteria used when listing posts. The zero value matches every
+// published post.
+type PostQuery struct {
+	Title    string
+	AuthorID int64
+	// Statuses restricts the listing to posts in any of the given statuses. When it
+	// is empty only posts which are published and whose published_at has passed are
+	// returned, which is what anonymous readers should see.
+	Statuses []string
+	// Tag and Category restrict the listing to posts filed under the term with the
+	// given slug. Posts in subcategories of Category are included.
+	Tag      string
+	Category string
+}
+
 type Post struct {
-	ID            int64     `json:"id"`
-	CreatedAt     time.Time `json:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
-	Title         string    `json:"title"`
-	Slug          string    `json:"slug"`
-	Content       string    `json:"content"`
-	Excerpt       string    `json:"excerpt"`
-	PublishedAt   time.Time `json:"published_at"`
-	Version       int32     `json:"version"`
-	FeaturedImage *Image    `json:"featured_image,omitempty"`
-	Images        []*Image  `json:"images,omitempty"`
+	ID          int64      `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	Title       string     `json:"title"`
+	Slug        string     `json:"slug"`
+	Content     string     `json:"content"`
+	Excerpt     string     `json:"excerpt"`
+	Status      string     `json:"status"`
+	PublishedAt *time.Time `json:"published_at"`
+	Version     int32      `json:"version"`
+	AuthorID    *int64     `json:"author_id,omitempty"`
+	Author      *Author    `json:"author,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	Categories  []string   `json:"categories,omitempty"`
+	// ContentHTML and TOC hold the rendered content, and are only filled in when a
+	// client asks for them.
+	ContentHTML   string             `json:"content_html,omitempty"`
+	TOC           []markdown.Heading `json:"toc,omitempty"`
+	FeaturedImage *Image             `json:"featured_image,omitempty"`
+	Images        []*Image           `json:"images,omitempty"`
 }
 
 type PostModel struct {
 	DB *sql.DB
 }
 
+// IsPublic reports whether the post is visible to anonymous readers.
+func (post *Post) IsPublic() bool {
+	return post.Status == PostStatusPublished && post.PublishedAt != nil && !post.PublishedAt.After(time.Now())
+}
+
+// CanTransitionTo reports whether the post may move from its current status to the
+// given one.
+func (post *Post) CanTransitionTo(status string) bool {
+	return validator.PermittedValue(status, postTransitions[post.Status]...)
+}
+
+// OwnedBy reports whether the post was written by the user with the given id.
+func (post *Post) OwnedBy(userID int64) bool {
+	return post.AuthorID != nil && *post.AuthorID == userID
+}
+
+// postAuthor holds the columns selected from a LEFT JOIN against users, which are
+// all NULL for posts without an author.
+type postAuthor struct {
+	id          sql.NullInt64
+	name        sql.NullString
+	slug        sql.NullString
+	avatarImage sql.NullString
+}
+
+func (a *postAuthor) apply(post *Post) {
+	if !a.id.Valid {
+		return
+	}
+	id := a.id.Int64
+	post.AuthorID = &id
+	post.Author = &Author{ID: id, Name: a.name.String, Slug: a.slug.String}
+	if a.avatarImage.Valid {
+		avatar := a.avatarImage.String
+		post.Author.AvatarImage = &avatar
+	}
+}
+
+// postTermColumns selects the slugs of a post's tags and categories as two arrays.
+const postTermColumns = `
+		ARRAY(SELECT t.slug FROM post_tags pt JOIN tags t ON t.id = pt.tag_id WHERE pt.post_id = p.id ORDER BY t.slug),
+		ARRAY(SELECT c.slug FROM post_categories pc JOIN categories c ON c.id = pc.category_id WHERE pc.post_id = p.id ORDER BY c.slug)`
+
+// setPostTerms saves the post's tags and categories. A nil slice leaves the existing
+// links alone.
+func setPostTerms(ctx context.Context, tx *sql.Tx, post *Post) error {
+	if post.Tags != nil {
+		err := setPostTags(ctx, tx, post.ID, post.Tags)
+		if err != nil {
+			return err
+		}
+	}
+	if post.Categories != nil {
+		err := setPostCategories(ctx, tx, post.ID, post.Categories)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Insert creates the post and records its first revision, attributed to the author.
 func (p PostModel) Insert(post *Post) error {
 	query := `
-		INSERT INTO posts (title, slug, content, excerpt, published_at) 
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO posts (title, slug, content, excerpt, status, published_at, author_id) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 		RETURNING id, created_at, updated_at, version`
 
-	args := []any{post.Title, post.Slug, post.Content, post.Excerpt, post.PublishedAt}
+	args := []any{post.Title, post.Slug, post.Content, post.Excerpt, post.Status, post.PublishedAt, post.AuthorID}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return p.DB.QueryRowContext(ctx, query, args...).Scan(&post.ID, &post.CreatedAt, &post.UpdatedAt, &post.Version)
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&post.ID, &post.CreatedAt, &post.UpdatedAt, &post.Version)
+	if err != nil {
+		switch {
+		case isDuplicatePostSlug(err):
+			return ErrDuplicateSlug
+		default:
+			return err
+		}
+	}
+
+	err = insertRevision(ctx, tx, post, post.AuthorID)
+	if err != nil {
+		return err
+	}
+
+	err = setPostTerms(ctx, tx, post)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (p PostModel) Get(id int64) (*Post, error) {
@@ -49,11 +187,14 @@ func (p PostModel) Get(id int64) (*Post, error) {
 	}
 
 	query := `
-		SELECT id, created_at, updated_at, title, slug, content, excerpt, published_at, version
-		FROM posts
-		WHERE id = $1`
+		SELECT p.id, p.created_at, p.updated_at, p.title, p.slug, p.content, p.excerpt, p.status, p.published_at, p.version,
+		       u.id, u.name, u.slug, u.avatar_image,` + postTermColumns + `
+		FROM posts p
+		LEFT JOIN users u ON u.id = p.author_id
+		WHERE p.id = $1`
 
 	var post Post
+	var author postAuthor
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -66,8 +207,15 @@ func (p PostModel) Get(id int64) (*Post, error) {
 		&post.Slug,
 		&post.Content,
 		&post.Excerpt,
+		&post.Status,
 		&post.PublishedAt,
 		&post.Version,
+		&author.id,
+		&author.name,
+		&author.slug,
+		&author.avatarImage,
+		pq.Array(&post.Tags),
+		pq.Array(&post.Categories),
 	)
 
 	if err != nil {
@@ -79,6 +227,7 @@ func (p PostModel) Get(id int64) (*Post, error) {
 		}
 	}
 
+	author.apply(&post)
 	return &post, nil
 }
 
@@ -88,11 +237,14 @@ func (p PostModel) GetBySlug(slug string) (*Post, error) {
 	}
 
 	query := `
-		SELECT id, created_at, updated_at, title, slug, content, excerpt, published_at, version
-		FROM posts
-		WHERE slug = $1`
+		SELECT p.id, p.created_at, p.updated_at, p.title, p.slug, p.content, p.excerpt, p.status, p.published_at, p.version,
+		       u.id, u.name, u.slug, u.avatar_image,` + postTermColumns + `
+		FROM posts p
+		LEFT JOIN users u ON u.id = p.author_id
+		WHERE p.slug = $1`
 
 	var post Post
+	var author postAuthor
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -105,8 +257,15 @@ func (p PostModel) GetBySlug(slug string) (*Post, error) {
 		&post.Slug,
 		&post.Content,
 		&post.Excerpt,
+		&post.Status,
 		&post.PublishedAt,
 		&post.Version,
+		&author.id,
+		&author.name,
+		&author.slug,
+		&author.avatarImage,
+		pq.Array(&post.Tags),
+		pq.Array(&post.Categories),
 	)
 
 	if err != nil {
@@ -118,21 +277,31 @@ func (p PostModel) GetBySlug(slug string) (*Post, error) {
 		}
 	}
 
+	author.apply(&post)
 	return &post, nil
 }
 
-func (p PostModel) Update(post *Post) error {
+// isDuplicatePostSlug reports whether err is a unique violation on the posts slug.
+func isDuplicatePostSlug(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "posts_slug_key"
+}
+
+// Update saves the post and records the new version as a revision made by the given
+// editor.
+func (p PostModel) Update(post *Post, editorID int64) error {
 	query := `
 		UPDATE posts 
-		SET title = $1, slug = $2, content = $3, excerpt = $4, published_at = $5, updated_at = NOW(), version = version + 1
-		WHERE id = $6 AND version = $7
-		RETURNING updated_at, version`
+		SET title = $1, slug = $2, content = $3, excerpt = $4, status = $5, published_at = $6, updated_at = NOW(), version = version + 1
+		WHERE id = $7 AND version = $8
+		RETURNING updated_at, version, status = 'published' AND published_at <= NOW()`
 
 	args := []any{
 		post.Title,
 		post.Slug,
 		post.Content,
 		post.Excerpt,
+		post.Status,
 		post.PublishedAt,
 		post.ID,
 		post.Version,
@@ -141,7 +310,18 @@ func (p PostModel) Update(post *Post) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := p.DB.QueryRowContext(ctx, query, args...).Scan(&post.UpdatedAt, &post.Version)
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var wasPublic bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT status = 'published' AND published_at <= NOW()
+		FROM posts
+		WHERE id = $1
+		FOR UPDATE`, post.ID).Scan(&wasPublic)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -151,7 +331,87 @@ func (p PostModel) Update(post *Post) error {
 		}
 	}
 
-	return nil
+	var isPublic bool
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&post.UpdatedAt, &post.Version, &isPublic)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case isDuplicatePostSlug(err):
+			return ErrDuplicateSlug
+		default:
+			return err
+		}
+	}
+
+	// A public post whose tags are set may be leaving the feeds of the tags it loses.
+	if wasPublic && (!isPublic || post.Tags != nil) {
+		err = recordPostRemoval(ctx, tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = insertRevision(ctx, tx, post, &editorID)
+	if err != nil {
+		return err
+	}
+
+	err = setPostTerms(ctx, tx, post)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ClaimDueScheduled publishes up to limit scheduled posts whose published_at has
+// passed and returns them. The rows are locked with SKIP LOCKED, so when several API
+// instances run the scheduler at once each due post is claimed by exactly one of them.
+// A revision without an editor is recorded for each post published this way.
+func (p PostModel) ClaimDueScheduled(limit int) ([]*Post, error) {
+	query := `
+		WITH claimed AS (
+			UPDATE posts
+			SET status = 'published', updated_at = NOW(), version = version + 1
+			WHERE id IN (
+				SELECT id FROM posts
+				WHERE status = 'scheduled' AND published_at <= NOW()
+				ORDER BY published_at
+				LIMIT $1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id, created_at, updated_at, title, slug, content, excerpt, status, published_at, version, author_id
+		), revisions AS (
+			INSERT INTO post_revisions (post_id, version, title, slug, content, excerpt, created_at)
+			SELECT id, version, title, slug, content, excerpt, updated_at FROM claimed
+		)
+		SELECT id, created_at, updated_at, title, slug, excerpt, status, published_at, version, author_id
+		FROM claimed`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := []*Post{}
+	for rows.Next() {
+		var post Post
+		err := rows.Scan(
+			&post.ID, &post.CreatedAt, &post.UpdatedAt, &post.Title, &post.Slug,
+			&post.Excerpt, &post.Status, &post.PublishedAt, &post.Version, &post.AuthorID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, &post)
+	}
+
+	return posts, rows.Err()
 }
 
 func (p PostModel) Delete(id int64) error {
@@ -159,41 +419,159 @@ func (p PostModel) Delete(id int64) error {
 		return ErrRecordNotFound
 	}
 
-	query := `DELETE FROM posts WHERE id = $1`
+	query := `
+		DELETE FROM posts WHERE id = $1
+		RETURNING status = 'published' AND published_at <= NOW()`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := p.DB.ExecContext(ctx, query, id)
+	tx, err := p.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	rowsAffected, err := result.RowsAffected()
+	var wasPublic bool
+	err = tx.QueryRowContext(ctx, query, id).Scan(&wasPublic)
 	if err != nil {
-		return err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
 	}
 
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+	if wasPublic {
+		err = recordPostRemoval(ctx, tx)
+		if err != nil {
+			return err
+		}
 	}
 
-	return nil
+	return tx.Commit()
+}
+
+// recordPostRemoval notes that a post has just stopped being public, or stopped
+// being listed somewhere it was.
+func recordPostRemoval(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `UPDATE post_removals SET removed_at = NOW()`)
+	return err
+}
+
+// LastRemoval returns the last time a post stopped being public. Listings of public
+// posts have changed at least since then, even if nothing they list has.
+func (p PostModel) LastRemoval() (time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var removedAt time.Time
+	err := p.DB.QueryRowContext(ctx, `SELECT removed_at FROM post_removals`).Scan(&removedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return time.Time{}, nil
+		default:
+			return time.Time{}, err
+		}
+	}
+	return removedAt, nil
+}
+
+// postPaging holds the parts of a post listing query which depend on whether it is
+// paged by page number or with a cursor.
+type postPaging struct {
+	// count is the column which counts the total number of matches. Counting needs the
+	// whole result set, so in cursor mode it is a constant instead.
+	count   string
+	clauses string
+	args    []any
+	keyset  *keyset
 }
 
-func (p PostModel) GetAll(title string, filters Filters) ([]*Post, Metadata, error) {
+// newPostPaging returns the paging for a listing whose other arguments take up the
+// parameters before $n.
+func newPostPaging(filters Filters, n int) postPaging {
+	if !filters.UsesCursor() {
+		return postPaging{
+			count: "count(*) OVER()",
+			clauses: fmt.Sprintf("ORDER BY p.%s %s, p.id ASC\n\t\tLIMIT $%d OFFSET $%d",
+				filters.sortColumn(), filters.sortDirection(), n, n+1),
+			args: []any{filters.limit(), filters.offset()},
+		}
+	}
+
+	ks := keyset{filters: filters, keyExpr: postSortExpr(filters.sortColumn()), idExpr: "p.id"}
+	where, args := ks.where(n + 1)
+	return postPaging{
+		count:   "0",
+		clauses: fmt.Sprintf("%s\n\t\tORDER BY %s\n\t\tLIMIT $%d", where, ks.orderBy(), n),
+		args:    append([]any{ks.limit()}, args...),
+		keyset:  &ks,
+	}
+}
+
+// postSortExpr returns the SQL expression posts are sorted on for the column. Drafts
+// have no published_at, and sort as if they were published at the dawn of time so
+// that keyset comparisons work on them.
+func postSortExpr(column string) string {
+	if column == "published_at" {
+		return "COALESCE(p.published_at, '-infinity')"
+	}
+	return "p." + column
+}
+
+// postSortKey returns a function reading the value of the sort column, as text, from
+// a post, to be stored in a cursor.
+func postSortKey(column string) func(*Post) (string, int64) {
+	return func(post *Post) (string, int64) {
+		switch column {
+		case "title":
+			return post.Title, post.ID
+		case "published_at":
+			if post.PublishedAt == nil {
+				return "-infinity", post.ID
+			}
+			return post.PublishedAt.Format(time.RFC3339Nano), post.ID
+		default:
+			return strconv.FormatInt(post.ID, 10), post.ID
+		}
+	}
+}
+
+// GetAll returns the posts matching the query.
+func (p PostModel) GetAll(q PostQuery, filters Filters) ([]*Post, Metadata, error) {
+	paging := newPostPaging(filters, 6)
+
 	query := fmt.Sprintf(`
-		SELECT count(*) OVER(), id, created_at, updated_at, title, slug, content, excerpt, published_at, version
-		FROM posts
-		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-		AND published_at <= NOW()
-		ORDER BY %s %s, id ASC
-		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+		SELECT %s, p.id, p.created_at, p.updated_at, p.title, p.slug, p.content, p.excerpt, p.status, p.published_at, p.version,
+		       u.id, u.name, u.slug, u.avatar_image,`+postTermColumns+`
+		FROM posts p
+		LEFT JOIN users u ON u.id = p.author_id
+		WHERE (to_tsvector('simple', p.title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		AND (p.author_id = $2 OR $2 = 0)
+		AND (p.status = ANY($3) OR (cardinality($3::text[]) = 0 AND p.status = 'published' AND p.published_at <= NOW()))
+		AND ($4 = '' OR EXISTS (
+			SELECT 1 FROM post_tags pt JOIN tags t ON t.id = pt.tag_id
+			WHERE pt.post_id = p.id AND t.slug = $4
+		))
+		AND ($5 = '' OR EXISTS (
+			WITH RECURSIVE tree AS (
+				SELECT id FROM categories WHERE slug = $5
+				UNION
+				SELECT c.id FROM categories c JOIN tree ON c.parent_id = tree.id
+			)
+			SELECT 1 FROM post_categories pc JOIN tree ON tree.id = pc.category_id
+			WHERE pc.post_id = p.id
+		))
+		%s`, paging.count, paging.clauses)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []any{title, filters.limit(), filters.offset()}
+	args := []any{q.Title, q.AuthorID, pq.Array(q.Statuses), q.Tag, q.Category}
+	args = append(args, paging.args...)
 
 	rows, err := p.DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -206,6 +584,7 @@ func (p PostModel) GetAll(title string, filters Filters) ([]*Post, Metadata, err
 
 	for rows.Next() {
 		var post Post
+		var author postAuthor
 		err := rows.Scan(
 			&totalRecords,
 			&post.ID,
@@ -215,12 +594,20 @@ func (p PostModel) GetAll(title string, filters Filters) ([]*Post, Metadata, err
 			&post.Slug,
 			&post.Content,
 			&post.Excerpt,
+			&post.Status,
 			&post.PublishedAt,
 			&post.Version,
+			&author.id,
+			&author.name,
+			&author.slug,
+			&author.avatarImage,
+			pq.Array(&post.Tags),
+			pq.Array(&post.Categories),
 		)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
+		author.apply(&post)
 		posts = append(posts, &post)
 	}
 
@@ -228,6 +615,11 @@ func (p PostModel) GetAll(title string, filters Filters) ([]*Post, Metadata, err
 		return nil, Metadata{}, err
 	}
 
+	if paging.keyset != nil {
+		posts, metadata := keysetPage(*paging.keyset, posts, postSortKey(filters.sortColumn()))
+		return posts, metadata, nil
+	}
+
 	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
 	return posts, metadata, nil
 }
@@ -239,36 +631,45 @@ func (p PostModel) GetWithImages(id int64) (*Post, error) {
 
 	query := `
 		SELECT p.id, p.created_at, p.updated_at, p.title, p.slug, p.content, p.excerpt,
-		       p.published_at, p.version,
+		       p.status, p.published_at, p.version,
+		       u.id, u.name, u.slug, u.avatar_image,` + postTermColumns + `,
 		       COALESCE(
 		           json_agg(
 		               json_build_object(
 		                   'id', i.id,
+		                   'post_id', pi.post_id,
 		                   'filename', i.filename,
 		                   'original_filename', i.original_filename,
-		                   'file_path', i.file_path,
-		                   'file_size', i.file_size,
-		                   'mime_type', i.mime_type,
-		                   'width', i.width,
-		                   'height', i.height,
+		                   'file_path', b.file_path,
+		                   'file_size', b.file_size,
+		                   'mime_type', b.mime_type,
+		                   'width', b.width,
+		                   'height', b.height,
+		                   'sha256', b.sha256,
 		                   'alt_text', i.alt_text,
 		                   'caption', i.caption,
-		                   'is_featured', i.is_featured,
-		                   'sort_order', i.sort_order,
+		                   'blurhash', i.blurhash,
+		                   'lqip', i.lqip,
+		                   'is_featured', pi.is_featured,
+		                   'sort_order', pi.sort_order,
 		                   'created_at', i.created_at,
 		                   'updated_at', i.updated_at,
 		                   'version', i.version
-		               ) ORDER BY i.sort_order, i.created_at
+		               ) ORDER BY pi.sort_order, pi.created_at
 		           ) FILTER (WHERE i.id IS NOT NULL),
 		           '[]'::json
 		       ) as images
 		FROM posts p
-		LEFT JOIN images i ON p.id = i.post_id
+		LEFT JOIN post_images pi ON pi.post_id = p.id
+		LEFT JOIN images i ON i.id = pi.image_id
+		LEFT JOIN image_blobs b ON b.id = i.blob_id
+		LEFT JOIN users u ON u.id = p.author_id
 		WHERE p.id = $1
 		GROUP BY p.id, p.created_at, p.updated_at, p.title, p.slug, p.content, p.excerpt,
-		         p.published_at, p.version`
+		         p.status, p.published_at, p.version, u.id, u.name, u.slug, u.avatar_image`
 
 	var post Post
+	var author postAuthor
 	var imagesJSON []byte
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -276,7 +677,9 @@ func (p PostModel) GetWithImages(id int64) (*Post, error) {
 
 	err := p.DB.QueryRowContext(ctx, query, id).Scan(
 		&post.ID, &post.CreatedAt, &post.UpdatedAt, &post.Title, &post.Slug,
-		&post.Content, &post.Excerpt, &post.PublishedAt, &post.Version, &imagesJSON,
+		&post.Content, &post.Excerpt, &post.Status, &post.PublishedAt, &post.Version,
+		&author.id, &author.name, &author.slug, &author.avatarImage,
+		pq.Array(&post.Tags), pq.Array(&post.Categories), &imagesJSON,
 	)
 
 	if err != nil {
@@ -288,6 +691,8 @@ func (p PostModel) GetWithImages(id int64) (*Post, error) {
 		}
 	}
 
+	author.apply(&post)
+
 	if len(imagesJSON) > 0 && string(imagesJSON) != "[]" {
 		err = json.Unmarshal(imagesJSON, &post.Images)
 		if err != nil {
@@ -313,36 +718,45 @@ func (p PostModel) GetBySlugWithImages(slug string) (*Post, error) {
 
 	query := `
 		SELECT p.id, p.created_at, p.updated_at, p.title, p.slug, p.content, p.excerpt,
-		       p.published_at, p.version,
+		       p.status, p.published_at, p.version,
+		       u.id, u.name, u.slug, u.avatar_image,` + postTermColumns + `,
 		       COALESCE(
 		           json_agg(
 		               json_build_object(
 		                   'id', i.id,
+		                   'post_id', pi.post_id,
 		                   'filename', i.filename,
 		                   'original_filename', i.original_filename,
-		                   'file_path', i.file_path,
-		                   'file_size', i.file_size,
-		                   'mime_type', i.mime_type,
-		                   'width', i.width,
-		                   'height', i.height,
+		                   'file_path', b.file_path,
+		                   'file_size', b.file_size,
+		                   'mime_type', b.mime_type,
+		                   'width', b.width,
+		                   'height', b.height,
+		                   'sha256', b.sha256,
 		                   'alt_text', i.alt_text,
 		                   'caption', i.caption,
-		                   'is_featured', i.is_featured,
-		                   'sort_order', i.sort_order,
+		                   'blurhash', i.blurhash,
+		                   'lqip', i.lqip,
+		                   'is_featured', pi.is_featured,
+		                   'sort_order', pi.sort_order,
 		                   'created_at', i.created_at,
 		                   'updated_at', i.updated_at,
 		                   'version', i.version
-		               ) ORDER BY i.sort_order, i.created_at
+		               ) ORDER BY pi.sort_order, pi.created_at
 		           ) FILTER (WHERE i.id IS NOT NULL),
 		           '[]'::json
 		       ) as images
 		FROM posts p
-		LEFT JOIN images i ON p.id = i.post_id
+		LEFT JOIN post_images pi ON pi.post_id = p.id
+		LEFT JOIN images i ON i.id = pi.image_id
+		LEFT JOIN image_blobs b ON b.id = i.blob_id
+		LEFT JOIN users u ON u.id = p.author_id
 		WHERE p.slug = $1
 		GROUP BY p.id, p.created_at, p.updated_at, p.title, p.slug, p.content, p.excerpt,
-		         p.published_at, p.version`
+		         p.status, p.published_at, p.version, u.id, u.name, u.slug, u.avatar_image`
 
 	var post Post
+	var author postAuthor
 	var imagesJSON []byte
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -350,7 +764,9 @@ func (p PostModel) GetBySlugWithImages(slug string) (*Post, error) {
 
 	err := p.DB.QueryRowContext(ctx, query, slug).Scan(
 		&post.ID, &post.CreatedAt, &post.UpdatedAt, &post.Title, &post.Slug,
-		&post.Content, &post.Excerpt, &post.PublishedAt, &post.Version, &imagesJSON,
+		&post.Content, &post.Excerpt, &post.Status, &post.PublishedAt, &post.Version,
+		&author.id, &author.name, &author.slug, &author.avatarImage,
+		pq.Array(&post.Tags), pq.Array(&post.Categories), &imagesJSON,
 	)
 
 	if err != nil {
@@ -362,6 +778,8 @@ func (p PostModel) GetBySlugWithImages(slug string) (*Post, error) {
 		}
 	}
 
+	author.apply(&post)
+
 	if len(imagesJSON) > 0 && string(imagesJSON) != "[]" {
 		err = json.Unmarshal(imagesJSON, &post.Images)
 		if err != nil {
@@ -380,22 +798,44 @@ func (p PostModel) GetBySlugWithImages(slug string) (*Post, error) {
 	return &post, nil
 }
 
-func (p PostModel) GetAllWithFeaturedImages(title string, filters Filters) ([]*Post, Metadata, error) {
+// GetAllWithFeaturedImages is GetAll with each post's featured image attached.
+func (p PostModel) GetAllWithFeaturedImages(q PostQuery, filters Filters) ([]*Post, Metadata, error) {
+	paging := newPostPaging(filters, 6)
+
 	query := fmt.Sprintf(`
-		SELECT count(*) OVER(), p.id, p.created_at, p.updated_at, p.title, p.slug, 
-		       p.content, p.excerpt, p.published_at, p.version,
-		       i.id, i.filename, i.file_path, i.alt_text, i.caption, i.width, i.height
+		SELECT %s, p.id, p.created_at, p.updated_at, p.title, p.slug, 
+		       p.content, p.excerpt, p.status, p.published_at, p.version,
+		       u.id, u.name, u.slug, u.avatar_image,`+postTermColumns+`,
+		       i.id, i.filename, b.file_path, b.file_size, b.mime_type, i.alt_text, i.caption, b.width, b.height,
+		       i.blurhash, i.lqip
 		FROM posts p
-		LEFT JOIN images i ON p.id = i.post_id AND i.is_featured = true
+		LEFT JOIN users u ON u.id = p.author_id
+		LEFT JOIN post_images pi ON pi.post_id = p.id AND pi.is_featured = true
+		LEFT JOIN images i ON i.id = pi.image_id
+		LEFT JOIN image_blobs b ON b.id = i.blob_id
 		WHERE (to_tsvector('simple', p.title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-		AND p.published_at <= NOW()
-		ORDER BY p.%s %s, p.id ASC
-		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+		AND (p.author_id = $2 OR $2 = 0)
+		AND (p.status = ANY($3) OR (cardinality($3::text[]) = 0 AND p.status = 'published' AND p.published_at <= NOW()))
+		AND ($4 = '' OR EXISTS (
+			SELECT 1 FROM post_tags pt JOIN tags t ON t.id = pt.tag_id
+			WHERE pt.post_id = p.id AND t.slug = $4
+		))
+		AND ($5 = '' OR EXISTS (
+			WITH RECURSIVE tree AS (
+				SELECT id FROM categories WHERE slug = $5
+				UNION
+				SELECT c.id FROM categories c JOIN tree ON c.parent_id = tree.id
+			)
+			SELECT 1 FROM post_categories pc JOIN tree ON tree.id = pc.category_id
+			WHERE pc.post_id = p.id
+		))
+		%s`, paging.count, paging.clauses)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []any{title, filters.limit(), filters.offset()}
+	args := []any{q.Title, q.AuthorID, pq.Array(q.Statuses), q.Tag, q.Category}
+	args = append(args, paging.args...)
 
 	rows, err := p.DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -408,20 +848,27 @@ func (p PostModel) GetAllWithFeaturedImages(title string, filters Filters) ([]*P
 
 	for rows.Next() {
 		var post Post
+		var author postAuthor
 		var imageID sql.NullInt64
-		var filename, filePath, altText, caption sql.NullString
-		var width, height sql.NullInt64
+		var filename, filePath, mimeType, altText, caption sql.NullString
+		var fileSize, width, height sql.NullInt64
+		var blurHash, lqip sql.NullString
 
 		err := rows.Scan(
 			&totalRecords, &post.ID, &post.CreatedAt, &post.UpdatedAt,
 			&post.Title, &post.Slug, &post.Content, &post.Excerpt,
-			&post.PublishedAt, &post.Version,
-			&imageID, &filename, &filePath, &altText, &caption, &width, &height,
+			&post.Status, &post.PublishedAt, &post.Version,
+			&author.id, &author.name, &author.slug, &author.avatarImage,
+			pq.Array(&post.Tags), pq.Array(&post.Categories),
+			&imageID, &filename, &filePath, &fileSize, &mimeType, &altText, &caption, &width, &height,
+			&blurHash, &lqip,
 		)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
 
+		author.apply(&post)
+
 		// Add featured image if exists
 		if imageID.Valid {
 			post.FeaturedImage = &Image{
@@ -429,6 +876,8 @@ func (p PostModel) GetAllWithFeaturedImages(title string, filters Filters) ([]*P
 				PostID:   post.ID,
 				Filename: filename.String,
 				FilePath: filePath.String,
+				FileSize: fileSize.Int64,
+				MimeType: mimeType.String,
 			}
 			if altText.Valid {
 				post.FeaturedImage.AltText = &altText.String
@@ -444,6 +893,12 @@ func (p PostModel) GetAllWithFeaturedImages(title string, filters Filters) ([]*P
 				h := int(height.Int64)
 				post.FeaturedImage.Height = &h
 			}
+			if blurHash.Valid {
+				post.FeaturedImage.BlurHash = &blurHash.String
+			}
+			if lqip.Valid {
+				post.FeaturedImage.LQIP = &lqip.String
+			}
 			post.FeaturedImage.IsFeatured = true
 		}
 
@@ -454,6 +909,11 @@ func (p PostModel) GetAllWithFeaturedImages(title string, filters Filters) ([]*P
 		return nil, Metadata{}, err
 	}
 
+	if paging.keyset != nil {
+		posts, metadata := keysetPage(*paging.keyset, posts, postSortKey(filters.sortColumn()))
+		return posts, metadata, nil
+	}
+
 	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
 	return posts, metadata, nil
 }
@@ -466,5 +926,10 @@ func ValidatePost(v *validator.Validator, post *Post) {
 	v.Check(post.Content != "", "content", "must be provided")
 	v.Check(post.Excerpt != "", "excerpt", "must be provided")
 	v.Check(len(post.Excerpt) <= 1000, "excerpt", "must not be more than 1000 bytes long")
-	v.Check(!post.PublishedAt.IsZero(), "published_at", "must be provided")
+	v.Check(validator.PermittedValue(post.Status, PostStatuses...), "status", "invalid status value")
+	if post.Status == PostStatusPublished || post.Status == PostStatusScheduled {
+		v.Check(post.PublishedAt != nil, "published_at", "must be provided for published posts")
+	}
+	ValidateTermSlugs(v, "tags", post.Tags)
+	ValidateTermSlugs(v, "categories", post.Categories)
 }
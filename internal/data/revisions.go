@@ -0,0 +1,119 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Revision is a snapshot of a post's editable fields as they were at a given version.
+// A revision is recorded every time a post is created or updated, so the revision
+// with the post's current version always matches the post itself.
+type Revision struct {
+	ID        int64     `json:"id"`
+	PostID    int64     `json:"post_id"`
+	Version   int32     `json:"version"`
+	Title     string    `json:"title"`
+	Slug      string    `json:"slug"`
+	Content   string    `json:"content,omitempty"`
+	Excerpt   string    `json:"excerpt"`
+	EditorID  *int64    `json:"editor_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type RevisionModel struct {
+	DB *sql.DB
+}
+
+// insertRevision records the current state of the post as a revision, inside the
+// transaction which created or updated it. editorID may be nil for changes made by
+// the system rather than a user.
+func insertRevision(ctx context.Context, tx *sql.Tx, post *Post, editorID *int64) error {
+	query := `
+		INSERT INTO post_revisions (post_id, version, title, slug, content, excerpt, editor_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	args := []any{post.ID, post.Version, post.Title, post.Slug, post.Content, post.Excerpt, editorID, post.UpdatedAt}
+
+	_, err := tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// GetAllForPost returns the revisions of a post, newest first. The content of each
+// revision is left out to keep the listing small; use Get to fetch it.
+func (m RevisionModel) GetAllForPost(postID int64) ([]*Revision, error) {
+	query := `
+		SELECT id, post_id, version, title, slug, excerpt, editor_id, created_at
+		FROM post_revisions
+		WHERE post_id = $1
+		ORDER BY version DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []*Revision{}
+	for rows.Next() {
+		var revision Revision
+		err := rows.Scan(
+			&revision.ID,
+			&revision.PostID,
+			&revision.Version,
+			&revision.Title,
+			&revision.Slug,
+			&revision.Excerpt,
+			&revision.EditorID,
+			&revision.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, &revision)
+	}
+
+	return revisions, rows.Err()
+}
+
+// Get returns the revision of a post at the given version.
+func (m RevisionModel) Get(postID int64, version int32) (*Revision, error) {
+	if postID < 1 || version < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, post_id, version, title, slug, content, excerpt, editor_id, created_at
+		FROM post_revisions
+		WHERE post_id = $1 AND version = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var revision Revision
+	err := m.DB.QueryRowContext(ctx, query, postID, version).Scan(
+		&revision.ID,
+		&revision.PostID,
+		&revision.Version,
+		&revision.Title,
+		&revision.Slug,
+		&revision.Content,
+		&revision.Excerpt,
+		&revision.EditorID,
+		&revision.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &revision, nil
+}
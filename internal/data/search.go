@@ -0,0 +1,246 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"blog/internal/data/validator"
+	"github.com/lib/pq"
+)
+
+// SearchLanguage is the text search configuration of the posts.search_vector column.
+// Searches in any other language build their vectors on the fly, which is slower as
+// the index can't be used.
+const SearchLanguage = "english"
+
+// SearchLanguages are the text search configurations a search may ask for. They are
+// the ones which ship with PostgreSQL.
+var SearchLanguages = []string{
+	"simple", "arabic", "armenian", "basque", "catalan", "danish", "dutch", "english",
+	"finnish", "french", "german", "greek", "hindi", "hungarian", "indonesian", "irish",
+	"italian", "lithuanian", "nepali", "norwegian", "portuguese", "romanian", "russian",
+	"serbian", "spanish", "swedish", "tamil", "turkish", "yiddish",
+}
+
+// SearchQuery holds the parameters of a full-text search.
+type SearchQuery struct {
+	// Text is the search as the reader typed it. Words are ANDed together, "quoted
+	// words" must appear as a phrase, word* matches any word starting with word, -word
+	// excludes posts containing it and OR between two terms matches either.
+	Text     string
+	Language string
+}
+
+// SearchResult is a post matching a search, with its rank and the parts of its
+// title, excerpt and content which matched. Matches are wrapped in <mark> tags and
+// everything else in the highlights is HTML-escaped.
+type SearchResult struct {
+	*Post
+	Rank       float64    `json:"rank"`
+	Highlights Highlights `json:"highlights"`
+}
+
+type Highlights struct {
+	Title   string `json:"title"`
+	Excerpt string `json:"excerpt"`
+	Content string `json:"content"`
+}
+
+func ValidateSearchQuery(v *validator.Validator, q SearchQuery) {
+	v.Check(strings.TrimSpace(q.Text) != "", "q", "must be provided")
+	v.Check(len(q.Text) <= 500, "q", "must not be more than 500 bytes long")
+	v.Check(tsquery(q.Text) != "", "q", "must contain at least one word")
+	v.Check(validator.PermittedValue(q.Language, SearchLanguages...), "lang", "unsupported language")
+}
+
+// tsquery converts the search syntax described on SearchQuery to to_tsquery() input.
+// Only letters and digits make it into the output, so the result is always valid
+// tsquery syntax whatever the reader typed.
+func tsquery(text string) string {
+	var terms []string
+	pendingOr := false
+
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		switch r := runes[i]; {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if phrase := tsqueryPhrase(string(runes[i+1 : end])); phrase != "" {
+				terms = appendTerm(terms, phrase, pendingOr)
+				pendingOr = false
+			}
+			i = end + 1
+			continue
+		}
+
+		end := i
+		for end < len(runes) && !unicode.IsSpace(runes[end]) && runes[end] != '"' {
+			end++
+		}
+		word := string(runes[i:end])
+		i = end
+
+		if word == "OR" {
+			pendingOr = len(terms) > 0
+			continue
+		}
+
+		negate := strings.HasPrefix(word, "-")
+		term := tsqueryPhrase(strings.TrimPrefix(word, "-"))
+		if term == "" {
+			continue
+		}
+		if negate {
+			term = "!" + term
+		}
+		terms = appendTerm(terms, term, pendingOr)
+		pendingOr = false
+	}
+
+	return strings.Join(terms, " & ")
+}
+
+// appendTerm adds a term to the ANDed list, or ORs it with the previous one.
+func appendTerm(terms []string, term string, or bool) []string {
+	if or {
+		terms[len(terms)-1] = fmt.Sprintf("(%s | %s)", terms[len(terms)-1], term)
+		return terms
+	}
+	return append(terms, term)
+}
+
+// tsqueryPhrase turns a run of text into lexemes which must follow each other, with a
+// trailing * on a word making it a prefix match.
+func tsqueryPhrase(text string) string {
+	var words []string
+	for _, field := range strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '*'
+	}) {
+		prefix := strings.HasSuffix(field, "*")
+		word := strings.Map(func(r rune) rune {
+			if r == '*' {
+				return -1
+			}
+			return unicode.ToLower(r)
+		}, field)
+		if word == "" {
+			continue
+		}
+		if prefix {
+			word += ":*"
+		}
+		words = append(words, word)
+	}
+
+	switch len(words) {
+	case 0:
+		return ""
+	case 1:
+		return words[0]
+	default:
+		return "(" + strings.Join(words, " <-> ") + ")"
+	}
+}
+
+// escapedHTML returns SQL which HTML-escapes the given text column, so that the only
+// markup in a ts_headline() of it is the highlighting we add.
+func escapedHTML(column string) string {
+	return fmt.Sprintf(`replace(replace(replace(%s, '&', '&amp;'), '<', '&lt;'), '>', '&gt;')`, column)
+}
+
+// Search returns the public posts matching the query, best matches first, ranked with
+// ts_rank_cd so that posts where the terms appear close together come out on top.
+func (p PostModel) Search(q SearchQuery, filters Filters) ([]*SearchResult, Metadata, error) {
+	vector := "p.search_vector"
+	if q.Language != SearchLanguage {
+		vector = `(setweight(to_tsvector($1::regconfig, p.title), 'A') ||
+		           setweight(to_tsvector($1::regconfig, p.excerpt), 'B') ||
+		           setweight(to_tsvector($1::regconfig, p.content), 'C'))`
+	}
+
+	// The headlines are only worked out for the page of results, as ts_headline() is
+	// expensive on long posts.
+	query := fmt.Sprintf(`
+		WITH matches AS (
+			SELECT count(*) OVER() AS total, p.id, ts_rank_cd(%s, tsq) AS rank, tsq
+			FROM posts p, to_tsquery($1::regconfig, $2) tsq
+			WHERE %s @@ tsq
+			AND `+publicPostCondition+`
+			ORDER BY rank DESC, p.published_at DESC, p.id ASC
+			LIMIT $3 OFFSET $4
+		)
+		SELECT m.total, m.rank,
+		       ts_headline($1::regconfig, %s, m.tsq, 'HighlightAll=true, StartSel=<mark>, StopSel=</mark>'),
+		       ts_headline($1::regconfig, %s, m.tsq, 'HighlightAll=true, StartSel=<mark>, StopSel=</mark>'),
+		       ts_headline($1::regconfig, %s, m.tsq, 'MaxFragments=3, MaxWords=25, MinWords=10, StartSel=<mark>, StopSel=</mark>'),
+		       p.id, p.created_at, p.updated_at, p.title, p.slug, p.content, p.excerpt, p.status, p.published_at, p.version,
+		       u.id, u.name, u.slug, u.avatar_image,`+postTermColumns+`
+		FROM matches m
+		JOIN posts p ON p.id = m.id
+		LEFT JOIN users u ON u.id = p.author_id
+		ORDER BY m.rank DESC, p.published_at DESC, p.id ASC`,
+		vector, vector, escapedHTML("p.title"), escapedHTML("p.excerpt"), escapedHTML("p.content"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	args := []any{q.Language, tsquery(q.Text), filters.limit(), filters.offset()}
+
+	rows, err := p.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	results := []*SearchResult{}
+
+	for rows.Next() {
+		result := SearchResult{Post: &Post{}}
+		var author postAuthor
+		err := rows.Scan(
+			&totalRecords,
+			&result.Rank,
+			&result.Highlights.Title,
+			&result.Highlights.Excerpt,
+			&result.Highlights.Content,
+			&result.ID,
+			&result.CreatedAt,
+			&result.UpdatedAt,
+			&result.Title,
+			&result.Slug,
+			&result.Content,
+			&result.Excerpt,
+			&result.Status,
+			&result.PublishedAt,
+			&result.Version,
+			&author.id,
+			&author.name,
+			&author.slug,
+			&author.avatarImage,
+			pq.Array(&result.Tags),
+			pq.Array(&result.Categories),
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		author.apply(result.Post)
+		results = append(results, &result)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return results, metadata, nil
+}
@@ -0,0 +1,180 @@
+package data
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+)
+
+func TestTsquery(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"empty", "", ""},
+		{"whitespace", " \t\n ", ""},
+		{"one word", "golang", "golang"},
+		{"lowercased", "GoLang", "golang"},
+		{"words are ANDed", "go web server", "go & web & server"},
+		{"punctuation separates words", "go,web;server", "(go <-> web <-> server)"},
+		{"unicode letters", "Café naïve", "café & naïve"},
+		{"digits", "http2 2024", "http2 & 2024"},
+
+		{"phrase", `"hello world"`, "(hello <-> world)"},
+		{"phrase of one word", `"hello"`, "hello"},
+		{"phrase and word", `"hello world" go`, "(hello <-> world) & go"},
+		{"phrase touching a word", `go"hello world"`, "go & (hello <-> world)"},
+		{"unclosed phrase", `go "hello world`, "go & (hello <-> world)"},
+		{"empty phrase", `""`, ""},
+		{"phrase of punctuation", `"!?"`, ""},
+		{"lone quote", `"`, ""},
+
+		{"prefix", "gola*", "gola:*"},
+		{"prefix in phrase", `"hello wor*"`, "(hello <-> wor:*)"},
+		{"repeated star", "gola**", "gola:*"},
+		{"star inside a word", "go*lang", "golang"},
+		{"lone star", "*", ""},
+		{"stars", "** *", ""},
+
+		{"negation", "go -java", "go & !java"},
+		{"negated prefix", "-jav*", "!jav:*"},
+		{"double dash", "--java", "!java"},
+		{"lone dash", "-", ""},
+		{"dash and punctuation", "-!!", ""},
+		{"dash inside a word", "e-mail", "(e <-> mail)"},
+
+		{"or", "go OR rust", "(go | rust)"},
+		{"or chain", "go OR rust OR zig", "((go | rust) | zig)"},
+		{"or binds to neighbours", "web go OR rust server", "web & (go | rust) & server"},
+		{"or with phrase", `"hello world" OR hi`, "((hello <-> world) | hi)"},
+		{"or with negation", "go OR -rust", "(go | !rust)"},
+		{"lowercase or is a word", "go or rust", "go & or & rust"},
+		{"leading or", "OR go", "go"},
+		{"trailing or", "go OR", "go"},
+		{"repeated or", "go OR OR rust", "(go | rust)"},
+		{"or alone", "OR", ""},
+		{"or before nothing", "OR OR -", ""},
+		{"or after dropped term", "! OR go", "go"},
+
+		{"tsquery operators", "a & b | !c", "a & b & c"},
+		{"tsquery syntax", "(a <-> b):* 'c'", "a & b & c"},
+		{"only operators", "& | ! ( ) <-> : ' \\", ""},
+		{"only punctuation", "...,;!?", ""},
+		{"sql", "'; DROP TABLE posts; --", "drop & table & posts"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tsquery(tt.text)
+			if got != tt.want {
+				t.Errorf("tsquery(%q) = %q; want %q", tt.text, got, tt.want)
+			}
+			if got != "" && !validTsquery(got) {
+				t.Errorf("tsquery(%q) = %q, which is not valid tsquery syntax", tt.text, got)
+			}
+		})
+	}
+}
+
+func TestTsqueryPhrase(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"empty", "", ""},
+		{"punctuation", " -!? ", ""},
+		{"stars", "* **", ""},
+		{"one word", "Hello", "hello"},
+		{"words", "hello big world", "(hello <-> big <-> world)"},
+		{"prefix", "wor*", "wor:*"},
+		{"prefix in the middle", "hel* world", "(hel:* <-> world)"},
+		{"leading star", "*world", "world"},
+		{"separators", "a.b/c", "(a <-> b <-> c)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tsqueryPhrase(tt.text)
+			if got != tt.want {
+				t.Errorf("tsqueryPhrase(%q) = %q; want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTsqueryAlwaysValid(t *testing.T) {
+	pieces := []string{
+		"", " ", "go", "GO", "OR", "or", "-", "--", "*", "-go*", `"`, `"a b"`, "!", "&", "|",
+		"(", ")", ":", "'", "<->", "é", "1", "\t",
+	}
+
+	for _, a := range pieces {
+		for _, b := range pieces {
+			for _, c := range pieces {
+				text := a + " " + b + c
+				got := tsquery(text)
+				if got != "" && !validTsquery(got) {
+					t.Errorf("tsquery(%q) = %q, which is not valid tsquery syntax", text, got)
+				}
+			}
+		}
+	}
+}
+
+// validTsquery reports whether q is made up only of the tsquery syntax tsquery emits:
+// lexemes of lowercase letters and digits with an optional :* suffix, combined with
+// !, &, |, <-> and parentheses.
+func validTsquery(q string) bool {
+	p := tsqueryChecker{s: q}
+	return p.expr() && p.s == ""
+}
+
+type tsqueryChecker struct {
+	s string
+}
+
+func (p *tsqueryChecker) consume(token string) bool {
+	if strings.HasPrefix(p.s, token) {
+		p.s = p.s[len(token):]
+		return true
+	}
+	return false
+}
+
+// expr parses operands separated by binary operators.
+func (p *tsqueryChecker) expr() bool {
+	if !p.operand() {
+		return false
+	}
+	for p.consume(" & ") || p.consume(" | ") || p.consume(" <-> ") {
+		if !p.operand() {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *tsqueryChecker) operand() bool {
+	if p.consume("!") {
+		return p.operand()
+	}
+	if p.consume("(") {
+		return p.expr() && p.consume(")")
+	}
+
+	n := 0
+	for _, r := range p.s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) || unicode.IsUpper(r) {
+			break
+		}
+		n += len(string(r))
+	}
+	if n == 0 {
+		return false
+	}
+	p.s = p.s[n:]
+	p.consume(":*")
+	return true
+}
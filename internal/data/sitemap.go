@@ -0,0 +1,108 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MaxSitemapURLs is the most URLs a single sitemap may list. Sites with more posts
+// are split into several sitemaps, listed in a sitemap index.
+const MaxSitemapURLs = 50_000
+
+// maxSitemapImages is the most images a sitemap may list for a single URL.
+const maxSitemapImages = 1_000
+
+// SitemapEntry is a public post as listed in the sitemap.
+type SitemapEntry struct {
+	Slug      string
+	UpdatedAt time.Time
+	// Images holds the filenames of the images attached to the post, featured image
+	// first.
+	Images []string
+}
+
+// SitemapPages splits the public posts, in id order, into sitemaps of up to size
+// posts each, returning the last time each one changed: when a post in it was updated,
+// or when a post was removed, which can shift posts between pages. There are no pages
+// if nothing is published.
+func (p PostModel) SitemapPages(size int) ([]time.Time, error) {
+	query := `
+		SELECT GREATEST(max(updated_at), (SELECT max(removed_at) FROM post_removals))
+		FROM (
+			SELECT updated_at, (row_number() OVER (ORDER BY id) - 1) / $1 AS page
+			FROM posts
+			WHERE status = 'published' AND published_at <= NOW()
+		) AS pages
+		GROUP BY page
+		ORDER BY page`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, query, size)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pages := []time.Time{}
+	for rows.Next() {
+		var lastMod time.Time
+		err := rows.Scan(&lastMod)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, lastMod)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return pages, nil
+}
+
+// GetSitemapPage returns the public posts in the given page, counting from 1, of
+// the sitemap split up by SitemapPages.
+func (p PostModel) GetSitemapPage(page, size int) ([]*SitemapEntry, error) {
+	query := `
+		SELECT p.slug, p.updated_at,
+		       ARRAY(
+		           SELECT i.filename FROM post_images pi JOIN images i ON i.id = pi.image_id
+		           WHERE pi.post_id = p.id
+		           ORDER BY pi.is_featured DESC, pi.sort_order, i.id
+		           LIMIT $3
+		       )
+		FROM posts p
+		WHERE p.status = 'published' AND p.published_at <= NOW()
+		ORDER BY p.id
+		LIMIT $1 OFFSET $2`
+
+	// Listing tens of thousands of posts takes longer than the usual query.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, query, size, (page-1)*size, maxSitemapImages)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*SitemapEntry{}
+	for rows.Next() {
+		var entry SitemapEntry
+		err := rows.Scan(&entry.Slug, &entry.UpdatedAt, pq.Array(&entry.Images))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
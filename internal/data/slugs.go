@@ -0,0 +1,19 @@
+package data
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	SlugRX = regexp.MustCompile("^[a-z0-9]+(?:-[a-z0-9]+)*$")
+
+	nonSlugRX = regexp.MustCompile("[^a-z0-9]+")
+)
+
+// Slugify lowercases the provided string and collapses every run of characters that
+// aren't ASCII letters or digits into a single hyphen, e.g. "Hello, World!" becomes
+// "hello-world".
+func Slugify(s string) string {
+	return strings.Trim(nonSlugRX.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
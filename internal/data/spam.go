@@ -0,0 +1,196 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Classes the spam filter is taught comments belong to: spam, or ham for comments
+// which aren't.
+const (
+	SpamClassSpam = "spam"
+	SpamClassHam  = "ham"
+)
+
+// SpamTokenCount is how many spam and ham comments a token has been seen in.
+type SpamTokenCount struct {
+	Spam int
+	Ham  int
+}
+
+// SpamCounts is the part of the spam filter's model needed to classify a comment.
+type SpamCounts struct {
+	// SpamMessages and HamMessages are how many comments the filter has been taught
+	// are spam and ham.
+	SpamMessages int
+	HamMessages  int
+	// Tokens holds the counts of each of the tokens asked for which has been seen.
+	Tokens map[string]SpamTokenCount
+}
+
+// SpamModel stores the naive Bayes spam filter's model.
+type SpamModel struct {
+	DB *sql.DB
+}
+
+// Counts returns how many comments of each class there have been, and how many of
+// each the given tokens have been seen in.
+func (m SpamModel) Counts(tokens []string) (*SpamCounts, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	counts := &SpamCounts{Tokens: make(map[string]SpamTokenCount)}
+
+	err := m.DB.QueryRowContext(ctx, `
+		SELECT
+			COALESCE(sum(messages) FILTER (WHERE class = 'spam'), 0),
+			COALESCE(sum(messages) FILTER (WHERE class = 'ham'), 0)
+		FROM spam_classes`).Scan(&counts.SpamMessages, &counts.HamMessages)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `
+		SELECT token, spam, ham
+		FROM spam_tokens
+		WHERE token = ANY($1)`, pq.Array(tokens))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var token string
+		var count SpamTokenCount
+		err := rows.Scan(&token, &count.Spam, &count.Ham)
+		if err != nil {
+			return nil, err
+		}
+		counts.Tokens[token] = count
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// TrainComment teaches the filter that the comment, made up of the given tokens, is
+// of the class given. If it was earlier taught the comment was of the other class,
+// that is undone first. It returns ErrRecordNotFound if the comment doesn't exist.
+func (m SpamModel) TrainComment(commentID int64, class string, tokens []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var trained string
+	err = tx.QueryRowContext(ctx, `SELECT spam_class FROM comments WHERE id = $1 FOR UPDATE`, commentID).Scan(&trained)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	changes := spamTraining(trained, class)
+	if len(changes) == 0 {
+		return nil
+	}
+	for _, change := range changes {
+		err = addSpamCounts(ctx, tx, change.class, tokens, change.delta)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE comments SET spam_class = $1 WHERE id = $2`, class, commentID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// spamCountChange is an amount to add to the counts of a class.
+type spamCountChange struct {
+	class string
+	delta int
+}
+
+// spamTraining returns the changes to the counts which teach the filter a comment is
+// of the class given, when it has already been taught it is of the trained class, or
+// of none if trained is empty. Teaching it the same class twice changes nothing.
+func spamTraining(trained, class string) []spamCountChange {
+	if trained == class {
+		return nil
+	}
+	var changes []spamCountChange
+	if trained != "" {
+		changes = append(changes, spamCountChange{trained, -1})
+	}
+	return append(changes, spamCountChange{class, 1})
+}
+
+// addSpamCounts adds delta to the number of comments of the class, and to the counts
+// of each of the tokens for the class.
+func addSpamCounts(ctx context.Context, tx *sql.Tx, class string, tokens []string, delta int) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE spam_classes SET messages = GREATEST(messages + $1, 0) WHERE class = $2`, delta, class)
+	if err != nil {
+		return err
+	}
+
+	var spam, ham int
+	if class == SpamClassSpam {
+		spam = delta
+	} else {
+		ham = delta
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO spam_tokens (token, spam, ham)
+		SELECT token, GREATEST($2, 0), GREATEST($3, 0) FROM unnest($1::text[]) AS token
+		ON CONFLICT (token) DO UPDATE
+		SET spam = GREATEST(spam_tokens.spam + $2, 0), ham = GREATEST(spam_tokens.ham + $3, 0)`,
+		pq.Array(tokens), spam, ham)
+	return err
+}
+
+// UseFormToken records that the form token with the given signature has been used,
+// until it expires. It reports false if the token had already been used. Tokens
+// which have expired are forgotten along the way.
+func (m SpamModel) UseFormToken(signature string, expires time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM used_form_tokens WHERE expires_at < NOW()`)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := m.DB.ExecContext(ctx, `
+		INSERT INTO used_form_tokens (signature, expires_at)
+		VALUES ($1, $2)
+		ON CONFLICT (signature) DO NOTHING`, signature, expires)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
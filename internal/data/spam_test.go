@@ -0,0 +1,49 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSpamTraining(t *testing.T) {
+	tests := []struct {
+		name    string
+		trained string
+		class   string
+		want    []spamCountChange
+	}{
+		{"untrained as spam", "", SpamClassSpam, []spamCountChange{{SpamClassSpam, 1}}},
+		{"untrained as ham", "", SpamClassHam, []spamCountChange{{SpamClassHam, 1}}},
+		{"spam again", SpamClassSpam, SpamClassSpam, nil},
+		{"ham again", SpamClassHam, SpamClassHam, nil},
+		{"ham retrained as spam", SpamClassHam, SpamClassSpam, []spamCountChange{{SpamClassHam, -1}, {SpamClassSpam, 1}}},
+		{"spam retrained as ham", SpamClassSpam, SpamClassHam, []spamCountChange{{SpamClassSpam, -1}, {SpamClassHam, 1}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := spamTraining(tt.trained, tt.class)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpamTrainingRoundTrip(t *testing.T) {
+	// Flipping a comment back and forth must leave the counts as if it had only been
+	// taught its final class.
+	counts := map[string]int{}
+	trained := ""
+	for _, class := range []string{SpamClassSpam, SpamClassHam, SpamClassHam, SpamClassSpam, SpamClassHam} {
+		for _, change := range spamTraining(trained, class) {
+			counts[change.class] += change.delta
+		}
+		trained = class
+	}
+
+	want := map[string]int{SpamClassSpam: 0, SpamClassHam: 1}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("got counts %v; want %v", counts, want)
+	}
+}
@@ -0,0 +1,187 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"blog/internal/data/validator"
+)
+
+// suggestThreshold is the lowest word similarity a suggestion may have. It is lower
+// than pg_trgm's default of 0.6 so that words with a typo or two still match.
+const suggestThreshold = "0.3"
+
+// Suggestions are the post titles, tags and authors whose names look most like what
+// a reader has typed so far, each list ordered by similarity.
+type Suggestions struct {
+	Posts   []*PostSuggestion   `json:"posts"`
+	Tags    []*TagSuggestion    `json:"tags"`
+	Authors []*AuthorSuggestion `json:"authors"`
+}
+
+type PostSuggestion struct {
+	ID    int64   `json:"id"`
+	Title string  `json:"title"`
+	Slug  string  `json:"slug"`
+	Score float64 `json:"score"`
+}
+
+type TagSuggestion struct {
+	Name  string  `json:"name"`
+	Slug  string  `json:"slug"`
+	Score float64 `json:"score"`
+}
+
+type AuthorSuggestion struct {
+	ID    int64   `json:"id"`
+	Name  string  `json:"name"`
+	Slug  string  `json:"slug"`
+	Score float64 `json:"score"`
+}
+
+type SuggestModel struct {
+	DB *sql.DB
+}
+
+func ValidateSuggestQuery(v *validator.Validator, text string, limit int) {
+	v.Check(text != "", "q", "must be provided")
+	v.Check(len(text) <= 100, "q", "must not be more than 100 bytes long")
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 20, "limit", "must be a maximum of 20")
+}
+
+// Get returns up to limit suggestions of each kind for the text. Only published posts
+// are suggested, and only authors and tags which have any.
+func (m SuggestModel) Get(text string, limit int) (*Suggestions, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// The threshold is set for this transaction only, so the GIN trigram indexes can
+	// still be used for the <% operator.
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `SELECT set_config('pg_trgm.word_similarity_threshold', $1, true)`, suggestThreshold)
+	if err != nil {
+		return nil, err
+	}
+
+	suggestions := &Suggestions{
+		Posts:   []*PostSuggestion{},
+		Tags:    []*TagSuggestion{},
+		Authors: []*AuthorSuggestion{},
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT p.id, p.title, p.slug, word_similarity($1, p.title) AS score
+		FROM posts p
+		WHERE $1 <% p.title AND `+publicPostCondition+`
+		ORDER BY score DESC, p.published_at DESC
+		LIMIT $2`, text, limit)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var s PostSuggestion
+		if err := rows.Scan(&s.ID, &s.Title, &s.Slug, &s.Score); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		suggestions.Posts = append(suggestions.Posts, &s)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+		SELECT t.name, t.slug, word_similarity($1, t.name) AS score
+		FROM tags t
+		WHERE $1 <% t.name AND EXISTS (
+			SELECT 1 FROM post_tags pt JOIN posts p ON p.id = pt.post_id
+			WHERE pt.tag_id = t.id AND `+publicPostCondition+`
+		)
+		ORDER BY score DESC, t.name
+		LIMIT $2`, text, limit)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var s TagSuggestion
+		if err := rows.Scan(&s.Name, &s.Slug, &s.Score); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		suggestions.Tags = append(suggestions.Tags, &s)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+		SELECT u.id, u.name, u.slug, word_similarity($1, u.name) AS score
+		FROM users u
+		WHERE $1 <% u.name AND EXISTS (
+			SELECT 1 FROM posts p WHERE p.author_id = u.id AND `+publicPostCondition+`
+		)
+		ORDER BY score DESC, u.name
+		LIMIT $2`, text, limit)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var s AuthorSuggestion
+		if err := rows.Scan(&s.ID, &s.Name, &s.Slug, &s.Score); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		suggestions.Authors = append(suggestions.Authors, &s)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suggestions, tx.Commit()
+}
+
+// DidYouMean returns up to limit post titles and tag names resembling text, best match
+// first. It is offered when a search finds nothing.
+func (m SuggestModel) DidYouMean(text string, limit int) ([]string, error) {
+	suggestions, err := m.Get(text, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	type candidate struct {
+		text  string
+		score float64
+	}
+	var candidates []candidate
+	for _, s := range suggestions.Posts {
+		candidates = append(candidates, candidate{s.Title, s.Score})
+	}
+	for _, s := range suggestions.Tags {
+		candidates = append(candidates, candidate{s.Name, s.Score})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+
+	result := []string{}
+	for _, c := range candidates {
+		if len(result) == limit {
+			break
+		}
+		if !validator.PermittedValue(c.text, result...) {
+			result = append(result, c.text)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,226 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"blog/internal/data/validator"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDuplicateSlug = errors.New("duplicate slug")
+)
+
+// publicPostCondition matches posts which anonymous readers can see, with posts aliased
+// as p. It is used wherever posts are counted, searched or suggested for them.
+const publicPostCondition = `p.status = 'published' AND p.published_at <= NOW()`
+
+type Tag struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"`
+	PostCount int       `json:"post_count"`
+	CreatedAt time.Time `json:"created_at"`
+	Version   int32     `json:"version"`
+}
+
+type TagModel struct {
+	DB *sql.DB
+}
+
+func ValidateTag(v *validator.Validator, tag *Tag) {
+	v.Check(tag.Name != "", "name", "must be provided")
+	v.Check(len(tag.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(tag.Slug != "", "slug", "must be provided")
+	v.Check(len(tag.Slug) <= 100, "slug", "must not be more than 100 bytes long")
+	v.Check(validator.Matches(tag.Slug, SlugRX), "slug", "must only contain lowercase letters, digits and hyphens")
+}
+
+// ValidateTermSlugs checks a list of tag or category slugs given for a post.
+func ValidateTermSlugs(v *validator.Validator, key string, slugs []string) {
+	v.Check(len(slugs) <= 20, key, "must not contain more than 20 entries")
+	v.Check(validator.Unique(slugs), key, "must not contain duplicate values")
+	for _, slug := range slugs {
+		v.Check(validator.Matches(slug, SlugRX) && len(slug) <= 100, key, "must only contain valid slugs")
+	}
+}
+
+func (m TagModel) Insert(tag *Tag) error {
+	query := `
+		INSERT INTO tags (name, slug)
+		VALUES ($1, $2)
+		RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tag.Name, tag.Slug).Scan(&tag.ID, &tag.CreatedAt, &tag.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "tags_slug_key"`:
+			return ErrDuplicateSlug
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// GetBySlug returns the tag with the given slug, along with the number of public posts
+// filed under it.
+func (m TagModel) GetBySlug(slug string) (*Tag, error) {
+	if slug == "" {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT t.id, t.name, t.slug, count(p.id), t.created_at, t.version
+		FROM tags t
+		LEFT JOIN post_tags pt ON pt.tag_id = t.id
+		LEFT JOIN posts p ON p.id = pt.post_id AND ` + publicPostCondition + `
+		WHERE t.slug = $1
+		GROUP BY t.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var tag Tag
+	err := m.DB.QueryRowContext(ctx, query, slug).Scan(
+		&tag.ID,
+		&tag.Name,
+		&tag.Slug,
+		&tag.PostCount,
+		&tag.CreatedAt,
+		&tag.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &tag, nil
+}
+
+// GetAll returns every tag ordered by name, each with the number of public posts
+// filed under it.
+func (m TagModel) GetAll() ([]*Tag, error) {
+	query := `
+		SELECT t.id, t.name, t.slug, count(p.id), t.created_at, t.version
+		FROM tags t
+		LEFT JOIN post_tags pt ON pt.tag_id = t.id
+		LEFT JOIN posts p ON p.id = pt.post_id AND ` + publicPostCondition + `
+		GROUP BY t.id
+		ORDER BY t.name, t.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []*Tag{}
+	for rows.Next() {
+		var tag Tag
+		err := rows.Scan(
+			&tag.ID,
+			&tag.Name,
+			&tag.Slug,
+			&tag.PostCount,
+			&tag.CreatedAt,
+			&tag.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, &tag)
+	}
+
+	return tags, rows.Err()
+}
+
+func (m TagModel) Update(tag *Tag) error {
+	query := `
+		UPDATE tags
+		SET name = $1, slug = $2, version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tag.Name, tag.Slug, tag.ID, tag.Version).Scan(&tag.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "tags_slug_key"`:
+			return ErrDuplicateSlug
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m TagModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM tags WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// setPostTags replaces the tags of a post with the given slugs, creating any tags
+// which don't exist yet. New tags are named after their slug.
+func setPostTags(ctx context.Context, tx *sql.Tx, postID int64, slugs []string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM post_tags WHERE post_id = $1`, postID)
+	if err != nil {
+		return err
+	}
+
+	if len(slugs) == 0 {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO tags (name, slug)
+		SELECT slug, slug FROM unnest($1::text[]) AS slug
+		ON CONFLICT (slug) DO NOTHING`, pq.Array(slugs))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO post_tags (post_id, tag_id)
+		SELECT $1, id FROM tags WHERE slug = ANY($2)`, postID, pq.Array(slugs))
+	return err
+}
@@ -0,0 +1,223 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Upload is a resumable upload of an image to a post. Its bytes arrive in chunks,
+// which are kept in storage under ChunkKeys until the upload is complete.
+type Upload struct {
+	ID     string
+	UserID int64
+	PostID int64
+	Length int64
+	Offset int64
+	// Metadata is the Upload-Metadata header the upload was created with.
+	Metadata  string
+	ChunkKeys []string
+	// ImageID is the image made from a completed upload, unless it has since been
+	// deleted.
+	ImageID     *int64
+	CompletedAt *time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+type UploadModel struct {
+	DB *sql.DB
+}
+
+func (m UploadModel) Insert(upload *Upload) error {
+	query := `
+		INSERT INTO uploads (id, user_id, post_id, upload_length, metadata)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING created_at, updated_at`
+
+	args := []any{upload.ID, upload.UserID, upload.PostID, upload.Length, upload.Metadata}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&upload.CreatedAt, &upload.UpdatedAt)
+}
+
+func (m UploadModel) Get(id string) (*Upload, error) {
+	query := `
+		SELECT id, user_id, post_id, upload_length, upload_offset, metadata, chunk_keys,
+		       image_id, completed_at, created_at, updated_at
+		FROM uploads
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var upload Upload
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&upload.ID, &upload.UserID, &upload.PostID, &upload.Length, &upload.Offset,
+		&upload.Metadata, pq.Array(&upload.ChunkKeys), &upload.ImageID,
+		&upload.CompletedAt, &upload.CreatedAt, &upload.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &upload, nil
+}
+
+// GetAll returns every upload, complete or not, without its chunk keys.
+func (m UploadModel) GetAll() ([]*Upload, error) {
+	query := `
+		SELECT id, user_id, post_id, upload_length, upload_offset, metadata,
+		       image_id, completed_at, created_at, updated_at
+		FROM uploads
+		ORDER BY created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uploads := []*Upload{}
+	for rows.Next() {
+		var upload Upload
+		err := rows.Scan(
+			&upload.ID, &upload.UserID, &upload.PostID, &upload.Length, &upload.Offset,
+			&upload.Metadata, &upload.ImageID, &upload.CompletedAt, &upload.CreatedAt,
+			&upload.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, &upload)
+	}
+
+	return uploads, rows.Err()
+}
+
+// AppendChunk records a chunk of size bytes stored under key as the next part of the
+// upload. It returns ErrEditConflict unless the upload's offset is still the one the
+// chunk was written at, so that of two requests racing to write at the same offset
+// only one is accepted.
+func (m UploadModel) AppendChunk(upload *Upload, key string, size int64) error {
+	query := `
+		UPDATE uploads
+		SET upload_offset = upload_offset + $3, chunk_keys = array_append(chunk_keys, $4),
+		    updated_at = NOW()
+		WHERE id = $1 AND upload_offset = $2 AND completed_at IS NULL
+		RETURNING upload_offset, updated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, upload.ID, upload.Offset, size, key).Scan(
+		&upload.Offset, &upload.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	upload.ChunkKeys = append(upload.ChunkKeys, key)
+	return nil
+}
+
+// ReplaceLastChunk records a chunk stored under key as taking the place of the
+// upload's last chunk, which is lastKey, and as extending the upload by size bytes. It
+// returns ErrEditConflict as AppendChunk does, or if the last chunk is no longer
+// lastKey.
+func (m UploadModel) ReplaceLastChunk(upload *Upload, lastKey, key string, size int64) error {
+	query := `
+		UPDATE uploads
+		SET upload_offset = upload_offset + $3,
+		    chunk_keys = chunk_keys[1:cardinality(chunk_keys) - 1] || $5::text,
+		    updated_at = NOW()
+		WHERE id = $1 AND upload_offset = $2 AND completed_at IS NULL
+		  AND chunk_keys[cardinality(chunk_keys)] = $4
+		RETURNING upload_offset, updated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, upload.ID, upload.Offset, size, lastKey, key).Scan(
+		&upload.Offset, &upload.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	upload.ChunkKeys[len(upload.ChunkKeys)-1] = key
+	return nil
+}
+
+// Complete marks an upload as finished, with the image made from it. Its chunks are
+// no longer needed, so they are forgotten.
+func (m UploadModel) Complete(upload *Upload, imageID int64) error {
+	query := `
+		UPDATE uploads
+		SET image_id = $2, chunk_keys = '{}', completed_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND completed_at IS NULL
+		RETURNING completed_at, updated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, upload.ID, imageID).Scan(&upload.CompletedAt, &upload.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	upload.ImageID = &imageID
+	upload.ChunkKeys = nil
+	return nil
+}
+
+func (m UploadModel) Delete(id string) error {
+	query := `DELETE FROM uploads WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
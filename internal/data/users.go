@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"blog/internal/data/validator"
@@ -21,13 +22,34 @@ var AnonymousUser = &User{}
 // Define a User struct to represent an individual user. Importantly, notice how we are // using the json:"-" struct tag to prevent the Password and Version fields appearing in // any output when we encode it to JSON. Also notice that the Password field uses the
 // custom password type defined below.
 type User struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	Password  password  `json:"-"`
-	Activated bool      `json:"activated"`
-	Version   int       `json:"-"`
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Name        string    `json:"name"`
+	Slug        string    `json:"slug"`
+	Email       string    `json:"email"`
+	AvatarImage *string   `json:"avatar_image,omitempty"`
+	Password    password  `json:"-"`
+	Activated   bool      `json:"activated"`
+	Version     int       `json:"-"`
+}
+
+// Author is the public profile of a user, as embedded in post responses. It
+// deliberately leaves out the email address and account state.
+type Author struct {
+	ID          int64   `json:"id"`
+	Name        string  `json:"name"`
+	Slug        string  `json:"slug"`
+	AvatarImage *string `json:"avatar_image,omitempty"`
+}
+
+// Profile returns the public author profile for the user.
+func (u *User) Profile() *Author {
+	return &Author{
+		ID:          u.ID,
+		Name:        u.Name,
+		Slug:        u.Slug,
+		AvatarImage: u.AvatarImage,
+	}
 }
 
 type password struct {
@@ -102,23 +124,108 @@ func ValidateUser(v *validator.Validator, user *User) {
 // RETURNING clause to read them into the User struct after the insert, in the same way // that we did when creating a movie.
 func (m UserModel) Insert(user *User) error {
 	query := `
-			INSERT INTO users (name, email, password_hash, activated) VALUES ($1, $2, $3, $4)
+			INSERT INTO users (name, email, password_hash, activated, slug) VALUES ($1, $2, $3, $4, $5)
 			RETURNING id, created_at, version`
-	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	// If the table already contains a record with this email address, then when we try // to perform the insert there will be a violation of the UNIQUE "users_email_key" // constraint that we set up in the previous chapter. We check for this error
-	// specifically, and return custom ErrDuplicateEmail error instead.
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+
+	// Another registration for the same name can take the slug between our picking it
+	// and inserting, so on a clash we pick again.
+	for attempt := 1; ; attempt++ {
+		slug, err := m.uniqueSlug(ctx, Slugify(user.Name))
+		if err != nil {
+			return err
+		}
+		user.Slug = slug
+
+		args := []any{user.Name, user.Email, user.Password.hash, user.Activated, user.Slug}
+		// If the table already contains a record with this email address, then when we try // to perform the insert there will be a violation of the UNIQUE "users_email_key" // constraint that we set up in the previous chapter. We check for this error
+		// specifically, and return custom ErrDuplicateEmail error instead.
+		err = m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+				return ErrDuplicateEmail
+			case err.Error() == `pq: duplicate key value violates unique constraint "users_slug_key"` && attempt < maxSlugAttempts:
+				continue
+			default:
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// maxSlugAttempts is how many slugs Insert tries before giving up on a user whose name
+// other registrations keep racing for.
+const maxSlugAttempts = 5
+
+// uniqueSlug returns the first of base, base-2, base-3... which isn't already taken by
+// another user. Two registrations racing for the same name can still pick the same
+// one, which Insert deals with.
+func (m UserModel) uniqueSlug(ctx context.Context, base string) (string, error) {
+	if base == "" {
+		base = "author"
+	}
+
+	query := `
+		SELECT slug FROM users
+		WHERE slug = $1 OR slug ~ ('^' || $1 || '-[0-9]+$')`
+
+	rows, err := m.DB.QueryContext(ctx, query, base)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	taken := make(map[string]bool)
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			return "", err
+		}
+		taken[slug] = true
+	}
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+
+	slug := base
+	for n := 2; taken[slug]; n++ {
+		slug = fmt.Sprintf("%s-%d", base, n)
+	}
+	return slug, nil
+}
+
+// GetAuthor returns the public profile of the user with the given id. Only users with
+// at least one public post are authors; for anyone else it returns ErrRecordNotFound,
+// so that the accounts of readers and editors aren't exposed.
+func (m UserModel) GetAuthor(id int64) (*Author, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT u.id, u.name, u.slug, u.avatar_image
+		FROM users u
+		WHERE u.id = $1
+		AND EXISTS (SELECT 1 FROM posts p WHERE p.author_id = u.id AND ` + publicPostCondition + `)`
+
+	var author Author
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&author.ID, &author.Name, &author.Slug, &author.AvatarImage)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
-			return ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
 		default:
-			return err
+			return nil, err
 		}
 	}
-	return nil
+	return &author, nil
 }
 
 // Retrieve the User details from the database based on the user's email address.
@@ -126,7 +233,7 @@ func (m UserModel) Insert(user *User) error {
 // return one record (or none at all, in which case we return a ErrRecordNotFound error).
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-SELECT id, created_at, name, email, password_hash, activated, version FROM users
+SELECT id, created_at, name, slug, email, avatar_image, password_hash, activated, version FROM users
 WHERE email = $1`
 	var user User
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -135,7 +242,9 @@ WHERE email = $1`
 		&user.ID,
 		&user.CreatedAt,
 		&user.Name,
+		&user.Slug,
 		&user.Email,
+		&user.AvatarImage,
 		&user.Password.hash,
 		&user.Activated,
 		&user.Version,
@@ -157,10 +266,10 @@ WHERE email = $1`
 // record originally.
 func (m UserModel) Update(user *User) error {
 	query := ` UPDATE users
-			SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1 WHERE id = $5 AND version = $6
+			SET name = $1, email = $2, password_hash = $3, activated = $4, avatar_image = $5, version = version + 1 WHERE id = $6 AND version = $7
 			RETURNING version`
 	args := []any{user.Name,
-		user.Email, user.Password.hash, user.Activated, user.ID, user.Version,
+		user.Email, user.Password.hash, user.Activated, user.AvatarImage, user.ID, user.Version,
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -184,7 +293,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 	// Set up the SQL query.
 	query := `
-		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version FROM users
+		SELECT users.id, users.created_at, users.name, users.slug, users.email, users.avatar_image, users.password_hash, users.activated, users.version FROM users
 		INNER JOIN tokens
 		ON users.id = tokens.user_id
 		WHERE tokens.hash = $1
@@ -201,7 +310,9 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		&user.ID,
 		&user.CreatedAt,
 		&user.Name,
+		&user.Slug,
 		&user.Email,
+		&user.AvatarImage,
 		&user.Password.hash,
 		&user.Activated,
 		&user.Version,
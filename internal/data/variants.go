@@ -0,0 +1,100 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ImageVariant is a resized copy of an image blob, either one of the configured
+// presets generated on upload or one made on the fly for a width a client asked for.
+type ImageVariant struct {
+	ID        int64     `json:"id"`
+	BlobID    int64     `json:"-"`
+	Name      string    `json:"name"`
+	Filename  string    `json:"filename"`
+	FilePath  string    `json:"file_path"`
+	FileSize  int64     `json:"file_size"`
+	MimeType  string    `json:"mime_type"`
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ImageVariantModel struct {
+	DB *sql.DB
+}
+
+// Insert records a variant. Variants are generated deterministically from their blob
+// and name, so if another request got there first the existing row is kept.
+func (m ImageVariantModel) Insert(variant *ImageVariant) error {
+	query := `
+		INSERT INTO image_variants (blob_id, name, filename, file_path, file_size, mime_type, width, height)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (blob_id, name) DO UPDATE SET name = EXCLUDED.name
+		RETURNING id, created_at`
+
+	args := []any{
+		variant.BlobID, variant.Name, variant.Filename, variant.FilePath,
+		variant.FileSize, variant.MimeType, variant.Width, variant.Height,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&variant.ID, &variant.CreatedAt)
+}
+
+// Find returns the narrowest variant of the blob of the given type whose width lies
+// between minWidth and maxWidth inclusive.
+func (m ImageVariantModel) Find(blobID int64, mimeType string, minWidth, maxWidth int) (*ImageVariant, error) {
+	query := `
+		SELECT id, blob_id, name, filename, file_path, file_size, mime_type, width, height, created_at
+		FROM image_variants
+		WHERE blob_id = $1 AND mime_type = $2 AND width BETWEEN $3 AND $4
+		ORDER BY width
+		LIMIT 1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var variant ImageVariant
+	err := m.DB.QueryRowContext(ctx, query, blobID, mimeType, minWidth, maxWidth).Scan(
+		&variant.ID, &variant.BlobID, &variant.Name, &variant.Filename, &variant.FilePath,
+		&variant.FileSize, &variant.MimeType, &variant.Width, &variant.Height, &variant.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &variant, nil
+}
+
+func (m ImageVariantModel) Delete(id int64) error {
+	query := `DELETE FROM image_variants WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,397 @@
+// Package diff computes line-level differences between two texts and formats them as
+// unified diffs.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Op identifies what happened to a line.
+type Op int
+
+const (
+	Equal Op = iota
+	Insert
+	Delete
+)
+
+// Line is a single line of an edit script. OldLine and NewLine are the 1-based line
+// numbers in the old and new text; the one which doesn't apply is zero.
+//
+// A text which doesn't end in a newline has a marker after its last line, with Text
+// NoNewline and both line numbers zero, so that adding or removing the newline at the
+// end is an edit like any other.
+type Line struct {
+	Op      Op
+	Text    string
+	OldLine int
+	NewLine int
+}
+
+// NoNewline is the text of the marker standing for a missing newline at the end of a
+// text, as unified diffs show it.
+const NoNewline = `\ No newline at end of file`
+
+// isNoNewline reports whether l is the marker for a missing final newline.
+func (l Line) isNoNewline() bool {
+	return l.OldLine == 0 && l.NewLine == 0
+}
+
+// noNewline stands for the missing newline among the lines being compared. No line
+// split from a text can equal it, since it is a newline itself.
+const noNewline = "\n"
+
+// Lines returns the shortest edit script turning a into b, line by line, using Myers'
+// O((N+M)D) algorithm in linear space. Texts too different to search in reasonable
+// time get a longer script; see maxWork.
+func Lines(a, b string) []Line {
+	x, y := splitLines(a), splitLines(b)
+
+	// Lines shared at the start and end of both texts are never part of the edit, so
+	// strip them before running the (comparatively expensive) search.
+	prefix := 0
+	for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(x)-prefix && suffix < len(y)-prefix && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+		suffix++
+	}
+
+	script := make([]Line, 0, len(x)+len(y))
+	for i := 0; i < prefix; i++ {
+		script = append(script, Line{Op: Equal, Text: x[i], OldLine: i + 1, NewLine: i + 1})
+	}
+	for _, l := range myers(x[prefix:len(x)-suffix], y[prefix:len(y)-suffix]) {
+		if l.OldLine > 0 {
+			l.OldLine += prefix
+		}
+		if l.NewLine > 0 {
+			l.NewLine += prefix
+		}
+		script = append(script, l)
+	}
+	for i := suffix; i > 0; i-- {
+		script = append(script, Line{Op: Equal, Text: x[len(x)-i], OldLine: len(x) - i + 1, NewLine: len(y) - i + 1})
+	}
+
+	// The marker can only be last in either text, so the other lines are numbered
+	// as they would be without it.
+	for i := range script {
+		if script[i].Text == noNewline {
+			script[i] = Line{Op: script[i].Op, Text: NoNewline}
+		}
+	}
+	return script
+}
+
+// maxWork bounds the effort spent searching for the shortest edit script, as the
+// number of lines compared times the edits looked through. Texts which differ by more
+// than that allows get a correct but longer script, with the parts too different to
+// search replaced wholesale.
+const maxWork = 1 << 24
+
+// minEdits is the most edits always searched for, however long the texts.
+const minEdits = 256
+
+func myers(a, b []string) []Line {
+	if len(a)+len(b) == 0 {
+		return nil
+	}
+
+	s := &search{a: a, b: b, limit: minEdits}
+	if limit := maxWork / (len(a) + len(b)); limit > s.limit {
+		s.limit = limit
+	}
+	s.compare(0, len(a), 0, len(b))
+	return s.script
+}
+
+// search finds the edit script with the linear space variant of Myers' algorithm,
+// which splits the texts where the middle of the shortest path crosses them and
+// searches each side in turn, rather than keeping every round of the search.
+type search struct {
+	a, b []string
+	// limit is the most edits looked through when finding the middle of a path.
+	limit  int
+	script []Line
+}
+
+// compare appends the edit script turning a[aLo:aHi] into b[bLo:bHi].
+func (s *search) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && s.a[aLo] == s.b[bLo] {
+		s.equal(aLo, bLo)
+		aLo++
+		bLo++
+	}
+	suffix := 0
+	for aHi > aLo && bHi > bLo && s.a[aHi-1] == s.b[bHi-1] {
+		aHi--
+		bHi--
+		suffix++
+	}
+
+	switch {
+	case aLo == aHi || bLo == bHi:
+		s.replace(aLo, aHi, bLo, bHi)
+	default:
+		// With the shared lines stripped and both sides left, the texts are at least
+		// two edits apart, so each side of the middle is smaller than the whole.
+		if x, y, ok := s.middle(aLo, aHi, bLo, bHi); ok {
+			s.compare(aLo, x, bLo, y)
+			s.compare(x, aHi, y, bHi)
+		} else {
+			s.replace(aLo, aHi, bLo, bHi)
+		}
+	}
+
+	for i := 0; i < suffix; i++ {
+		s.equal(aHi+i, bHi+i)
+	}
+}
+
+// middle returns a point on the shortest path turning a[aLo:aHi] into b[bLo:bHi],
+// about halfway along it, found by searching forwards from the start and backwards
+// from the end at once until the two meet. It gives up, returning false, if that
+// takes more than the search's limit of edits.
+func (s *search) middle(aLo, aHi, bLo, bHi int) (x, y int, ok bool) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	odd := delta%2 != 0
+
+	maxD := (n + m + 1) / 2
+	if maxD > s.limit {
+		maxD = s.limit
+	}
+
+	// forward[off+k] holds how far along a the forward search has got on diagonal
+	// k = x - y, and backward[off+c] how far back from the end of a the backward
+	// search has got on diagonal c = (n - x) - (m - y), which is delta - k.
+	off := maxD + 1
+	forward := make([]int, 2*off+1)
+	backward := make([]int, 2*off+1)
+
+	for d := 0; d <= maxD; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && forward[off+k-1] < forward[off+k+1]) {
+				x = forward[off+k+1]
+			} else {
+				x = forward[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && s.a[aLo+x] == s.b[bLo+y] {
+				x++
+				y++
+			}
+			forward[off+k] = x
+
+			if c := delta - k; odd && c >= -(d-1) && c <= d-1 && x+backward[off+c] >= n {
+				return aLo + x, bLo + y, true
+			}
+		}
+
+		for c := -d; c <= d; c += 2 {
+			var u int
+			if c == -d || (c != d && backward[off+c-1] < backward[off+c+1]) {
+				u = backward[off+c+1]
+			} else {
+				u = backward[off+c-1] + 1
+			}
+			w := u - c
+			for u < n && w < m && s.a[aHi-1-u] == s.b[bHi-1-w] {
+				u++
+				w++
+			}
+			backward[off+c] = u
+
+			if k := delta - c; !odd && k >= -d && k <= d && forward[off+k]+u >= n {
+				return aHi - u, bHi - w, true
+			}
+		}
+	}
+
+	return 0, 0, false
+}
+
+func (s *search) equal(x, y int) {
+	s.script = append(s.script, Line{Op: Equal, Text: s.a[x], OldLine: x + 1, NewLine: y + 1})
+}
+
+// replace appends the deletion of a[aLo:aHi] followed by the insertion of b[bLo:bHi].
+func (s *search) replace(aLo, aHi, bLo, bHi int) {
+	for x := aLo; x < aHi; x++ {
+		s.script = append(s.script, Line{Op: Delete, Text: s.a[x], OldLine: x + 1})
+	}
+	for y := bLo; y < bHi; y++ {
+		s.script = append(s.script, Line{Op: Insert, Text: s.b[y], NewLine: y + 1})
+	}
+}
+
+// Unified returns the differences between a and b in unified diff format, with the
+// given number of context lines around each change. It returns an empty string when
+// the texts are identical. A last line without a newline is followed by a
+// "\ No newline at end of file" line, and shows as changed if the other text has
+// the same line with a newline.
+func Unified(fromName, toName, a, b string, context int) string {
+	script, oldLast, newLast := unifiedScript(Lines(a, b))
+
+	var sb strings.Builder
+	for _, h := range hunks(script, context) {
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		lines := script[h.start:h.end]
+
+		oldStart, oldCount, newStart, newCount := 0, 0, 0, 0
+		for _, l := range lines {
+			if l.Op != Insert {
+				if oldCount == 0 {
+					oldStart = l.OldLine
+				}
+				oldCount++
+			}
+			if l.Op != Delete {
+				if newCount == 0 {
+					newStart = l.NewLine
+				}
+				newCount++
+			}
+		}
+		// An empty range is reported as starting at the line before it.
+		if oldCount == 0 {
+			oldStart = lineBefore(script, h.start, func(l Line) int { return l.OldLine })
+		}
+		if newCount == 0 {
+			newStart = lineBefore(script, h.start, func(l Line) int { return l.NewLine })
+		}
+
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount))
+		for _, l := range lines {
+			switch l.Op {
+			case Equal:
+				sb.WriteString(" ")
+			case Insert:
+				sb.WriteString("+")
+			case Delete:
+				sb.WriteString("-")
+			}
+			sb.WriteString(l.Text)
+			sb.WriteString("\n")
+
+			if l.Op != Insert && l.OldLine == oldLast || l.Op != Delete && l.NewLine == newLast {
+				sb.WriteString(NoNewline + "\n")
+			}
+		}
+	}
+	return sb.String()
+}
+
+// unifiedScript rewrites an edit script for Unified, which shows missing newlines
+// after the lines they belong to rather than as lines of their own. The markers are
+// dropped, and a last line which only one of the texts ends without a newline is
+// deleted and inserted again rather than left unchanged. It also returns the number
+// of the last line of each text if that line has no newline, or zero.
+func unifiedScript(script []Line) (result []Line, oldLast, newLast int) {
+	var oldMissing, newMissing bool
+	for _, l := range script {
+		switch {
+		case l.isNoNewline():
+			oldMissing = oldMissing || l.Op != Insert
+			newMissing = newMissing || l.Op != Delete
+		case l.OldLine > oldLast:
+			oldLast = l.OldLine
+		}
+		if l.NewLine > newLast {
+			newLast = l.NewLine
+		}
+	}
+	if !oldMissing {
+		oldLast = 0
+	}
+	if !newMissing {
+		newLast = 0
+	}
+
+	result = make([]Line, 0, len(script)+1)
+	for _, l := range script {
+		switch {
+		case l.isNoNewline():
+		case l.Op == Equal && (l.OldLine == oldLast) != (l.NewLine == newLast):
+			result = append(result,
+				Line{Op: Delete, Text: l.Text, OldLine: l.OldLine},
+				Line{Op: Insert, Text: l.Text, NewLine: l.NewLine})
+		default:
+			result = append(result, l)
+		}
+	}
+	return result, oldLast, newLast
+}
+
+type hunk struct {
+	start, end int
+}
+
+// hunks groups the changes in the script into hunks, each padded with up to context
+// unchanged lines. Changes separated by no more than 2*context unchanged lines share a
+// hunk.
+func hunks(script []Line, context int) []hunk {
+	var result []hunk
+	for i := 0; i < len(script); i++ {
+		if script[i].Op == Equal {
+			continue
+		}
+
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		if n := len(result); n > 0 && start <= result[n-1].end {
+			start = result[n-1].start
+			result = result[:n-1]
+		}
+
+		// Extend over this run of changes.
+		end := i
+		for end < len(script) && script[end].Op != Equal {
+			end++
+		}
+		i = end - 1
+
+		end += context
+		if end > len(script) {
+			end = len(script)
+		}
+		result = append(result, hunk{start: start, end: end})
+	}
+	return result
+}
+
+func lineBefore(script []Line, i int, line func(Line) int) int {
+	for j := i - 1; j >= 0; j-- {
+		if n := line(script[j]); n > 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+func hunkRange(start, count int) string {
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// splitLines splits s into lines, followed by noNewline if s doesn't end in one.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	if !strings.HasSuffix(s, "\n") {
+		lines = append(lines, noNewline)
+	}
+	return lines
+}
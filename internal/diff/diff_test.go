@@ -0,0 +1,209 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name  string
+		a, b  string
+		edits int
+	}{
+		{"both empty", "", "", 0},
+		{"from empty", "", "a\nb\n", 2},
+		{"to empty", "a\nb\n", "", 2},
+		{"identical", "a\nb\nc\n", "a\nb\nc\n", 0},
+		{"trailing newline added", "a", "a\n", 1},
+		{"trailing newline removed", "a\nb\n", "a\nb", 1},
+		{"no trailing newline", "a\nb", "a\nc", 2},
+		{"blank line", "", "\n", 1},
+		{"line changed", "a\nb\nc\n", "a\nx\nc\n", 2},
+		{"line inserted", "a\nc\n", "a\nb\nc\n", 1},
+		{"line deleted", "a\nb\nc\n", "a\nc\n", 1},
+		{"lines moved", "a\nb\nc\nd\n", "c\nd\na\nb\n", 4},
+		{"repeated lines", "a\na\nb\na\n", "a\nb\na\na\n", 2},
+		{"everything changed", "a\nb\nc\n", "x\ny\n", 5},
+		{"myers paper", "a\nb\nc\na\nb\nb\na\n", "c\nb\na\nb\na\nc\n", 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := Lines(tt.a, tt.b)
+
+			edits := 0
+			for _, l := range script {
+				if l.Op != Equal {
+					edits++
+				}
+			}
+			if edits != tt.edits {
+				t.Errorf("got %d edits; want %d\n%v", edits, tt.edits, script)
+			}
+
+			checkScript(t, script, tt.a, tt.b)
+		})
+	}
+}
+
+// checkScript checks that applying script rebuilds a and b, with every line numbered
+// in order.
+func checkScript(t *testing.T, script []Line, a, b string) {
+	t.Helper()
+
+	var oldText, newText strings.Builder
+	var oldLines, newLines int
+	for _, l := range script {
+		if l.OldLine == 0 && l.NewLine == 0 {
+			if l.Text != NoNewline {
+				t.Errorf("line %q has no line numbers", l.Text)
+			}
+			if l.Op != Insert {
+				checkNoNewline(t, &oldText)
+			}
+			if l.Op != Delete {
+				checkNoNewline(t, &newText)
+			}
+			continue
+		}
+
+		if l.Op != Insert {
+			oldText.WriteString(l.Text + "\n")
+			oldLines++
+			if l.OldLine != oldLines {
+				t.Errorf("line %q has OldLine %d; want %d", l.Text, l.OldLine, oldLines)
+			}
+		} else if l.OldLine != 0 {
+			t.Errorf("inserted line %q has OldLine %d; want 0", l.Text, l.OldLine)
+		}
+
+		if l.Op != Delete {
+			newText.WriteString(l.Text + "\n")
+			newLines++
+			if l.NewLine != newLines {
+				t.Errorf("line %q has NewLine %d; want %d", l.Text, l.NewLine, newLines)
+			}
+		} else if l.NewLine != 0 {
+			t.Errorf("deleted line %q has NewLine %d; want 0", l.Text, l.NewLine)
+		}
+	}
+
+	if oldText.String() != a {
+		t.Errorf("script rebuilds old text as %q; want %q", oldText.String(), a)
+	}
+	if newText.String() != b {
+		t.Errorf("script rebuilds new text as %q; want %q", newText.String(), b)
+	}
+}
+
+// checkNoNewline drops the newline after the last line rebuilt so far, for a marker
+// saying the text has none.
+func checkNoNewline(t *testing.T, text *strings.Builder) {
+	t.Helper()
+
+	s := text.String()
+	if !strings.HasSuffix(s, "\n") {
+		t.Errorf("no newline marker after %q; want it after a line", s)
+		return
+	}
+	text.Reset()
+	text.WriteString(strings.TrimSuffix(s, "\n"))
+}
+
+func TestLinesLimit(t *testing.T) {
+	// Texts too different to search in full still get a script which turns one into
+	// the other.
+	var a, b strings.Builder
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&a, "a%d\n", i)
+		fmt.Fprintf(&b, "b%d\n", i%7)
+		if i%3 == 0 {
+			fmt.Fprintf(&b, "a%d\n", i)
+		}
+	}
+
+	checkScript(t, Lines(a.String(), b.String()), a.String(), b.String())
+}
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    string
+		context int
+		want    string
+	}{
+		{
+			name: "identical",
+			a:    "a\nb",
+			b:    "a\nb",
+			want: "",
+		},
+		{
+			name:    "trailing newline removed",
+			a:       "a\nb\n",
+			b:       "a\nb",
+			context: 3,
+			want:    "--- old\n+++ new\n@@ -1,2 +1,2 @@\n a\n-b\n+b\n\\ No newline at end of file\n",
+		},
+		{
+			name:    "trailing newline added",
+			a:       "a",
+			b:       "a\nb\n",
+			context: 3,
+			want:    "--- old\n+++ new\n@@ -1 +1,2 @@\n-a\n\\ No newline at end of file\n+a\n+b\n",
+		},
+		{
+			name:    "last line without newline changed",
+			a:       "a\nb",
+			b:       "a\nc",
+			context: 1,
+			want:    "--- old\n+++ new\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n",
+		},
+		{
+			name:    "context without newline",
+			a:       "a\nb",
+			b:       "x\nb",
+			context: 1,
+			want:    "--- old\n+++ new\n@@ -1,2 +1,2 @@\n-a\n+x\n b\n\\ No newline at end of file\n",
+		},
+		{
+			name:    "change with context",
+			a:       "1\n2\n3\n4\n5\n6\n7\n8\n",
+			b:       "1\n2\n3\nfour\n5\n6\n7\n8\n",
+			context: 2,
+			want:    "--- old\n+++ new\n@@ -2,5 +2,5 @@\n 2\n 3\n-4\n+four\n 5\n 6\n",
+		},
+		{
+			name:    "separate hunks",
+			a:       "1\n2\n3\n4\n5\n6\n7\n8\n",
+			b:       "one\n2\n3\n4\n5\n6\n7\neight\n",
+			context: 1,
+			want:    "--- old\n+++ new\n@@ -1,2 +1,2 @@\n-1\n+one\n 2\n@@ -7,2 +7,2 @@\n 7\n-8\n+eight\n",
+		},
+		{
+			name:    "from empty",
+			a:       "",
+			b:       "a\n",
+			context: 3,
+			want:    "--- old\n+++ new\n@@ -0,0 +1 @@\n+a\n",
+		},
+		{
+			name:    "insertion",
+			a:       "a\nc\n",
+			b:       "a\nb\nc\n",
+			context: 0,
+			want:    "--- old\n+++ new\n@@ -1,0 +2 @@\n+b\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Unified("old", "new", tt.a, tt.b, tt.context)
+			if got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+// Package events provides a small in-process event bus which lets parts of the
+// application react to things happening elsewhere, such as a post going live.
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Event names.
+const (
+	PostPublished = "post.published"
+	// PostUpdated is any other change to a post, such as an edit or it being taken
+	// off the site, and PostDeleted its deletion.
+	PostUpdated = "post.updated"
+	PostDeleted = "post.deleted"
+)
+
+// Event is a single occurrence of a named event along with its payload.
+type Event struct {
+	Name       string    `json:"event"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Data       any       `json:"data"`
+}
+
+// New returns an event with the given name and payload, stamped with the current time.
+func New(name string, data any) Event {
+	return Event{Name: name, OccurredAt: time.Now().UTC(), Data: data}
+}
+
+// Handler reacts to an event.
+type Handler func(ctx context.Context, e Event) error
+
+// Bus keeps track of the handlers subscribed to each event name. It doesn't run
+// handlers itself, so the caller decides how (and on which goroutines) they execute.
+type Bus struct {
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+}
+
+// NewBus returns an empty Bus.
+func NewBus() *Bus {
+	return &Bus{handlers: make(map[string][]Handler)}
+}
+
+// Subscribe registers a handler for the named event.
+func (b *Bus) Subscribe(name string, h Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[name] = append(b.handlers[name], h)
+}
+
+// Handlers returns the handlers subscribed to the named event.
+func (b *Bus) Handlers(name string) []Handler {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return append([]Handler(nil), b.handlers[name]...)
+}
@@ -0,0 +1,54 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// SignatureHeader carries the hex-encoded HMAC-SHA256 of the request body, keyed with
+// the webhook secret, so receivers can check a delivery came from us.
+const SignatureHeader = "X-Technoprise-Signature"
+
+// Webhook returns a Handler which POSTs each event as JSON to the given URL. If secret
+// is not empty the body is signed and the signature sent in SignatureHeader.
+func Webhook(url, secret string) Handler {
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	return func(ctx context.Context, e Event) error {
+		body, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Technoprise-Event", e.Name)
+
+		if secret != "" {
+			mac := hmac.New(sha256.New, []byte(secret))
+			mac.Write(body)
+			req.Header.Set(SignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("webhook %s responded with status %d", url, resp.StatusCode)
+		}
+		return nil
+	}
+}
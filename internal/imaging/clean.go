@@ -0,0 +1,326 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/draw"
+)
+
+// cleanQuality is the JPEG quality a photo is saved at when it has to be decoded and
+// encoded again to turn it the right way up.
+const cleanQuality = 92
+
+// Clean strips everything from an image but what's needed to display it: EXIF, XMP,
+// IPTC and comments. Cameras and phones record where a picture was taken and their
+// serial number, which mustn't be published along with it.
+//
+// EXIF also says which way up to show a photo. JPEG and PNG images are turned to
+// match it; WebP images, which we can't encode, keep their orientation and nothing
+// else of their EXIF, for browsers and Decode to turn them by. Either way info's Width
+// and Height are swapped if the image is shown on its side. Clean returns the cleaned
+// file and the part of the EXIF worth keeping, if any. The file must have passed
+// Inspect, which filled in info.
+func Clean(b []byte, info *Info) ([]byte, *Metadata, error) {
+	switch info.MimeType {
+	case "image/jpeg":
+		return cleanJPEG(b, info)
+	case "image/png":
+		return cleanPNG(b, info)
+	case "image/webp":
+		return cleanWebP(b, info)
+	case "image/gif":
+		return cleanGIF(b)
+	}
+	return nil, nil, ErrUnsupportedFormat
+}
+
+// cleanJPEG copies the segments of a JPEG, leaving out the application segments other
+// than the JFIF and Adobe headers and ICC colour profiles, comments, and anything after
+// the end of the image, such as the extra pictures some phones append.
+func cleanJPEG(b []byte, info *Info) ([]byte, *Metadata, error) {
+	var out bytes.Buffer
+	var icc [][]byte
+	e := &exif{}
+
+	out.Write(b[:2]) // SOI
+	p := 2
+	for {
+		if p+2 > len(b) || b[p] != 0xFF {
+			return nil, nil, ErrCorrupt
+		}
+		marker := b[p+1]
+		if marker == 0xFF { // fill byte
+			p++
+			continue
+		}
+		if marker == 0xD9 { // EOI
+			out.Write(b[p : p+2])
+			break
+		}
+
+		if p+4 > len(b) {
+			return nil, nil, ErrCorrupt
+		}
+		end := p + 2 + int(binary.BigEndian.Uint16(b[p+2:]))
+		if end < p+4 || end > len(b) {
+			return nil, nil, ErrCorrupt
+		}
+		segment, payload := b[p:end], b[p+4:end]
+
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")):
+			if e.tags == nil {
+				e = parseEXIF(payload[6:])
+			}
+		case marker == 0xE2 && bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00")):
+			icc = append(icc, segment)
+			out.Write(segment)
+		case marker == 0xE0 || marker == 0xEE: // JFIF, Adobe
+			out.Write(segment)
+		case marker >= 0xE0 && marker <= 0xEF, marker == 0xFE: // APPn, COM
+		default:
+			out.Write(segment)
+		}
+
+		if marker == 0xDA { // SOS
+			// The compressed data runs up to the next marker other than a restart
+			// marker; 0xFF in the data itself is followed by 0x00.
+			q := end
+			for q+1 < len(b) && (b[q] != 0xFF || b[q+1] == 0x00 || b[q+1] >= 0xD0 && b[q+1] <= 0xD7) {
+				q++
+			}
+			if q+1 >= len(b) {
+				return nil, nil, ErrCorrupt
+			}
+			out.Write(b[end:q])
+			end = q
+		}
+		p = end
+	}
+
+	o := e.orientation()
+	if o == 1 {
+		return out.Bytes(), e.metadata(), nil
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, nil, ErrCorrupt
+	}
+	img = orient(img, o)
+	info.Width, info.Height = img.Bounds().Dx(), img.Bounds().Dy()
+	encoded, err := Encode(img, info.MimeType, cleanQuality)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Put the colour profile back after the SOI, so the colours don't change.
+	out.Reset()
+	out.Write(encoded[:2])
+	for _, segment := range icc {
+		out.Write(segment)
+	}
+	out.Write(encoded[2:])
+
+	return out.Bytes(), e.metadata(), nil
+}
+
+// cleanPNG copies the chunks of a PNG, leaving out text, timestamps and EXIF. A PNG
+// which has to be turned is encoded again, losing its other ancillary chunks too.
+func cleanPNG(b []byte, info *Info) ([]byte, *Metadata, error) {
+	var out bytes.Buffer
+	e := &exif{}
+
+	out.Write(b[:8]) // signature
+	p := 8
+	for p < len(b) {
+		if p+12 > len(b) {
+			return nil, nil, ErrCorrupt
+		}
+		length := uint64(binary.BigEndian.Uint32(b[p:]))
+		if uint64(p)+12+length > uint64(len(b)) {
+			return nil, nil, ErrCorrupt
+		}
+		end := p + 12 + int(length)
+		typ := string(b[p+4 : p+8])
+
+		switch typ {
+		case "eXIf":
+			if e.tags == nil {
+				e = parseEXIF(b[p+8 : end-4])
+			}
+		case "tEXt", "zTXt", "iTXt", "tIME":
+		default:
+			out.Write(b[p:end])
+		}
+
+		p = end
+		if typ == "IEND" {
+			break
+		}
+	}
+
+	o := e.orientation()
+	if o == 1 {
+		return out.Bytes(), e.metadata(), nil
+	}
+
+	img, err := png.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, nil, ErrCorrupt
+	}
+	img = orient(img, o)
+	info.Width, info.Height = img.Bounds().Dx(), img.Bounds().Dy()
+	encoded, err := Encode(img, info.MimeType, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return encoded, e.metadata(), nil
+}
+
+// cleanWebP copies the chunks of a WebP, leaving out EXIF and XMP. If the EXIF gave an
+// orientation, a new EXIF chunk holding only that is added in its place.
+func cleanWebP(b []byte, info *Info) ([]byte, *Metadata, error) {
+	var chunks bytes.Buffer
+	e := &exif{}
+	flags := -1 // offset of the VP8X flags in chunks, if there are any
+
+	p := 12 // RIFF header
+	for p < len(b) {
+		if p+8 > len(b) {
+			return nil, nil, ErrCorrupt
+		}
+		fourCC := string(b[p : p+4])
+		size := uint64(binary.LittleEndian.Uint32(b[p+4:]))
+		if uint64(p)+8+size > uint64(len(b)) {
+			return nil, nil, ErrCorrupt
+		}
+		payload := b[p+8 : p+8+int(size)]
+		end := p + 8 + int(size+size%2)
+		if end > len(b) {
+			end = len(b)
+		}
+
+		switch fourCC {
+		case "EXIF":
+			if e.tags == nil {
+				e = parseEXIF(payload)
+			}
+		case "XMP ":
+		default:
+			if fourCC == "VP8X" && size > 0 {
+				flags = chunks.Len() + 8
+			}
+			// Chunks are padded to an even length, which some writers leave out at the
+			// end of the file.
+			chunks.Write(b[p : p+8+int(size)])
+			if size%2 == 1 {
+				chunks.WriteByte(0)
+			}
+		}
+		p = end
+	}
+
+	out := chunks.Bytes()
+	o := e.orientation()
+	if o >= 5 {
+		info.Width, info.Height = info.Height, info.Width
+	}
+	if flags >= 0 {
+		const exifFlag, xmpFlag = 0x08, 0x04
+		out[flags] &^= exifFlag | xmpFlag
+
+		if o != 1 {
+			out[flags] |= exifFlag
+			tiff := orientationEXIF(o)
+			out = binary.LittleEndian.AppendUint32(append(out, "EXIF"...), uint32(len(tiff)))
+			out = append(out, tiff...)
+		}
+	}
+
+	header := binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(4+len(out)))
+	header = append(header, "WEBP"...)
+	return append(header, out...), e.metadata(), nil
+}
+
+// cleanGIF drops the comments and application data of a GIF, such as XMP, by encoding
+// it again. GIFs are lossless, so the frames are unchanged. GIFs don't carry EXIF.
+func cleanGIF(b []byte) ([]byte, *Metadata, error) {
+	if !bytes.Contains(b, []byte{0x21, 0xFE}) && !bytes.Contains(b, []byte("XMP DataXMP")) {
+		return b, nil, nil
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(b))
+	if err != nil {
+		return nil, nil, ErrCorrupt
+	}
+
+	var out bytes.Buffer
+	err = gif.EncodeAll(&out, g)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out.Bytes(), nil, nil
+}
+
+// orient turns img the way EXIF orientation o says it should be shown.
+func orient(img image.Image, o int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	src := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx, dy := x, y
+			switch o {
+			case 2: // mirrored
+				dx = w - 1 - x
+			case 3: // upside down
+				dx, dy = w-1-x, h-1-y
+			case 4: // upside down and mirrored
+				dy = h - 1 - y
+			case 5: // mirrored along the diagonal
+				dx, dy = y, x
+			case 6: // needs a quarter turn clockwise
+				dx, dy = h-1-y, x
+			case 7: // mirrored along the other diagonal
+				dx, dy = h-1-y, w-1-x
+			case 8: // needs a quarter turn anticlockwise
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):][:4], src.Pix[src.PixOffset(x, y):][:4])
+		}
+	}
+
+	return dst
+}
+
+// webpOrientation returns the EXIF orientation of a WebP, or 1 if it has none.
+func webpOrientation(b []byte) int {
+	p := 12 // RIFF header
+	for p+8 <= len(b) {
+		size := uint64(binary.LittleEndian.Uint32(b[p+4:]))
+		if uint64(p)+8+size > uint64(len(b)) {
+			break
+		}
+		if string(b[p:p+4]) == "EXIF" {
+			return parseEXIF(b[p+8 : p+8+int(size)]).orientation()
+		}
+		p += 8 + int(size+size%2)
+	}
+	return 1
+}
@@ -0,0 +1,273 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// The tags of testEXIF which mustn't survive cleaning.
+const (
+	testGPSDatum = "GPS-MARKER-51.5074N"
+	testSerial   = "SERIAL-MARKER-0042"
+)
+
+var testMetadata = Metadata{
+	CapturedAt:   "2024-05-06T07:08:09+02:00",
+	CameraMake:   "TestCam",
+	CameraModel:  "Model 1",
+	FNumber:      2.8,
+	ExposureTime: "1/250",
+	ISO:          400,
+	FocalLength:  50,
+}
+
+func TestClean(t *testing.T) {
+	// The picture is 4x2, with its top left pixel red.
+	const width, height = 4, 2
+	src := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := range src.Pix {
+		src.Pix[i] = 0xFF
+	}
+	src.Set(0, 0, color.NRGBA{R: 0xFF, A: 0xFF})
+
+	formats := []struct {
+		ext   string
+		build func(t *testing.T, img *image.NRGBA, tiff []byte) []byte
+	}{
+		{".jpg", testJPEG},
+		{".png", testPNG},
+		{".webp", testWebP},
+	}
+
+	for _, f := range formats {
+		for o := 1; o <= 8; o++ {
+			b := f.build(t, src, testEXIF(o))
+
+			info, err := Inspect(b, f.ext)
+			if err != nil {
+				t.Fatalf("%s, orientation %d: Inspect: %v", f.ext, o, err)
+			}
+
+			cleaned, metadata, err := Clean(b, info)
+			if err != nil {
+				t.Fatalf("%s, orientation %d: Clean: %v", f.ext, o, err)
+			}
+
+			for _, marker := range []string{testGPSDatum, testSerial, testMetadata.CameraModel} {
+				if bytes.Contains(cleaned, []byte(marker)) {
+					t.Errorf("%s, orientation %d: cleaned file still holds %q", f.ext, o, marker)
+				}
+			}
+
+			wantW, wantH := width, height
+			if o >= 5 {
+				wantW, wantH = height, width
+			}
+			if info.Width != wantW || info.Height != wantH {
+				t.Errorf("%s, orientation %d: got info of %dx%d; want %dx%d", f.ext, o, info.Width, info.Height, wantW, wantH)
+			}
+
+			img, err := Decode(bytes.NewReader(cleaned))
+			if err != nil {
+				t.Fatalf("%s, orientation %d: Decode: %v", f.ext, o, err)
+			}
+			if got := img.Bounds(); got.Dx() != wantW || got.Dy() != wantH {
+				t.Errorf("%s, orientation %d: decoded %dx%d; want %dx%d", f.ext, o, got.Dx(), got.Dy(), wantW, wantH)
+			}
+
+			// PNGs are lossless, so show where the top left pixel went.
+			if f.ext == ".png" {
+				x, y := 0, 0
+				if o == 2 || o == 3 || o == 6 || o == 7 {
+					x = wantW - 1
+				}
+				if o == 3 || o == 4 || o == 7 || o == 8 {
+					y = wantH - 1
+				}
+				if r, g, _, _ := img.At(x, y).RGBA(); r != 0xFFFF || g != 0 {
+					t.Errorf("orientation %d: the top left pixel isn't at (%d, %d)", o, x, y)
+				}
+			}
+
+			if metadata == nil || *metadata != testMetadata {
+				t.Errorf("%s, orientation %d: got metadata %+v; want %+v", f.ext, o, metadata, testMetadata)
+			}
+		}
+	}
+}
+
+// testEXIF returns a little-endian TIFF structure giving the orientation o, the
+// details in testMetadata, a serial number and a GPS IFD.
+func testEXIF(o int) []byte {
+	type entry struct {
+		tag, typ uint16
+		value    []byte
+	}
+	le := binary.LittleEndian
+	ascii := func(tag uint16, s string) entry { return entry{tag, 2, append([]byte(s), 0)} }
+	short := func(tag uint16, v uint16) entry { return entry{tag, 3, le.AppendUint16(nil, v)} }
+	long := func(tag uint16, v uint32) entry { return entry{tag, 4, le.AppendUint32(nil, v)} }
+	rational := func(tag uint16, num, den uint32) entry {
+		return entry{tag, 5, le.AppendUint32(le.AppendUint32(nil, num), den)}
+	}
+
+	b := []byte("II*\x00\x00\x00\x00\x00")
+
+	// ifd appends an IFD holding entries, followed by the values too long to fit in
+	// them, and returns its offset.
+	ifd := func(entries ...entry) uint32 {
+		offset := uint32(len(b))
+		next := offset + 2 + 12*uint32(len(entries)) + 4
+		var values []byte
+
+		b = le.AppendUint16(b, uint16(len(entries)))
+		for _, e := range entries {
+			b = le.AppendUint16(b, e.tag)
+			b = le.AppendUint16(b, e.typ)
+			b = le.AppendUint32(b, uint32(len(e.value)/exifTypeSize(e.typ)))
+			if len(e.value) <= 4 {
+				b = append(b, e.value...)
+				b = append(b, make([]byte, 4-len(e.value))...)
+			} else {
+				b = le.AppendUint32(b, next+uint32(len(values)))
+				values = append(values, e.value...)
+			}
+		}
+		b = le.AppendUint32(b, 0)
+		b = append(b, values...)
+		return offset
+	}
+
+	gps := ifd(
+		ascii(0x0001, "N"),          // GPSLatitudeRef
+		rational(0x0002, 51, 1),     // GPSLatitude
+		ascii(0x0012, testGPSDatum), // GPSMapDatum
+	)
+	exifIFD := ifd(
+		rational(tagExposureTime, 1, 250),
+		rational(tagFNumber, 28, 10),
+		short(tagISO, 400),
+		ascii(tagDateTimeOriginal, "2024:05:06 07:08:09"),
+		ascii(tagOffsetTimeOriginal, "+02:00"),
+		rational(tagFocalLength, 50, 1),
+		ascii(0xA431, testSerial), // BodySerialNumber
+	)
+	ifd0 := ifd(
+		ascii(tagMake, testMetadata.CameraMake),
+		ascii(tagModel, testMetadata.CameraModel),
+		short(tagOrientation, uint16(o)),
+		long(tagExifIFD, exifIFD),
+		long(0x8825, gps), // GPSInfo
+	)
+	le.PutUint32(b[4:], ifd0)
+
+	return b
+}
+
+// testJPEG encodes img as a JPEG with tiff in an APP1 segment.
+func testJPEG(t *testing.T, img *image.NRGBA, tiff []byte) []byte {
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, img, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := binary.BigEndian.AppendUint16([]byte{0xFF, 0xE1}, uint16(2+len(payload)))
+	app1 = append(app1, payload...)
+
+	return append(append(append([]byte{}, b[:2]...), app1...), b[2:]...)
+}
+
+// testPNG encodes img as a PNG with tiff in an eXIf chunk after the header.
+func testPNG(t *testing.T, img *image.NRGBA, tiff []byte) []byte {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+
+	const afterIHDR = 8 + 12 + 13
+	return append(append(append([]byte{}, b[:afterIHDR]...), pngChunk("eXIf", tiff)...), b[afterIHDR:]...)
+}
+
+func pngChunk(typ string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, typ...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// testWebP returns an extended WebP the size of img with tiff in its EXIF chunk. There
+// is no WebP encoder to hand, so the picture is a lossless bitstream of a single
+// colour, whose prefix codes each have one symbol and so take no bits per pixel.
+func testWebP(t *testing.T, img *image.NRGBA, tiff []byte) []byte {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+
+	var bits bitWriter
+	bits.write(0x2F, 8) // signature
+	bits.write(uint32(w-1), 14)
+	bits.write(uint32(h-1), 14)
+	bits.write(0, 1) // no alpha
+	bits.write(0, 3) // version
+	bits.write(0, 1) // no transforms
+	bits.write(0, 1) // no colour cache
+	bits.write(0, 1) // no meta prefix codes
+	// Green, red, blue, alpha and distance codes: simple, with one 8 bit symbol.
+	for _, symbol := range []uint32{0xFF, 0xFF, 0xFF, 0xFF, 0} {
+		bits.write(1, 1)
+		bits.write(0, 1)
+		bits.write(1, 1)
+		bits.write(symbol, 8)
+	}
+	vp8l := bits.bytes()
+
+	vp8x := []byte{0x08, 0, 0, 0} // EXIF flag
+	vp8x = append(vp8x, byte(w-1), byte((w-1)>>8), byte((w-1)>>16))
+	vp8x = append(vp8x, byte(h-1), byte((h-1)>>8), byte((h-1)>>16))
+
+	var chunks []byte
+	for _, c := range []struct {
+		fourCC string
+		data   []byte
+	}{{"VP8X", vp8x}, {"VP8L", vp8l}, {"EXIF", tiff}} {
+		chunks = append(chunks, c.fourCC...)
+		chunks = binary.LittleEndian.AppendUint32(chunks, uint32(len(c.data)))
+		chunks = append(chunks, c.data...)
+		if len(c.data)%2 == 1 {
+			chunks = append(chunks, 0)
+		}
+	}
+
+	b := binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(4+len(chunks)))
+	b = append(b, "WEBP"...)
+	return append(b, chunks...)
+}
+
+// bitWriter packs values least significant bit first, as VP8L reads them.
+type bitWriter struct {
+	buf []byte
+	n   uint
+}
+
+func (w *bitWriter) write(v uint32, bits uint) {
+	for i := uint(0); i < bits; i++ {
+		if w.n%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		w.buf[len(w.buf)-1] |= byte(v>>i&1) << (w.n % 8)
+		w.n++
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	return w.buf
+}
@@ -0,0 +1,258 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Metadata is the part of an image's EXIF kept after the file itself is stripped:
+// when the picture was taken and with what. Everything else, including the location,
+// the owner's name and serial numbers, is thrown away.
+type Metadata struct {
+	// CapturedAt is when the picture was taken, as 2006-01-02T15:04:05 in the camera's
+	// local time, followed by its offset from UTC if the camera recorded one.
+	CapturedAt   string  `json:"captured_at,omitempty"`
+	CameraMake   string  `json:"camera_make,omitempty"`
+	CameraModel  string  `json:"camera_model,omitempty"`
+	LensModel    string  `json:"lens_model,omitempty"`
+	FNumber      float64 `json:"f_number,omitempty"`
+	ExposureTime string  `json:"exposure_time,omitempty"`
+	ISO          int     `json:"iso,omitempty"`
+	FocalLength  float64 `json:"focal_length,omitempty"`
+}
+
+// EXIF tags read into Metadata, along with the orientation and the pointer to the
+// EXIF sub-IFD which holds most of them.
+const (
+	tagMake               = 0x010F
+	tagModel              = 0x0110
+	tagOrientation        = 0x0112
+	tagDateTime           = 0x0132
+	tagExifIFD            = 0x8769
+	tagExposureTime       = 0x829A
+	tagFNumber            = 0x829D
+	tagISO                = 0x8827
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+	tagFocalLength        = 0x920A
+	tagLensModel          = 0xA434
+)
+
+// maxTextLength bounds the text fields copied out of EXIF.
+const maxTextLength = 100
+
+// exif holds the tags read from a TIFF structure, as found in JPEG APP1 segments and
+// PNG and WebP EXIF chunks.
+type exif struct {
+	order binary.ByteOrder
+	b     []byte
+	tags  map[uint16]exifEntry
+}
+
+type exifEntry struct {
+	typ   uint16
+	value []byte
+}
+
+// parseEXIF reads IFD0 and the EXIF sub-IFD of a TIFF structure. It is forgiving:
+// whatever can't be read is left out, since the EXIF is about to be thrown away and
+// only matters for what it can tell us.
+func parseEXIF(b []byte) *exif {
+	// Some writers keep the JPEG APP1 header when putting EXIF in other formats.
+	b = bytes.TrimPrefix(b, []byte("Exif\x00\x00"))
+
+	e := &exif{b: b, tags: map[uint16]exifEntry{}}
+	if len(b) < 8 {
+		return e
+	}
+	switch string(b[:4]) {
+	case "II*\x00":
+		e.order = binary.LittleEndian
+	case "MM\x00*":
+		e.order = binary.BigEndian
+	default:
+		return e
+	}
+
+	e.readIFD(e.order.Uint32(b[4:8]))
+	if entry, ok := e.tags[tagExifIFD]; ok && len(entry.value) == 4 {
+		e.readIFD(e.order.Uint32(entry.value))
+	}
+	return e
+}
+
+// readIFD adds the entries of the IFD at offset to the tags, keeping any read before.
+func (e *exif) readIFD(offset uint32) {
+	if uint64(offset)+2 > uint64(len(e.b)) {
+		return
+	}
+	n := int(e.order.Uint16(e.b[offset:]))
+	start := int(offset) + 2
+
+	for i := 0; i < n; i++ {
+		p := start + i*12
+		if p+12 > len(e.b) {
+			return
+		}
+		tag := e.order.Uint16(e.b[p:])
+		if _, ok := e.tags[tag]; ok {
+			continue
+		}
+
+		entry := exifEntry{typ: e.order.Uint16(e.b[p+2:])}
+		size := uint64(exifTypeSize(entry.typ)) * uint64(e.order.Uint32(e.b[p+4:]))
+		if size == 0 {
+			continue
+		}
+		if size <= 4 {
+			entry.value = e.b[p+8 : p+8+int(size)]
+		} else {
+			off := uint64(e.order.Uint32(e.b[p+8:]))
+			if off+size > uint64(len(e.b)) {
+				continue
+			}
+			entry.value = e.b[off : off+size]
+		}
+		e.tags[tag] = entry
+	}
+}
+
+func exifTypeSize(typ uint16) int {
+	switch typ {
+	case 1, 2, 6, 7: // BYTE, ASCII, SBYTE, UNDEFINED
+		return 1
+	case 3, 8: // SHORT, SSHORT
+		return 2
+	case 4, 9: // LONG, SLONG
+		return 4
+	case 5, 10: // RATIONAL, SRATIONAL
+		return 8
+	}
+	return 0
+}
+
+// uint returns the first value of a SHORT or LONG tag.
+func (e *exif) uint(tag uint16) (uint32, bool) {
+	entry, ok := e.tags[tag]
+	if !ok {
+		return 0, false
+	}
+	switch entry.typ {
+	case 3:
+		return uint32(e.order.Uint16(entry.value)), true
+	case 4:
+		return e.order.Uint32(entry.value), true
+	}
+	return 0, false
+}
+
+// rational returns the first value of a RATIONAL tag as its numerator and denominator.
+func (e *exif) rational(tag uint16) (uint32, uint32, bool) {
+	entry, ok := e.tags[tag]
+	if !ok || entry.typ != 5 {
+		return 0, 0, false
+	}
+	num, den := e.order.Uint32(entry.value), e.order.Uint32(entry.value[4:])
+	if den == 0 {
+		return 0, 0, false
+	}
+	return num, den, true
+}
+
+// text returns an ASCII tag, cut at its terminating NUL and trimmed.
+func (e *exif) text(tag uint16) string {
+	entry, ok := e.tags[tag]
+	if !ok || entry.typ != 2 {
+		return ""
+	}
+	s := string(entry.value)
+	if i := strings.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	s = strings.TrimSpace(strings.ToValidUTF8(s, ""))
+	for len(s) > maxTextLength {
+		_, size := utf8.DecodeLastRuneInString(s)
+		s = s[:len(s)-size]
+	}
+	return s
+}
+
+// orientation returns the EXIF orientation, 1 to 8, or 1 if there's none.
+func (e *exif) orientation() int {
+	o, ok := e.uint(tagOrientation)
+	if !ok || o < 1 || o > 8 {
+		return 1
+	}
+	return int(o)
+}
+
+// metadata returns the whitelisted tags, or nil if none were found.
+func (e *exif) metadata() *Metadata {
+	m := &Metadata{
+		CameraMake:  e.text(tagMake),
+		CameraModel: e.text(tagModel),
+		LensModel:   e.text(tagLensModel),
+	}
+
+	m.CapturedAt = exifTime(e.text(tagDateTimeOriginal), e.text(tagOffsetTimeOriginal))
+	if m.CapturedAt == "" {
+		m.CapturedAt = exifTime(e.text(tagDateTime), "")
+	}
+
+	if num, den, ok := e.rational(tagFNumber); ok {
+		m.FNumber = round(float64(num)/float64(den), 1)
+	}
+	if num, den, ok := e.rational(tagFocalLength); ok {
+		m.FocalLength = round(float64(num)/float64(den), 1)
+	}
+	if num, den, ok := e.rational(tagExposureTime); ok && num > 0 {
+		if num >= den {
+			m.ExposureTime = strconv.FormatFloat(round(float64(num)/float64(den), 1), 'f', -1, 64)
+		} else {
+			m.ExposureTime = "1/" + strconv.FormatFloat(math.Round(float64(den)/float64(num)), 'f', -1, 64)
+		}
+	}
+	if iso, ok := e.uint(tagISO); ok {
+		m.ISO = int(iso)
+	}
+
+	if *m == (Metadata{}) {
+		return nil
+	}
+	return m
+}
+
+// exifTime converts an EXIF date, as 2006:01:02 15:04:05, and optional offset, as
+// +01:00, to CapturedAt's format. It returns "" if the date isn't valid.
+func exifTime(date, offset string) string {
+	t, err := time.Parse("2006:01:02 15:04:05", date)
+	if err != nil {
+		return ""
+	}
+	s := t.Format("2006-01-02T15:04:05")
+	if _, err := time.Parse("-07:00", offset); err == nil {
+		s += offset
+	}
+	return s
+}
+
+func round(f float64, places int) float64 {
+	p := math.Pow(10, float64(places))
+	return math.Round(f*p) / p
+}
+
+// orientationEXIF returns a TIFF structure holding nothing but an orientation, for
+// images which can't be rotated here and so must keep telling viewers to do it.
+func orientationEXIF(o int) []byte {
+	return []byte{
+		'I', 'I', '*', 0, 8, 0, 0, 0, // header, IFD0 at offset 8
+		1, 0, // one entry
+		0x12, 0x01, 3, 0, 1, 0, 0, 0, byte(o), 0, 0, 0, // Orientation, SHORT, 1 value
+		0, 0, 0, 0, // no next IFD
+	}
+}
@@ -0,0 +1,150 @@
+// Package imaging inspects uploaded images, working out what they really are from
+// their contents rather than from what the client claims.
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"net/http"
+	"strings"
+
+	"blog/internal/data/validator"
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("imaging: unsupported image format")
+	ErrFormatMismatch    = errors.New("imaging: file contents do not match its type")
+	ErrPolyglot          = errors.New("imaging: file contains data besides the image")
+	ErrTooLarge          = errors.New("imaging: image dimensions too large")
+	ErrCorrupt           = errors.New("imaging: image could not be decoded")
+)
+
+// MaxPixels bounds the width times height of an image we'll decode, so that a small
+// file claiming huge dimensions can't exhaust memory.
+const MaxPixels = 50_000_000
+
+// Extensions maps the MIME types we accept to the file extensions they may be
+// uploaded with.
+var Extensions = map[string][]string{
+	"image/jpeg": {".jpg", ".jpeg"},
+	"image/png":  {".png"},
+	"image/gif":  {".gif"},
+	"image/webp": {".webp"},
+}
+
+// formats maps the names the image package registers decoders under to MIME types.
+var formats = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+	"webp": "image/webp",
+}
+
+// markup is text which has no business in an image, and which browsers or servers
+// might act on if the file were ever served with the wrong type.
+var markup = [][]byte{
+	[]byte("<?php"),
+	[]byte("<script"),
+	[]byte("<html"),
+	[]byte("<svg"),
+	[]byte("<iframe"),
+}
+
+// Info describes an image as found by Inspect.
+type Info struct {
+	MimeType string
+	Width    int
+	Height   int
+}
+
+// Inspect sniffs the type of the image in b, checks it against the file extension it
+// was uploaded with, and decodes it to make sure it is a well-formed image and nothing
+// else. It returns the image's real type and dimensions.
+func Inspect(b []byte, ext string) (*Info, error) {
+	mimeType := http.DetectContentType(b)
+	exts, ok := Extensions[mimeType]
+	if !ok {
+		return nil, ErrUnsupportedFormat
+	}
+	if !validator.PermittedValue(strings.ToLower(ext), exts...) {
+		return nil, ErrFormatMismatch
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	if formats[format] != mimeType {
+		return nil, ErrFormatMismatch
+	}
+	if config.Width < 1 || config.Height < 1 {
+		return nil, ErrCorrupt
+	}
+	if int64(config.Width)*int64(config.Height) > MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	err = checkContents(b, format)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Info{MimeType: mimeType, Width: config.Width, Height: config.Height}, nil
+}
+
+// checkContents decodes the whole image and makes sure nothing follows it, which is
+// where polyglot files usually keep their second payload. JPEGs are the exception,
+// being checked only for markup.
+func checkContents(b []byte, format string) error {
+	lower := bytes.ToLower(b)
+	for _, m := range markup {
+		if bytes.Contains(lower, m) {
+			return ErrPolyglot
+		}
+	}
+
+	r := bytes.NewReader(b)
+	switch format {
+	case "gif":
+		// The GIF and PNG decoders stop reading at the end of the image, so anything
+		// still unread was appended to it.
+		if _, err := gif.DecodeAll(r); err != nil {
+			return ErrCorrupt
+		}
+		if r.Len() > 0 {
+			return ErrPolyglot
+		}
+	case "png":
+		if _, err := png.Decode(r); err != nil {
+			return ErrCorrupt
+		}
+		if r.Len() > 0 {
+			return ErrPolyglot
+		}
+	case "jpeg":
+		// Phones append all sorts after the end of a JPEG, such as the video of a
+		// motion photo, so data there is allowed; the markup check above has looked
+		// through it, and Clean strips it.
+		if _, _, err := image.Decode(r); err != nil {
+			return ErrCorrupt
+		}
+	case "webp":
+		if _, _, err := image.Decode(r); err != nil {
+			return ErrCorrupt
+		}
+		// A RIFF header gives the size of the rest of the file, padded to an even
+		// number of bytes.
+		size := int64(binary.LittleEndian.Uint32(b[4:8]))
+		if size+size%2 != int64(len(b)-8) && size != int64(len(b)-8) {
+			return ErrPolyglot
+		}
+	}
+
+	return nil
+}
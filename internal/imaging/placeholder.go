@@ -0,0 +1,139 @@
+package imaging
+
+import (
+	"encoding/base64"
+	"image"
+	"math"
+	"strings"
+)
+
+const (
+	// placeholderSize is the longest side an image is shrunk to before its BlurHash is
+	// worked out. The hash only holds a few colours, so more pixels would be wasted.
+	placeholderSize = 32
+	// lqipSize is the longest side of the tiny copy of an image inlined as its LQIP.
+	lqipSize = 16
+	// lqipQuality is the JPEG quality of LQIPs, which are blurred when shown anyway.
+	lqipQuality = 40
+)
+
+// Placeholders returns what a client can show while an image loads: its BlurHash, and
+// a tiny copy of it as a data URL (a low quality image placeholder, or LQIP).
+func Placeholders(img image.Image, mimeType string) (blurHash, lqip string, err error) {
+	small := Fit(img, placeholderSize)
+
+	xComponents, yComponents := 4, 3
+	if small.Bounds().Dy() > small.Bounds().Dx() {
+		xComponents, yComponents = 3, 4
+	}
+	blurHash = BlurHash(small, xComponents, yComponents)
+
+	lqipType := OutputType(mimeType)
+	b, err := Encode(Fit(small, lqipSize), lqipType, lqipQuality)
+	if err != nil {
+		return "", "", err
+	}
+	lqip = "data:" + lqipType + ";base64," + base64.StdEncoding.EncodeToString(b)
+
+	return blurHash, lqip, nil
+}
+
+// BlurHash encodes img as a BlurHash (https://blurha.sh) with the given number of
+// components across and down, each between 1 and 9.
+func BlurHash(img image.Image, xComponents, yComponents int) string {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	// The image in linear RGB, which the components are worked out in.
+	linear := make([][3]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			linear[y*w+x] = [3]float64{sRGBToLinear(r >> 8), sRGBToLinear(g >> 8), sRGBToLinear(b >> 8)}
+		}
+	}
+
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+
+			var f [3]float64
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					basis := math.Cos(math.Pi*float64(i)*float64(x)/float64(w)) *
+						math.Cos(math.Pi*float64(j)*float64(y)/float64(h))
+					for c := 0; c < 3; c++ {
+						f[c] += basis * linear[y*w+x][c]
+					}
+				}
+			}
+
+			scale := normalisation / float64(w*h)
+			factors = append(factors, [3]float64{f[0] * scale, f[1] * scale, f[2] * scale})
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(base83((xComponents-1)+(yComponents-1)*9, 1))
+
+	dc, ac := factors[0], factors[1:]
+	maximum := 1.0
+	if len(ac) > 0 {
+		actual := 0.0
+		for _, f := range ac {
+			actual = math.Max(actual, math.Max(math.Abs(f[0]), math.Max(math.Abs(f[1]), math.Abs(f[2]))))
+		}
+		quantised := int(math.Max(0, math.Min(82, math.Floor(actual*166-0.5))))
+		maximum = float64(quantised+1) / 166
+		hash.WriteString(base83(quantised, 1))
+	} else {
+		hash.WriteString(base83(0, 1))
+	}
+
+	hash.WriteString(base83(linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4))
+
+	for _, f := range ac {
+		quant := func(v float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(signPow(v/maximum, 0.5)*9+9.5))))
+		}
+		hash.WriteString(base83(quant(f[0])*19*19+quant(f[1])*19+quant(f[2]), 2))
+	}
+
+	return hash.String()
+}
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// base83 encodes n in the given number of BlurHash base 83 digits.
+func base83(n, length int) string {
+	b := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		b[i] = base83Chars[n%83]
+		n /= 83
+	}
+	return string(b)
+}
+
+func sRGBToLinear(v uint32) float64 {
+	f := float64(v) / 255
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
@@ -0,0 +1,91 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestPlaceholders(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		mimeType      string
+		lqipW, lqipH  int
+	}{
+		{"landscape", 640, 480, "image/jpeg", 16, 12},
+		{"portrait", 480, 640, "image/png", 12, 16},
+		{"small", 10, 5, "image/png", 10, 5},
+		{"tall and narrow", 8, 200000, "image/jpeg", 1, 16},
+		{"wide and short", 200000, 8, "image/png", 16, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := image.NewNRGBA(image.Rect(0, 0, tt.width, tt.height))
+			for i := range img.Pix {
+				img.Pix[i] = uint8(i)
+			}
+
+			blurHash, lqip, err := Placeholders(img, tt.mimeType)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// A BlurHash with 3x4 or 4x3 components is always 28 characters long.
+			if len(blurHash) != 28 {
+				t.Errorf("got BlurHash %q of length %d; want 28", blurHash, len(blurHash))
+			}
+
+			prefix := "data:" + tt.mimeType + ";base64,"
+			data, ok := strings.CutPrefix(lqip, prefix)
+			if !ok {
+				t.Fatalf("got LQIP %.40q; want prefix %q", lqip, prefix)
+			}
+			b, err := base64.StdEncoding.DecodeString(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var cfg image.Config
+			switch tt.mimeType {
+			case "image/jpeg":
+				cfg, err = jpeg.DecodeConfig(bytes.NewReader(b))
+			case "image/png":
+				cfg, err = png.DecodeConfig(bytes.NewReader(b))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Width != tt.lqipW || cfg.Height != tt.lqipH {
+				t.Errorf("got LQIP of %dx%d; want %dx%d", cfg.Width, cfg.Height, tt.lqipW, tt.lqipH)
+			}
+		})
+	}
+}
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		width, height int
+		size          int
+		wantW, wantH  int
+	}{
+		{100, 50, 32, 32, 16},
+		{50, 100, 32, 16, 32},
+		{20, 10, 32, 20, 10},
+		{1, 100000, 16, 1, 16},
+		{100000, 1, 16, 16, 1},
+	}
+
+	for _, tt := range tests {
+		img := image.NewGray(image.Rect(0, 0, tt.width, tt.height))
+		got := Fit(img, tt.size).Bounds()
+		if got.Dx() != tt.wantW || got.Dy() != tt.wantH {
+			t.Errorf("Fit(%dx%d, %d) = %dx%d; want %dx%d", tt.width, tt.height, tt.size, got.Dx(), got.Dy(), tt.wantW, tt.wantH)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// DefaultVariants are the sizes generated for every upload unless configured otherwise.
+const DefaultVariants = "thumb:320:70,medium:800:80,large:1600:85"
+
+// DefaultQuality is the JPEG quality of resized images with no preset to take it from.
+const DefaultQuality = 80
+
+// Formats are the output formats a resized image may be asked for in, by the name used
+// in the fmt query parameter. Only formats the standard library can encode are offered.
+var Formats = map[string]string{
+	"jpeg": "image/jpeg",
+	"jpg":  "image/jpeg",
+	"png":  "image/png",
+}
+
+// Variant is a preset size generated for each uploaded image: no wider than MaxWidth,
+// and encoded at Quality if it's a JPEG.
+type Variant struct {
+	Name     string
+	MaxWidth int
+	Quality  int
+}
+
+// ParseVariants reads a comma separated list of name:max_width:quality presets, as in
+// DefaultVariants. The presets are returned narrowest first.
+func ParseVariants(s string) ([]Variant, error) {
+	var variants []Variant
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		parts := strings.Split(field, ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid image variant %q: must be name:max_width:quality", field)
+		}
+		width, err := strconv.Atoi(parts[1])
+		if err != nil || width < 1 {
+			return nil, fmt.Errorf("invalid image variant %q: max width must be a positive integer", field)
+		}
+		quality, err := strconv.Atoi(parts[2])
+		if err != nil || quality < 1 || quality > 100 {
+			return nil, fmt.Errorf("invalid image variant %q: quality must be between 1 and 100", field)
+		}
+
+		for _, v := range variants {
+			if v.Name == parts[0] {
+				return nil, fmt.Errorf("duplicate image variant %q", parts[0])
+			}
+		}
+		variants = append(variants, Variant{Name: parts[0], MaxWidth: width, Quality: quality})
+	}
+
+	sort.Slice(variants, func(i, j int) bool {
+		return variants[i].MaxWidth < variants[j].MaxWidth
+	})
+	return variants, nil
+}
+
+// QualityFor returns the quality of the narrowest preset at least width wide, so that
+// images resized on the fly look like the presets around them.
+func QualityFor(variants []Variant, width int) int {
+	for _, v := range variants {
+		if v.MaxWidth >= width {
+			return v.Quality
+		}
+	}
+	if len(variants) > 0 {
+		return variants[len(variants)-1].Quality
+	}
+	return DefaultQuality
+}
+
+// OutputType returns the type resized copies of an image of the given type are
+// encoded as by default. Everything but JPEG may be transparent, so becomes PNG.
+func OutputType(mimeType string) string {
+	if mimeType == "image/jpeg" {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
+
+// Extension returns the file extension for an output type.
+func Extension(mimeType string) string {
+	if mimeType == "image/jpeg" {
+		return ".jpg"
+	}
+	return ".png"
+}
+
+// Decode decodes an image in any of the accepted formats. Animated GIFs give their
+// first frame. WebP images are turned the way their EXIF says they should be shown;
+// Clean has already turned other images.
+func Decode(r io.Reader) (image.Image, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	if format == "webp" {
+		if o := webpOrientation(b); o != 1 {
+			img = orient(img, o)
+		}
+	}
+	return img, nil
+}
+
+// Resize scales img down to width, keeping its aspect ratio. Images which are already
+// no wider than width are returned as they are.
+func Resize(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+	if width >= bounds.Dx() {
+		return img
+	}
+
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+// Fit scales img down so that neither side is longer than size, keeping its aspect
+// ratio, though neither side goes below a pixel. Images which already fit are returned
+// as they are.
+func Fit(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+
+	if w >= h {
+		w, h = size, h*size/w
+	} else {
+		w, h = w*size/h, size
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+// Encode encodes img as the given output type, using quality for JPEGs.
+func Encode(img image.Image, mimeType string, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+
+	switch mimeType {
+	case "image/jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	case "image/png":
+		encoder := png.Encoder{CompressionLevel: png.BestCompression}
+		err = encoder.Encode(&buf, img)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Srcset returns an HTML srcset value offering the image at url in each preset width
+// narrower than the image itself, and at its full width. Each preset is fetched by
+// asking for its width with the w query parameter.
+func Srcset(url string, width int, variants []Variant) string {
+	var candidates []string
+	for _, v := range variants {
+		if v.MaxWidth < width {
+			candidates = append(candidates, fmt.Sprintf("%s?w=%d %dw", url, v.MaxWidth, v.MaxWidth))
+		}
+	}
+	candidates = append(candidates, fmt.Sprintf("%s %dw", url, width))
+	return strings.Join(candidates, ", ")
+}
@@ -0,0 +1,96 @@
+package markdown
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+)
+
+// Cache holds recently rendered documents, evicting the least recently used once it
+// is full. Keys should change whenever the source does, e.g. by including a version
+// number, since entries are only dropped by Purge.
+type Cache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key string
+	doc *Document
+}
+
+// NewCache returns a Cache holding up to size documents. A size of zero or less
+// disables caching.
+func NewCache(size int) *Cache {
+	return &Cache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Render returns the cached document for key, rendering source and caching the result
+// if there isn't one.
+func (c *Cache) Render(key, source string) (*Document, error) {
+	if doc, ok := c.get(key); ok {
+		return doc, nil
+	}
+
+	doc, err := Render(source)
+	if err != nil {
+		return nil, err
+	}
+
+	c.put(key, doc)
+	return doc, nil
+}
+
+// Purge drops every document whose key starts with prefix, such as the renders of
+// old versions of a post.
+func (c *Cache) Purge(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, el := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			c.order.Remove(el)
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (c *Cache) get(key string) (*Document, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*cacheEntry).doc, true
+}
+
+func (c *Cache) put(key string, doc *Document) {
+	if c.size <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*cacheEntry).doc = doc
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, doc: doc})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
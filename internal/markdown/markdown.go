@@ -0,0 +1,108 @@
+// Package markdown renders post content written in Markdown to sanitized HTML, along
+// with a table of contents built from its headings.
+package markdown
+
+import (
+	"bytes"
+	"regexp"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+)
+
+// Heading is an entry in a document's table of contents. ID is the anchor of the
+// heading in the rendered HTML.
+type Heading struct {
+	Level int    `json:"level"`
+	Text  string `json:"text"`
+	ID    string `json:"id"`
+}
+
+// Document is the result of rendering Markdown.
+type Document struct {
+	HTML string    `json:"html"`
+	TOC  []Heading `json:"toc"`
+}
+
+// AnchorClass is the class of the self-link appended to every heading.
+const AnchorClass = "heading-anchor"
+
+var (
+	md = goldmark.New(
+		goldmark.WithExtensions(extension.GFM),
+		goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+		// Raw HTML is passed through here and cleaned up by the sanitizer afterwards,
+		// so authors can still use the harmless parts of it.
+		goldmark.WithRendererOptions(html.WithUnsafe()),
+	)
+
+	policy = newPolicy()
+)
+
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("id").Matching(regexp.MustCompile(`^[\w-]+$`)).OnElements("h1", "h2", "h3", "h4", "h5", "h6")
+	// Fenced code blocks are tagged with their language for client-side highlighters.
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#-]+$`)).OnElements("code")
+	p.AllowAttrs("class").Matching(regexp.MustCompile("^" + AnchorClass + "$")).OnElements("a")
+	return p
+}
+
+// Render converts Markdown source to sanitized HTML. Every heading gets an id and a
+// self-link with the AnchorClass class, and is listed in the table of contents.
+func Render(source string) (*Document, error) {
+	src := []byte(source)
+	root := md.Parser().Parse(text.NewReader(src))
+
+	doc := &Document{TOC: []Heading{}}
+
+	var headings []*ast.Heading
+	err := ast.Walk(root, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if heading, ok := n.(*ast.Heading); ok && entering {
+			headings = append(headings, heading)
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, heading := range headings {
+		id, ok := heading.AttributeString("id")
+		if !ok {
+			continue
+		}
+		idBytes, ok := id.([]byte)
+		if !ok {
+			continue
+		}
+
+		doc.TOC = append(doc.TOC, Heading{
+			Level: heading.Level,
+			Text:  string(heading.Text(src)),
+			ID:    string(idBytes),
+		})
+
+		anchor := ast.NewLink()
+		anchor.Destination = append([]byte("#"), idBytes...)
+		anchor.SetAttributeString("class", []byte(AnchorClass))
+		anchor.AppendChild(anchor, ast.NewString([]byte("#")))
+		heading.AppendChild(heading, ast.NewString([]byte(" ")))
+		heading.AppendChild(heading, anchor)
+	}
+
+	var buf bytes.Buffer
+	err = md.Renderer().Render(&buf, src, root)
+	if err != nil {
+		return nil, err
+	}
+
+	doc.HTML = policy.Sanitize(buf.String())
+	return doc, nil
+}
@@ -0,0 +1,228 @@
+package markdown
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRenderSanitizes(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "script element",
+			source:  "<script>alert(1)</script>\n\nhello",
+			want:    []string{"<p>hello</p>"},
+			notWant: []string{"<script", "alert(1)"},
+		},
+		{
+			name:    "inline script element",
+			source:  "hello <script>alert(1)</script> world",
+			want:    []string{"hello", "world"},
+			notWant: []string{"<script", "alert(1)"},
+		},
+		{
+			name:    "event handler",
+			source:  `<img src="x.png" onerror="alert(1)">`,
+			want:    []string{`<img src="x.png">`},
+			notWant: []string{"onerror", "alert(1)"},
+		},
+		{
+			name:    "event handler on link",
+			source:  `<a href="https://example.com" onclick="alert(1)">x</a>`,
+			want:    []string{`href="https://example.com"`},
+			notWant: []string{"onclick"},
+		},
+		{
+			name:    "javascript markdown link",
+			source:  "[x](javascript:alert(1))",
+			want:    []string{"<p>x</p>"},
+			notWant: []string{"javascript:", "href"},
+		},
+		{
+			name:    "javascript html link",
+			source:  `<a href="javascript:alert(1)">x</a>`,
+			notWant: []string{"javascript:", "href"},
+		},
+		{
+			name:    "javascript link with mixed case",
+			source:  "[x](JaVaScRiPt:alert(1))",
+			notWant: []string{"javascript:", "JaVaScRiPt:", "href"},
+		},
+		{
+			name:    "style attribute",
+			source:  `<p style="background:url(x)">x</p>`,
+			notWant: []string{"style"},
+		},
+		{
+			name:    "iframe",
+			source:  `<iframe src="https://example.com"></iframe>`,
+			notWant: []string{"<iframe"},
+		},
+		{
+			name:    "ordinary link",
+			source:  "[x](https://example.com)",
+			want:    []string{`<a href="https://example.com" rel="nofollow">x</a>`},
+			notWant: nil,
+		},
+		{
+			name:    "class on other elements",
+			source:  `<p class="language-go">x</p>`,
+			notWant: []string{"class"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := Render(tt.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, s := range tt.want {
+				if !strings.Contains(doc.HTML, s) {
+					t.Errorf("got %q; want it to contain %q", doc.HTML, s)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(doc.HTML, s) {
+					t.Errorf("got %q; want it not to contain %q", doc.HTML, s)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderCodeLanguage(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"go", "```go\nx := 1\n```", `<pre><code class="language-go">x := 1` + "\n</code></pre>"},
+		{"with plus", "```c++\nint x;\n```", `<code class="language-c++">`},
+		{"with hash", "```c#\nint x;\n```", `<code class="language-c#">`},
+		{"no language", "```\nx\n```", "<pre><code>x\n</code></pre>"},
+		{"attribute injection", "```\" onclick=\"alert(1)\nx\n```", "<pre><code>x\n</code></pre>"},
+		{"raw html class", `<code class="evil">x</code>`, "<code>x</code>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := Render(tt.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.Contains(doc.HTML, tt.want) {
+				t.Errorf("got %q; want it to contain %q", doc.HTML, tt.want)
+			}
+			if strings.Contains(doc.HTML, "onclick") {
+				t.Errorf("got %q; want no onclick attribute", doc.HTML)
+			}
+		})
+	}
+}
+
+func TestRenderHeadings(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		toc    []Heading
+	}{
+		{
+			name:   "no headings",
+			source: "just a paragraph",
+			toc:    []Heading{},
+		},
+		{
+			name:   "levels",
+			source: "# Title\n\n## Section\n\n### Detail",
+			toc: []Heading{
+				{Level: 1, Text: "Title", ID: "title"},
+				{Level: 2, Text: "Section", ID: "section"},
+				{Level: 3, Text: "Detail", ID: "detail"},
+			},
+		},
+		{
+			name:   "duplicates",
+			source: "## Setup\n\n## Setup\n\n## Setup",
+			toc: []Heading{
+				{Level: 2, Text: "Setup", ID: "setup"},
+				{Level: 2, Text: "Setup", ID: "setup-1"},
+				{Level: 2, Text: "Setup", ID: "setup-2"},
+			},
+		},
+		{
+			name:   "duplicate of a numbered id",
+			source: "# Hello\n\n# Hello\n\n# Hello-1",
+			toc: []Heading{
+				{Level: 1, Text: "Hello", ID: "hello"},
+				{Level: 1, Text: "Hello", ID: "hello-1"},
+				{Level: 1, Text: "Hello-1", ID: "hello-1-1"},
+			},
+		},
+		{
+			name:   "inline formatting",
+			source: "## Using *emphasis* and `code`",
+			toc: []Heading{
+				{Level: 2, Text: "Using emphasis and code", ID: "using-emphasis-and-code"},
+			},
+		},
+		{
+			name:   "setext",
+			source: "Title\n=====\n\nSection\n-------",
+			toc: []Heading{
+				{Level: 1, Text: "Title", ID: "title"},
+				{Level: 2, Text: "Section", ID: "section"},
+			},
+		},
+		{
+			name:   "empty heading",
+			source: "#",
+			toc: []Heading{
+				{Level: 1, Text: "", ID: "heading"},
+			},
+		},
+		{
+			name:   "heading markup in code block",
+			source: "```\n# not a heading\n```",
+			toc:    []Heading{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := Render(tt.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(doc.TOC, tt.toc) {
+				t.Errorf("got TOC %#v; want %#v", doc.TOC, tt.toc)
+			}
+
+			for _, h := range tt.toc {
+				if !strings.Contains(doc.HTML, ` id="`+h.ID+`"`) {
+					t.Errorf("got %q; want a heading with id %q", doc.HTML, h.ID)
+				}
+				anchor := `<a href="#` + h.ID + `" class="` + AnchorClass + `"`
+				if !strings.Contains(doc.HTML, anchor) {
+					t.Errorf("got %q; want it to contain %q", doc.HTML, anchor)
+				}
+			}
+
+			again, err := Render(tt.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again.HTML != doc.HTML || !reflect.DeepEqual(again.TOC, doc.TOC) {
+				t.Errorf("rendering twice gave different results:\n%q\n%q", doc.HTML, again.HTML)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package spam
+
+import (
+	"context"
+	"math"
+
+	"blog/internal/data"
+)
+
+// BayesModel holds what a Bayes classifier has been taught, such as data.SpamModel.
+type BayesModel interface {
+	Counts(tokens []string) (*data.SpamCounts, error)
+}
+
+// Bayes is a naive Bayes classifier of comments, which learns from the comments
+// moderators approve or mark as spam. It lets everything through until it has been
+// taught at least MinMessages of each, and catches comments it thinks are spam with
+// at least Threshold probability.
+type Bayes struct {
+	Model       BayesModel
+	Threshold   float64
+	MinMessages int
+}
+
+func (b Bayes) Check(ctx context.Context, s *Submission) (Verdict, error) {
+	if s.Kind != KindComment {
+		return Verdict{}, nil
+	}
+
+	tokens := Tokens(s.Content)
+	if len(tokens) == 0 {
+		return Verdict{}, nil
+	}
+
+	counts, err := b.Model.Counts(tokens)
+	if err != nil {
+		return Verdict{}, err
+	}
+	if counts.SpamMessages < b.MinMessages || counts.HamMessages < b.MinMessages {
+		return Verdict{}, nil
+	}
+
+	p := spamProbability(counts, tokens)
+	if p >= b.Threshold {
+		return spam("classified as spam with probability %.3f", p), nil
+	}
+	return Verdict{}, nil
+}
+
+// spamProbability works out the probability that a comment made up of the tokens is
+// spam. Each token is taken to appear in comments of each class independently, with
+// add-one smoothing so that tokens only seen in one class don't decide the matter on
+// their own. Tokens never seen tell nothing, and are skipped. The class sizes are
+// smoothed the same way, so the answer is 0.5 before any training and stays short of
+// certain while only one class has been taught.
+func spamProbability(counts *data.SpamCounts, tokens []string) float64 {
+	spamMessages := float64(counts.SpamMessages)
+	hamMessages := float64(counts.HamMessages)
+
+	logOdds := math.Log((spamMessages + 1) / (hamMessages + 1))
+	for _, token := range tokens {
+		count, ok := counts.Tokens[token]
+		if !ok || count.Spam+count.Ham == 0 {
+			continue
+		}
+		pSpam := (float64(count.Spam) + 1) / (spamMessages + 2)
+		pHam := (float64(count.Ham) + 1) / (hamMessages + 2)
+		logOdds += math.Log(pSpam / pHam)
+	}
+
+	return 1 / (1 + math.Exp(-logOdds))
+}
@@ -0,0 +1,212 @@
+package spam
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"blog/internal/data"
+)
+
+// fakeModel is a BayesModel holding its counts in memory.
+type fakeModel struct {
+	counts data.SpamCounts
+	err    error
+}
+
+func (m fakeModel) Counts(tokens []string) (*data.SpamCounts, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	counts := &data.SpamCounts{
+		SpamMessages: m.counts.SpamMessages,
+		HamMessages:  m.counts.HamMessages,
+		Tokens:       make(map[string]data.SpamTokenCount),
+	}
+	for _, token := range tokens {
+		if count, ok := m.counts.Tokens[token]; ok {
+			counts.Tokens[token] = count
+		}
+	}
+	return counts, nil
+}
+
+func TestSpamProbability(t *testing.T) {
+	tests := []struct {
+		name   string
+		counts data.SpamCounts
+		tokens []string
+		want   float64
+	}{
+		{
+			name:   "no training",
+			counts: data.SpamCounts{},
+			tokens: []string{"hello"},
+			want:   0.5,
+		},
+		{
+			// Odds of (3+1)/(1+1) from the classes alone.
+			name:   "no tokens",
+			counts: data.SpamCounts{SpamMessages: 3, HamMessages: 1},
+			want:   2.0 / 3,
+		},
+		{
+			name:   "unseen tokens",
+			counts: data.SpamCounts{SpamMessages: 1, HamMessages: 1, Tokens: map[string]data.SpamTokenCount{}},
+			tokens: []string{"hello", "world"},
+			want:   0.5,
+		},
+		{
+			name: "token with zero counts",
+			counts: data.SpamCounts{SpamMessages: 1, HamMessages: 1, Tokens: map[string]data.SpamTokenCount{
+				"hello": {},
+			}},
+			tokens: []string{"hello"},
+			want:   0.5,
+		},
+		{
+			// Odds of 1 from the classes, times (2/4)/(1/4) = 2 from the token.
+			name: "spammy token",
+			counts: data.SpamCounts{SpamMessages: 2, HamMessages: 2, Tokens: map[string]data.SpamTokenCount{
+				"viagra": {Spam: 1},
+			}},
+			tokens: []string{"viagra"},
+			want:   2.0 / 3,
+		},
+		{
+			// Odds of 1 from the classes, times (1/4)/(3/4) = 1/3 from the token.
+			name: "hammy token",
+			counts: data.SpamCounts{SpamMessages: 2, HamMessages: 2, Tokens: map[string]data.SpamTokenCount{
+				"golang": {Ham: 2},
+			}},
+			tokens: []string{"golang"},
+			want:   0.25,
+		},
+		{
+			// Odds of 2 from the token each, 4 together.
+			name: "tokens combine",
+			counts: data.SpamCounts{SpamMessages: 2, HamMessages: 2, Tokens: map[string]data.SpamTokenCount{
+				"cheap":  {Spam: 1},
+				"viagra": {Spam: 1},
+			}},
+			tokens: []string{"cheap", "viagra"},
+			want:   0.8,
+		},
+		{
+			// Odds of (9+1)/(0+1) from the classes, times (10/11)/(1/2) = 20/11 from
+			// the token.
+			name: "spam only",
+			counts: data.SpamCounts{SpamMessages: 9, Tokens: map[string]data.SpamTokenCount{
+				"viagra": {Spam: 9},
+			}},
+			tokens: []string{"viagra"},
+			want:   200.0 / 211,
+		},
+		{
+			name: "ham only",
+			counts: data.SpamCounts{HamMessages: 9, Tokens: map[string]data.SpamTokenCount{
+				"golang": {Ham: 9},
+			}},
+			tokens: []string{"golang"},
+			want:   11.0 / 211,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := spamProbability(&tt.counts, tt.tokens)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBayes(t *testing.T) {
+	trained := data.SpamCounts{
+		SpamMessages: 10,
+		HamMessages:  10,
+		Tokens: map[string]data.SpamTokenCount{
+			"viagra": {Spam: 10},
+			"cheap":  {Spam: 8, Ham: 1},
+			"golang": {Ham: 10},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		model  fakeModel
+		s      Submission
+		spam   bool
+		hasErr bool
+	}{
+		{
+			name:  "spam",
+			model: fakeModel{counts: trained},
+			s:     Submission{Kind: KindComment, Content: "Cheap viagra!"},
+			spam:  true,
+		},
+		{
+			name:  "ham",
+			model: fakeModel{counts: trained},
+			s:     Submission{Kind: KindComment, Content: "I like golang"},
+		},
+		{
+			name:  "unknown words",
+			model: fakeModel{counts: trained},
+			s:     Submission{Kind: KindComment, Content: "hello there"},
+		},
+		{
+			name:  "no words",
+			model: fakeModel{err: errors.New("not asked")},
+			s:     Submission{Kind: KindComment, Content: "!!! ?"},
+		},
+		{
+			name:  "registration",
+			model: fakeModel{err: errors.New("not asked")},
+			s:     Submission{Kind: KindRegistration, Name: "cheap viagra"},
+		},
+		{
+			name:  "no training",
+			model: fakeModel{},
+			s:     Submission{Kind: KindComment, Content: "Cheap viagra!"},
+		},
+		{
+			name: "too little ham",
+			model: fakeModel{counts: data.SpamCounts{
+				SpamMessages: 10,
+				HamMessages:  9,
+				Tokens:       trained.Tokens,
+			}},
+			s: Submission{Kind: KindComment, Content: "Cheap viagra!"},
+		},
+		{
+			name: "spam only",
+			model: fakeModel{counts: data.SpamCounts{
+				SpamMessages: 50,
+				Tokens:       trained.Tokens,
+			}},
+			s: Submission{Kind: KindComment, Content: "Cheap viagra!"},
+		},
+		{
+			name:   "model error",
+			model:  fakeModel{err: errors.New("boom")},
+			s:      Submission{Kind: KindComment, Content: "hello"},
+			hasErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := Bayes{Model: tt.model, Threshold: 0.9, MinMessages: 10}
+			verdict, err := b.Check(context.Background(), &tt.s)
+			if (err != nil) != tt.hasErr {
+				t.Fatalf("got error %v; want error %v", err, tt.hasErr)
+			}
+			if verdict.Spam != tt.spam {
+				t.Errorf("got spam %v (%s); want %v", verdict.Spam, verdict.Reason, tt.spam)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package spam
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Honeypot catches bots which fill in every field of a form, including one hidden
+// from people.
+type Honeypot struct{}
+
+func (Honeypot) Check(ctx context.Context, s *Submission) (Verdict, error) {
+	if s.Honeypot != "" {
+		return spam("honeypot field filled in"), nil
+	}
+	return Verdict{}, nil
+}
+
+// MinTime catches bots which submit a form faster than a person could fill it in.
+// Clients fetch a signed form token when they show the form, made by NewFormToken,
+// and send it back with the submission. Tokens older than MaxAge are refused too, and
+// each token is only accepted once if Used is set, so that one can't be saved up and
+// reused.
+type MinTime struct {
+	Key    []byte
+	MinAge time.Duration
+	MaxAge time.Duration
+	Used   FormTokenStore
+}
+
+// FormTokenStore remembers the form tokens which have been used, such as
+// data.SpamModel. UseFormToken records a token's signature until the token expires,
+// and reports false if it had been recorded already.
+type FormTokenStore interface {
+	UseFormToken(signature string, expires time.Time) (bool, error)
+}
+
+// NewFormToken returns a form token for MinTime issued now. A random nonce tells
+// apart the tokens issued in the same second.
+func (m MinTime) NewFormToken() (string, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	payload := strconv.FormatInt(time.Now().Unix(), 10) + "." + hex.EncodeToString(b)
+	return payload + "." + m.sign(payload), nil
+}
+
+func (m MinTime) sign(payload string) string {
+	mac := hmac.New(sha256.New, m.Key)
+	mac.Write([]byte("form-token:" + payload))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func (m MinTime) Check(ctx context.Context, s *Submission) (Verdict, error) {
+	i := strings.LastIndexByte(s.FormToken, '.')
+	if i < 0 {
+		return spam("missing or invalid form token"), nil
+	}
+	payload, signature := s.FormToken[:i], s.FormToken[i+1:]
+	if !hmac.Equal([]byte(signature), []byte(m.sign(payload))) {
+		return spam("missing or invalid form token"), nil
+	}
+
+	issued, _, _ := strings.Cut(payload, ".")
+	unix, err := strconv.ParseInt(issued, 10, 64)
+	if err != nil {
+		return spam("missing or invalid form token"), nil
+	}
+
+	age := time.Since(time.Unix(unix, 0))
+	switch {
+	case age < m.MinAge:
+		return spam("form submitted after %s", age.Round(time.Millisecond)), nil
+	case age > m.MaxAge:
+		return spam("form token expired"), nil
+	}
+
+	if m.Used != nil {
+		ok, err := m.Used.UseFormToken(signature, time.Unix(unix, 0).Add(m.MaxAge))
+		if err != nil {
+			return Verdict{}, err
+		}
+		if !ok {
+			return spam("form token already used"), nil
+		}
+	}
+	return Verdict{}, nil
+}
+
+// Links catches comments with more than MaxLinks links in them, and names with any.
+type Links struct {
+	MaxLinks int
+}
+
+func (l Links) Check(ctx context.Context, s *Submission) (Verdict, error) {
+	if linkRX.MatchString(s.Name) {
+		return spam("link in name"), nil
+	}
+	if n := len(linkRX.FindAllString(s.Content, -1)); n > l.MaxLinks {
+		return spam("too many links (%d)", n), nil
+	}
+	return Verdict{}, nil
+}
+
+// Blocklist catches submissions mentioning any of a list of words, phrases or domains,
+// or sent from a listed IP address. Terms are matched case-insensitively anywhere in
+// the name, email address or content.
+type Blocklist struct {
+	terms []string
+}
+
+// ReadBlocklist reads a blocklist with a term on each line. Blank lines and lines
+// starting with # are skipped.
+func ReadBlocklist(r io.Reader) (*Blocklist, error) {
+	b := &Blocklist{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		term := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if term == "" || strings.HasPrefix(term, "#") {
+			continue
+		}
+		b.terms = append(b.terms, term)
+	}
+	return b, scanner.Err()
+}
+
+func (b *Blocklist) Check(ctx context.Context, s *Submission) (Verdict, error) {
+	text := strings.ToLower(s.Name + "\n" + s.Email + "\n" + s.Content)
+	for _, term := range b.terms {
+		if term == s.IP {
+			return spam("blocked IP address %s", s.IP), nil
+		}
+		if strings.Contains(text, term) {
+			return spam("blocked term %q", term), nil
+		}
+	}
+	return Verdict{}, nil
+}
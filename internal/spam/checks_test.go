@@ -0,0 +1,228 @@
+package spam
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHoneypot(t *testing.T) {
+	tests := []struct {
+		name     string
+		honeypot string
+		spam     bool
+	}{
+		{"empty", "", false},
+		{"filled in", "http://example.com", true},
+		{"whitespace", " ", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verdict, err := Honeypot{}.Check(context.Background(), &Submission{Honeypot: tt.honeypot})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if verdict.Spam != tt.spam {
+				t.Errorf("got spam %v; want %v", verdict.Spam, tt.spam)
+			}
+		})
+	}
+}
+
+func TestMinTime(t *testing.T) {
+	m := MinTime{Key: []byte("secret"), MinAge: 3 * time.Second, MaxAge: time.Hour}
+
+	// issuedAgo returns a correctly signed token issued the given time ago.
+	issuedAgo := func(d time.Duration) string {
+		payload := strconv.FormatInt(time.Now().Add(-d).Unix(), 10) + ".0123456789abcdef"
+		return payload + "." + m.sign(payload)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		spam   bool
+		reason string
+	}{
+		{"old enough", issuedAgo(10 * time.Second), false, ""},
+		{"nearly expired", issuedAgo(time.Hour - time.Minute), false, ""},
+		{"fresh", newFormToken(t, m), true, "form submitted after"},
+		{"too fast", issuedAgo(time.Second), true, "form submitted after"},
+		{"from the future", issuedAgo(-time.Minute), true, "form submitted after"},
+		{"expired", issuedAgo(time.Hour + time.Minute), true, "form token expired"},
+		{"missing", "", true, "missing or invalid"},
+		{"no signature", strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10), true, "missing or invalid"},
+		{"bad signature", strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10) + ".0123456789abcdef.0123456789abcdef0123456789abcdef", true, "missing or invalid"},
+		{"other key", newFormToken(t, MinTime{Key: []byte("other")}), true, "missing or invalid"},
+		{"not a number", "abc." + m.sign("abc"), true, "missing or invalid"},
+		{"tampered time", tamper(issuedAgo(10 * time.Second)), true, "missing or invalid"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verdict, err := m.Check(context.Background(), &Submission{FormToken: tt.token})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if verdict.Spam != tt.spam {
+				t.Errorf("got spam %v (%s); want %v", verdict.Spam, verdict.Reason, tt.spam)
+			}
+			if !strings.Contains(verdict.Reason, tt.reason) {
+				t.Errorf("got reason %q; want it to contain %q", verdict.Reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestMinTimeUsedOnce(t *testing.T) {
+	m := MinTime{Key: []byte("secret"), MaxAge: time.Hour, Used: fakeFormTokenStore{}}
+	first, second := newFormToken(t, m), newFormToken(t, m)
+
+	for _, tt := range []struct {
+		name   string
+		token  string
+		spam   bool
+		reason string
+	}{
+		{"first use", first, false, ""},
+		{"token issued in the same second", second, false, ""},
+		{"second use", first, true, "already used"},
+	} {
+		verdict, err := m.Check(context.Background(), &Submission{FormToken: tt.token})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if verdict.Spam != tt.spam || !strings.Contains(verdict.Reason, tt.reason) {
+			t.Errorf("%s: got spam %v (%s); want %v (%s)", tt.name, verdict.Spam, verdict.Reason, tt.spam, tt.reason)
+		}
+	}
+}
+
+// fakeFormTokenStore is a FormTokenStore holding the used signatures in memory.
+type fakeFormTokenStore map[string]time.Time
+
+func (f fakeFormTokenStore) UseFormToken(signature string, expires time.Time) (bool, error) {
+	if _, ok := f[signature]; ok {
+		return false, nil
+	}
+	f[signature] = expires
+	return true, nil
+}
+
+func newFormToken(t *testing.T, m MinTime) string {
+	t.Helper()
+
+	token, err := m.NewFormToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// tamper moves the issue time of a token back by a minute, keeping its signature.
+func tamper(token string) string {
+	issued, rest, _ := strings.Cut(token, ".")
+	unix, _ := strconv.ParseInt(issued, 10, 64)
+	return strconv.FormatInt(unix-60, 10) + "." + rest
+}
+
+func TestLinks(t *testing.T) {
+	tests := []struct {
+		name   string
+		s      Submission
+		spam   bool
+		reason string
+	}{
+		{"no links", Submission{Name: "Ann", Content: "Nice post."}, false, ""},
+		{"at the limit", Submission{Name: "Ann", Content: "See https://a.example and www.b.example"}, false, ""},
+		{"over the limit", Submission{Name: "Ann", Content: "http://a.example http://b.example HTTPS://c.example"}, true, "too many links (3)"},
+		{"bare domains", Submission{Name: "Ann", Content: "a.example b.example c.example"}, false, ""},
+		{"link in name", Submission{Name: "www.cheap.example", Content: "Nice post."}, true, "link in name"},
+		{"url in name", Submission{Name: "http://cheap.example", Content: ""}, true, "link in name"},
+	}
+
+	l := Links{MaxLinks: 2}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verdict, err := l.Check(context.Background(), &tt.s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if verdict.Spam != tt.spam {
+				t.Errorf("got spam %v (%s); want %v", verdict.Spam, verdict.Reason, tt.spam)
+			}
+			if verdict.Reason != tt.reason {
+				t.Errorf("got reason %q; want %q", verdict.Reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestBlocklist(t *testing.T) {
+	b, err := ReadBlocklist(strings.NewReader(`
+# Words
+Viagra
+  casino  
+
+# Domains
+spam.example
+# 192.0.2.1 is commented out
+203.0.113.7
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"viagra", "casino", "spam.example", "203.0.113.7"}; strings.Join(b.terms, ",") != strings.Join(want, ",") {
+		t.Fatalf("got terms %q; want %q", b.terms, want)
+	}
+
+	tests := []struct {
+		name   string
+		s      Submission
+		spam   bool
+		reason string
+	}{
+		{"clean", Submission{Name: "Ann", Email: "ann@example.com", Content: "Nice post.", IP: "198.51.100.1"}, false, ""},
+		{"term in content", Submission{Name: "Ann", Content: "Buy VIAGRA now", IP: "198.51.100.1"}, true, `blocked term "viagra"`},
+		{"term inside a word", Submission{Name: "Ann", Content: "online casinos", IP: "198.51.100.1"}, true, `blocked term "casino"`},
+		{"term in name", Submission{Name: "Casino King", Content: "hi", IP: "198.51.100.1"}, true, `blocked term "casino"`},
+		{"domain in email", Submission{Name: "Ann", Email: "ann@Spam.Example", IP: "198.51.100.1"}, true, `blocked term "spam.example"`},
+		{"blocked ip", Submission{Name: "Ann", Content: "hi", IP: "203.0.113.7"}, true, "blocked IP address 203.0.113.7"},
+		{"commented out ip", Submission{Name: "Ann", Content: "hi", IP: "192.0.2.1"}, false, ""},
+		{"registration", Submission{Kind: KindRegistration, Name: "Ann", Email: "ann@spam.example"}, true, `blocked term "spam.example"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verdict, err := b.Check(context.Background(), &tt.s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if verdict.Spam != tt.spam {
+				t.Errorf("got spam %v (%s); want %v", verdict.Spam, verdict.Reason, tt.spam)
+			}
+			if verdict.Reason != tt.reason {
+				t.Errorf("got reason %q; want %q", verdict.Reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestEmptyBlocklist(t *testing.T) {
+	b, err := ReadBlocklist(strings.NewReader("# nothing yet\n\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verdict, err := b.Check(context.Background(), &Submission{Name: "Ann", Content: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verdict.Spam {
+		t.Errorf("got spam (%s); want none", verdict.Reason)
+	}
+}
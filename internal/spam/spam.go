@@ -0,0 +1,102 @@
+// Package spam decides whether comments and registrations are spam, with checks that
+// can be combined: a honeypot field, a minimum time to fill in the form, link and
+// blocklist heuristics, and a naive Bayes classifier trained by moderators.
+package spam
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Kinds of submission.
+const (
+	KindComment      = "comment"
+	KindRegistration = "registration"
+)
+
+// Submission is something a client sent which might be spam.
+type Submission struct {
+	Kind string
+	// Name, Email and Content are what the client wrote. Registrations have no
+	// content.
+	Name    string
+	Email   string
+	Content string
+	IP      string
+	// Honeypot is the value of a form field hidden from people, which only bots fill
+	// in, and FormToken the token the client fetched when it showed the form.
+	Honeypot  string
+	FormToken string
+}
+
+// Verdict is what a SpamChecker made of a submission. Reason says which check caught
+// it, for logs and moderators; clients aren't told.
+type Verdict struct {
+	Spam   bool
+	Reason string
+}
+
+// SpamChecker decides whether a submission is spam. Checks which don't apply to a
+// kind of submission let it through.
+type SpamChecker interface {
+	Check(ctx context.Context, s *Submission) (Verdict, error)
+}
+
+// Chain runs each of its checks in turn, returning the first which finds spam.
+type Chain []SpamChecker
+
+func (c Chain) Check(ctx context.Context, s *Submission) (Verdict, error) {
+	for _, checker := range c {
+		verdict, err := checker.Check(ctx, s)
+		if err != nil || verdict.Spam {
+			return verdict, err
+		}
+	}
+	return Verdict{}, nil
+}
+
+func spam(format string, args ...any) Verdict {
+	return Verdict{Spam: true, Reason: fmt.Sprintf(format, args...)}
+}
+
+var linkRX = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"']+|\bwww\.[^\s<>"']+`)
+
+// Tokens splits text into the words the Bayes classifier learns from: lowercased,
+// each given once, with the host names of any links as "host:" tokens.
+func Tokens(text string) []string {
+	seen := make(map[string]bool)
+	var tokens []string
+	add := func(token string) {
+		if !seen[token] && len(tokens) < maxTokens {
+			seen[token] = true
+			tokens = append(tokens, token)
+		}
+	}
+
+	for _, link := range linkRX.FindAllString(text, -1) {
+		host := strings.ToLower(link)
+		host = strings.TrimPrefix(strings.TrimPrefix(host, "http://"), "https://")
+		if i := strings.IndexAny(host, "/?#:"); i >= 0 {
+			host = host[:i]
+		}
+		add("host:" + strings.TrimRight(host, ".,;!?)]}"))
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\'' && r != '$'
+	})
+	for _, word := range words {
+		word = strings.Trim(word, "'")
+		if n := len([]rune(word)); n >= 2 && n <= 30 {
+			add(word)
+		}
+	}
+
+	return tokens
+}
+
+// maxTokens is the most tokens taken from a single submission.
+const maxTokens = 500
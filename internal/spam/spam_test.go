@@ -0,0 +1,99 @@
+package spam
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"empty", "", nil},
+		{"punctuation", "!!! ... ?", nil},
+		{"words", "Hello, World!", []string{"hello", "world"}},
+		{"repeated", "buy buy BUY now", []string{"buy", "now"}},
+		{"too short", "a b cd", []string{"cd"}},
+		{"too long", strings.Repeat("x", 31) + " " + strings.Repeat("y", 30), []string{strings.Repeat("y", 30)}},
+		{"apostrophes", "don't 'quoted'", []string{"don't", "quoted"}},
+		{"money", "win $1000 now", []string{"win", "$1000", "now"}},
+		{"unicode", "Ünïcödé wörds", []string{"ünïcödé", "wörds"}},
+		{
+			"links",
+			"see https://Cheap.Example/buy?x=1 and www.spam.example.",
+			[]string{"host:cheap.example", "host:www.spam.example", "see", "https", "cheap", "example", "buy", "and", "www", "spam"},
+		},
+		{"link with port", "http://a.example:8080/x", []string{"host:a.example", "http", "example", "8080"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Tokens(tt.text)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokensLimit(t *testing.T) {
+	var words []string
+	for i := 0; i < maxTokens+100; i++ {
+		words = append(words, "w"+strings.Repeat("x", i%20)+string(rune('a'+i%26))+string(rune('a'+i/26%26)))
+	}
+
+	if got := len(Tokens(strings.Join(words, " "))); got != maxTokens {
+		t.Errorf("got %d tokens; want %d", got, maxTokens)
+	}
+}
+
+// checkFunc is a SpamChecker made from a function.
+type checkFunc func(s *Submission) (Verdict, error)
+
+func (f checkFunc) Check(ctx context.Context, s *Submission) (Verdict, error) {
+	return f(s)
+}
+
+func TestChain(t *testing.T) {
+	var ran []string
+	check := func(name string, verdict Verdict, err error) SpamChecker {
+		return checkFunc(func(s *Submission) (Verdict, error) {
+			ran = append(ran, name)
+			return verdict, err
+		})
+	}
+
+	tests := []struct {
+		name    string
+		chain   Chain
+		ran     []string
+		reason  string
+		wantErr bool
+	}{
+		{"empty", Chain{}, nil, "", false},
+		{"all pass", Chain{check("a", Verdict{}, nil), check("b", Verdict{}, nil)}, []string{"a", "b"}, "", false},
+		{"first spam wins", Chain{check("a", Verdict{}, nil), check("b", spam("b"), nil), check("c", spam("c"), nil)}, []string{"a", "b"}, "b", false},
+		{"error stops", Chain{check("a", Verdict{}, errors.New("boom")), check("b", spam("b"), nil)}, []string{"a"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ran = nil
+			verdict, err := tt.chain.Check(context.Background(), &Submission{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v; want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(ran, tt.ran) {
+				t.Errorf("ran %q; want %q", ran, tt.ran)
+			}
+			if verdict.Reason != tt.reason || verdict.Spam != (tt.reason != "") {
+				t.Errorf("got verdict %+v; want reason %q", verdict, tt.reason)
+			}
+		})
+	}
+}
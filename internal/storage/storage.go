@@ -0,0 +1,46 @@
+// Package storage keeps uploaded files, either on the local filesystem or in an
+// S3-compatible object store so that several API replicas can share them.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	ErrNotFound     = errors.New("storage: object not found")
+	ErrInvalidKey   = errors.New("storage: invalid key")
+	ErrNotSupported = errors.New("storage: operation not supported by backend")
+)
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// Storage is a store of files by key. Keys are slash separated relative paths such as
+// "images/1_1700000000.jpg".
+type Storage interface {
+	// Put stores size bytes read from r under key, replacing anything already there.
+	// Readers of the key see either the old or the new content, never a partial write.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens the object stored under key. The caller must close it. Backends whose
+	// objects can be seeked return an io.ReadSeekCloser.
+	Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error)
+	// Delete removes the object stored under key. Deleting a missing object is not an
+	// error.
+	Delete(ctx context.Context, key string) error
+	// Stat returns information about the object stored under key.
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// List calls fn for each object whose key starts with prefix, stopping at the
+	// first error fn returns.
+	List(ctx context.Context, prefix string, fn func(*ObjectInfo) error) error
+	// SignedURL returns a URL from which the object can be fetched directly until the
+	// expiry has passed, or ErrNotSupported if the backend can't hand out URLs.
+	SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error)
+}
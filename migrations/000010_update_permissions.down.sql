@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS permissions_code_idx;
+
+UPDATE permissions SET code = 'movies:read' WHERE code = 'posts:read';
+UPDATE permissions SET code = 'movies:write' WHERE code = 'posts:write';
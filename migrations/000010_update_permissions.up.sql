@@ -0,0 +1,9 @@
+-- Replace the movie permission codes left over from 000006 with post codes.
+UPDATE permissions SET code = 'posts:read' WHERE code = 'movies:read';
+UPDATE permissions SET code = 'posts:write' WHERE code = 'movies:write';
+
+INSERT INTO permissions (code)
+SELECT code FROM (VALUES ('posts:read'), ('posts:write')) AS p(code)
+WHERE NOT EXISTS (SELECT 1 FROM permissions WHERE permissions.code = p.code);
+
+CREATE UNIQUE INDEX IF NOT EXISTS permissions_code_idx ON permissions (code);
@@ -0,0 +1,8 @@
+DELETE FROM permissions WHERE code = 'posts:manage_all';
+
+DROP INDEX IF EXISTS posts_author_id_idx;
+ALTER TABLE posts DROP COLUMN IF EXISTS author_id;
+
+DROP INDEX IF EXISTS users_slug_key;
+ALTER TABLE users DROP COLUMN IF EXISTS avatar_image;
+ALTER TABLE users DROP COLUMN IF EXISTS slug;
@@ -0,0 +1,15 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS slug text;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_image text;
+
+-- Give existing users a unique slug derived from their name.
+UPDATE users
+SET slug = trim(BOTH '-' FROM lower(regexp_replace(name, '[^a-zA-Z0-9]+', '-', 'g'))) || '-' || id
+WHERE slug IS NULL;
+
+ALTER TABLE users ALTER COLUMN slug SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS users_slug_key ON users (slug);
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS author_id bigint REFERENCES users(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS posts_author_id_idx ON posts (author_id);
+
+INSERT INTO permissions (code) VALUES ('posts:manage_all') ON CONFLICT (code) DO NOTHING;
@@ -0,0 +1,10 @@
+DELETE FROM permissions WHERE code = 'posts:publish';
+
+DROP INDEX IF EXISTS posts_status_published_at_idx;
+
+UPDATE posts SET published_at = created_at WHERE published_at IS NULL;
+ALTER TABLE posts ALTER COLUMN published_at SET DEFAULT NOW();
+ALTER TABLE posts ALTER COLUMN published_at SET NOT NULL;
+
+ALTER TABLE posts DROP CONSTRAINT IF EXISTS posts_status_check;
+ALTER TABLE posts DROP COLUMN IF EXISTS status;
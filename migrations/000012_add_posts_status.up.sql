@@ -0,0 +1,16 @@
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'draft';
+ALTER TABLE posts ADD CONSTRAINT posts_status_check
+    CHECK (status IN ('draft', 'in_review', 'published', 'archived'));
+
+-- Existing posts were public once their published_at passed. Those it has passed for
+-- stay published; the rest aren't out yet, so they become drafts which keep their date.
+UPDATE posts SET status = 'published' WHERE published_at <= NOW();
+UPDATE posts SET status = 'draft' WHERE published_at > NOW();
+
+-- Drafts have no publication date until they are published.
+ALTER TABLE posts ALTER COLUMN published_at DROP NOT NULL;
+ALTER TABLE posts ALTER COLUMN published_at DROP DEFAULT;
+
+CREATE INDEX IF NOT EXISTS posts_status_published_at_idx ON posts (status, published_at);
+
+INSERT INTO permissions (code) VALUES ('posts:publish') ON CONFLICT (code) DO NOTHING;
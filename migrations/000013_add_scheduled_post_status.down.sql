@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS posts_scheduled_idx;
+
+UPDATE posts SET status = 'published' WHERE status = 'scheduled';
+
+ALTER TABLE posts DROP CONSTRAINT IF EXISTS posts_status_check;
+ALTER TABLE posts ADD CONSTRAINT posts_status_check
+    CHECK (status IN ('draft', 'in_review', 'published', 'archived'));
@@ -0,0 +1,12 @@
+ALTER TABLE posts DROP CONSTRAINT IF EXISTS posts_status_check;
+ALTER TABLE posts ADD CONSTRAINT posts_status_check
+    CHECK (status IN ('draft', 'in_review', 'scheduled', 'published', 'archived'));
+
+-- Posts dated in the future were waiting for their date to come round: those published
+-- ahead of it, and those the previous migration held back as drafts. They go out when
+-- it does now that the scheduler publishes them.
+UPDATE posts SET status = 'scheduled'
+WHERE status IN ('published', 'draft') AND published_at > NOW();
+
+-- Lets the scheduler find due posts without scanning the whole table.
+CREATE INDEX IF NOT EXISTS posts_scheduled_idx ON posts (published_at) WHERE status = 'scheduled';
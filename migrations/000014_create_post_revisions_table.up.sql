@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS post_revisions (
+    id bigserial PRIMARY KEY,
+    post_id bigint NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    version integer NOT NULL,
+    title text NOT NULL,
+    slug text NOT NULL,
+    content text NOT NULL,
+    excerpt text NOT NULL,
+    editor_id bigint REFERENCES users(id) ON DELETE SET NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (post_id, version)
+);
+
+-- Seed the history with the current version of every existing post.
+INSERT INTO post_revisions (post_id, version, title, slug, content, excerpt, editor_id, created_at)
+SELECT id, version, title, slug, content, excerpt, author_id, updated_at FROM posts
+ON CONFLICT (post_id, version) DO NOTHING;
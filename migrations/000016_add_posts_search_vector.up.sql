@@ -0,0 +1,9 @@
+-- Keep a weighted search vector for every post: matches in the title count the most,
+-- then the excerpt, then the content.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('english', title), 'A') ||
+    setweight(to_tsvector('english', excerpt), 'B') ||
+    setweight(to_tsvector('english', content), 'C')
+) STORED;
+
+CREATE INDEX IF NOT EXISTS posts_search_vector_idx ON posts USING GIN (search_vector);
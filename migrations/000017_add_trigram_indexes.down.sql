@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS users_name_trgm_idx;
+DROP INDEX IF EXISTS tags_name_trgm_idx;
+DROP INDEX IF EXISTS posts_title_trgm_idx;
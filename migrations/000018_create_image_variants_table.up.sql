@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS image_variants (
+    id bigserial PRIMARY KEY,
+    image_id bigint NOT NULL REFERENCES images(id) ON DELETE CASCADE,
+    name varchar(50) NOT NULL,
+    filename varchar(255) NOT NULL UNIQUE,
+    file_path varchar(500) NOT NULL,
+    file_size bigint NOT NULL,
+    mime_type varchar(100) NOT NULL,
+    width integer NOT NULL,
+    height integer NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (image_id, name)
+);
+
+-- Serving a resized image looks for the narrowest variant of the right type and width.
+CREATE INDEX IF NOT EXISTS image_variants_lookup_idx ON image_variants(image_id, mime_type, width);
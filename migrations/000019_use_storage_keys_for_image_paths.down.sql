@@ -0,0 +1,5 @@
+UPDATE images SET file_path = 'uploads/' || file_path
+WHERE file_path NOT LIKE 'uploads/%';
+
+UPDATE image_variants SET file_path = 'uploads/' || file_path
+WHERE file_path NOT LIKE 'uploads/%';
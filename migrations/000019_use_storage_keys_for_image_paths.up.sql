@@ -0,0 +1,7 @@
+-- Image paths become keys relative to the storage backend's root, which for local
+-- storage is the uploads directory they used to be given relative to.
+UPDATE images SET file_path = substr(file_path, length('uploads/') + 1)
+WHERE file_path LIKE 'uploads/%';
+
+UPDATE image_variants SET file_path = substr(file_path, length('uploads/') + 1)
+WHERE file_path LIKE 'uploads/%';
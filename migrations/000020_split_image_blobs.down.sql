@@ -0,0 +1,36 @@
+DROP TRIGGER IF EXISTS images_count_blob_refs ON images;
+DROP FUNCTION IF EXISTS count_image_blob_refs();
+
+ALTER TABLE images
+    ADD COLUMN file_path varchar(500),
+    ADD COLUMN file_size bigint,
+    ADD COLUMN mime_type varchar(100),
+    ADD COLUMN width integer,
+    ADD COLUMN height integer;
+
+UPDATE images i
+SET file_path = b.file_path, file_size = b.file_size, mime_type = b.mime_type,
+    width = b.width, height = b.height
+FROM image_blobs b WHERE b.id = i.blob_id;
+
+ALTER TABLE images
+    ALTER COLUMN file_path SET NOT NULL,
+    ALTER COLUMN file_size SET NOT NULL,
+    ALTER COLUMN mime_type SET NOT NULL;
+
+-- Variants of blobs shared by several images go with the first of them.
+ALTER TABLE image_variants ADD COLUMN image_id bigint REFERENCES images(id) ON DELETE CASCADE;
+UPDATE image_variants v SET image_id = (SELECT min(i.id) FROM images i WHERE i.blob_id = v.blob_id);
+DELETE FROM image_variants WHERE image_id IS NULL;
+ALTER TABLE image_variants ALTER COLUMN image_id SET NOT NULL;
+ALTER TABLE image_variants DROP COLUMN blob_id;
+ALTER TABLE image_variants ADD CONSTRAINT image_variants_image_id_name_key UNIQUE (image_id, name);
+CREATE INDEX IF NOT EXISTS image_variants_lookup_idx ON image_variants(image_id, mime_type, width);
+
+DROP INDEX IF EXISTS images_post_featured_sort_idx;
+DROP INDEX IF EXISTS images_blob_id_idx;
+ALTER TABLE images DROP COLUMN blob_id;
+CREATE INDEX IF NOT EXISTS images_post_featured_sort_idx ON images(post_id, is_featured, sort_order)
+INCLUDE (filename, file_path, alt_text, caption, width, height);
+
+DROP TABLE IF EXISTS image_blobs;
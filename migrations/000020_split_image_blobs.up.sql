@@ -0,0 +1,64 @@
+-- Image files are stored once per distinct content in image_blobs, keyed by their
+-- SHA-256. images now holds the attachments of blobs to posts.
+CREATE TABLE IF NOT EXISTS image_blobs (
+    id bigserial PRIMARY KEY,
+    -- NULL for files uploaded before uploads were hashed.
+    sha256 char(64) UNIQUE,
+    file_path varchar(500) NOT NULL,
+    file_size bigint NOT NULL,
+    mime_type varchar(100) NOT NULL,
+    width integer,
+    height integer,
+    -- The number of images rows attached to the blob, kept up to date by a trigger.
+    ref_count integer NOT NULL DEFAULT 0 CHECK (ref_count >= 0),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- Each existing upload becomes a blob of its own.
+ALTER TABLE image_blobs ADD COLUMN image_id bigint;
+INSERT INTO image_blobs (file_path, file_size, mime_type, width, height, ref_count, created_at, image_id)
+SELECT file_path, file_size, mime_type, width, height, 1, created_at, id FROM images;
+
+ALTER TABLE images ADD COLUMN blob_id bigint REFERENCES image_blobs(id) ON DELETE RESTRICT;
+UPDATE images i SET blob_id = b.id FROM image_blobs b WHERE b.image_id = i.id;
+ALTER TABLE images ALTER COLUMN blob_id SET NOT NULL;
+
+-- Variants are of the content, so they belong to the blob.
+ALTER TABLE image_variants ADD COLUMN blob_id bigint REFERENCES image_blobs(id) ON DELETE CASCADE;
+UPDATE image_variants v SET blob_id = i.blob_id FROM images i WHERE i.id = v.image_id;
+ALTER TABLE image_variants ALTER COLUMN blob_id SET NOT NULL;
+ALTER TABLE image_variants DROP COLUMN image_id;
+ALTER TABLE image_variants ADD CONSTRAINT image_variants_blob_id_name_key UNIQUE (blob_id, name);
+CREATE INDEX IF NOT EXISTS image_variants_lookup_idx ON image_variants(blob_id, mime_type, width);
+
+ALTER TABLE image_blobs DROP COLUMN image_id;
+
+DROP INDEX IF EXISTS images_post_featured_sort_idx;
+ALTER TABLE images
+    DROP COLUMN file_path,
+    DROP COLUMN file_size,
+    DROP COLUMN mime_type,
+    DROP COLUMN width,
+    DROP COLUMN height;
+
+CREATE INDEX IF NOT EXISTS images_blob_id_idx ON images(blob_id);
+CREATE INDEX IF NOT EXISTS images_post_featured_sort_idx ON images(post_id, is_featured, sort_order)
+INCLUDE (filename, blob_id, alt_text, caption);
+
+-- Keep ref_count in step with the attachments, however they come and go, including
+-- when a post is deleted and its images with it.
+CREATE OR REPLACE FUNCTION count_image_blob_refs() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        UPDATE image_blobs SET ref_count = ref_count + 1 WHERE id = NEW.blob_id;
+    END IF;
+    IF TG_OP IN ('DELETE', 'UPDATE') THEN
+        UPDATE image_blobs SET ref_count = ref_count - 1 WHERE id = OLD.blob_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER images_count_blob_refs
+AFTER INSERT OR DELETE OR UPDATE OF blob_id ON images
+FOR EACH ROW EXECUTE FUNCTION count_image_blob_refs();
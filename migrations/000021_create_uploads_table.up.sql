@@ -0,0 +1,15 @@
+-- Resumable uploads in progress. Each PATCH of an upload stores its bytes as a chunk in
+-- storage, and chunk_keys lists the accepted chunks in order.
+CREATE TABLE IF NOT EXISTS uploads (
+    id char(32) PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    post_id bigint NOT NULL REFERENCES posts ON DELETE CASCADE,
+    upload_length bigint NOT NULL CHECK (upload_length > 0),
+    upload_offset bigint NOT NULL DEFAULT 0 CHECK (upload_offset <= upload_length),
+    metadata text NOT NULL DEFAULT '',
+    chunk_keys text[] NOT NULL DEFAULT '{}',
+    image_id bigint REFERENCES images ON DELETE SET NULL,
+    completed_at timestamp(0) with time zone,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
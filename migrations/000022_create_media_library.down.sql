@@ -0,0 +1,31 @@
+DROP INDEX IF EXISTS images_created_at_idx;
+
+ALTER TABLE images
+    ADD COLUMN post_id bigint REFERENCES posts(id) ON DELETE CASCADE,
+    ADD COLUMN is_featured boolean NOT NULL DEFAULT false,
+    ADD COLUMN sort_order integer NOT NULL DEFAULT 0;
+
+-- Each image goes back to the first post it's attached to. Media attached to no post
+-- can't be kept.
+UPDATE images i
+SET post_id = pi.post_id, is_featured = pi.is_featured, sort_order = pi.sort_order
+FROM (
+    SELECT DISTINCT ON (image_id) image_id, post_id, is_featured, sort_order
+    FROM post_images
+    ORDER BY image_id, post_id
+) pi
+WHERE pi.image_id = i.id;
+
+DELETE FROM images WHERE post_id IS NULL;
+ALTER TABLE images ALTER COLUMN post_id SET NOT NULL;
+ALTER TABLE images DROP COLUMN user_id;
+
+CREATE INDEX IF NOT EXISTS images_post_id_idx ON images(post_id);
+CREATE INDEX IF NOT EXISTS images_featured_idx ON images(post_id, is_featured) WHERE is_featured = true;
+CREATE INDEX IF NOT EXISTS images_sort_order_idx ON images(post_id, sort_order);
+CREATE UNIQUE INDEX IF NOT EXISTS images_featured_unique_idx ON images(post_id)
+WHERE is_featured = true;
+CREATE INDEX IF NOT EXISTS images_post_featured_sort_idx ON images(post_id, is_featured, sort_order)
+INCLUDE (filename, blob_id, alt_text, caption);
+
+DROP TABLE IF EXISTS post_images;
@@ -0,0 +1,40 @@
+-- Images become a media library of their own. post_images attaches them to any number
+-- of posts, and holds what used to be per-image: the order of a post's gallery and
+-- which image is its featured one.
+CREATE TABLE IF NOT EXISTS post_images (
+    post_id bigint NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    image_id bigint NOT NULL REFERENCES images(id) ON DELETE CASCADE,
+    is_featured boolean NOT NULL DEFAULT false,
+    sort_order integer NOT NULL DEFAULT 0,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (post_id, image_id)
+);
+
+INSERT INTO post_images (post_id, image_id, is_featured, sort_order, created_at)
+SELECT post_id, id, is_featured, sort_order, created_at FROM images;
+
+CREATE INDEX IF NOT EXISTS post_images_image_id_idx ON post_images(image_id);
+CREATE INDEX IF NOT EXISTS post_images_sort_order_idx ON post_images(post_id, sort_order);
+
+-- Ensure only one featured image per post
+CREATE UNIQUE INDEX IF NOT EXISTS post_images_featured_unique_idx ON post_images(post_id)
+WHERE is_featured = true;
+
+-- Media belongs to whoever uploaded it, taken to be the author of the post for
+-- existing images.
+ALTER TABLE images ADD COLUMN user_id bigint REFERENCES users(id) ON DELETE SET NULL;
+UPDATE images i SET user_id = p.author_id FROM posts p WHERE p.id = i.post_id;
+
+DROP INDEX IF EXISTS images_post_featured_sort_idx;
+DROP INDEX IF EXISTS images_featured_unique_idx;
+DROP INDEX IF EXISTS images_featured_idx;
+DROP INDEX IF EXISTS images_sort_order_idx;
+DROP INDEX IF EXISTS images_post_id_idx;
+
+ALTER TABLE images
+    DROP COLUMN post_id,
+    DROP COLUMN is_featured,
+    DROP COLUMN sort_order;
+
+-- The library lists the newest media first.
+CREATE INDEX IF NOT EXISTS images_created_at_idx ON images(created_at, id);
@@ -0,0 +1,3 @@
+-- The capture date and camera kept from an upload's EXIF, which is stripped from the
+-- stored file. Images uploaded before this have none.
+ALTER TABLE images ADD COLUMN IF NOT EXISTS metadata jsonb;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS images_missing_placeholders_idx;
+ALTER TABLE images DROP COLUMN IF EXISTS lqip;
+ALTER TABLE images DROP COLUMN IF EXISTS blurhash;
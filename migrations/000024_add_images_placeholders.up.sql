@@ -0,0 +1,6 @@
+-- Placeholders shown while an image loads: a BlurHash and a tiny inlined copy (LQIP).
+-- Images uploaded before this get them from the "placeholders" command.
+ALTER TABLE images ADD COLUMN IF NOT EXISTS blurhash text;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS lqip text;
+
+CREATE INDEX IF NOT EXISTS images_missing_placeholders_idx ON images(blob_id) WHERE blurhash IS NULL;
@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'comments:moderate';
+
+DROP TABLE IF EXISTS comments;
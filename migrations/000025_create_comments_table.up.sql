@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS comments (
+    id bigserial PRIMARY KEY,
+    post_id bigint NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    parent_id bigint REFERENCES comments(id) ON DELETE CASCADE,
+    user_id bigint REFERENCES users(id) ON DELETE SET NULL,
+    author_name text NOT NULL,
+    author_email text NOT NULL DEFAULT '',
+    content text NOT NULL,
+    status text NOT NULL DEFAULT 'pending',
+    ip_address text NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1,
+    CONSTRAINT comments_status_check CHECK (status IN ('pending', 'approved', 'spam', 'deleted'))
+);
+
+CREATE INDEX IF NOT EXISTS comments_post_id_status_idx ON comments (post_id, status);
+CREATE INDEX IF NOT EXISTS comments_parent_id_idx ON comments (parent_id);
+CREATE INDEX IF NOT EXISTS comments_status_created_at_idx ON comments (status, created_at);
+
+INSERT INTO permissions (code) VALUES ('comments:moderate') ON CONFLICT (code) DO NOTHING;
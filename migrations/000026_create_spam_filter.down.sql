@@ -0,0 +1,4 @@
+ALTER TABLE comments DROP COLUMN IF EXISTS spam_class;
+
+DROP TABLE IF EXISTS spam_classes;
+DROP TABLE IF EXISTS spam_tokens;
@@ -0,0 +1,18 @@
+-- The naive Bayes spam filter's model: how many spam and ham (not spam) comments each
+-- token has been seen in, and how many of each there have been.
+CREATE TABLE IF NOT EXISTS spam_tokens (
+    token text PRIMARY KEY,
+    spam integer NOT NULL DEFAULT 0,
+    ham integer NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS spam_classes (
+    class text PRIMARY KEY,
+    messages integer NOT NULL DEFAULT 0
+);
+
+INSERT INTO spam_classes (class) VALUES ('spam'), ('ham') ON CONFLICT (class) DO NOTHING;
+
+-- What the filter was taught each comment was, so that a moderator changing their
+-- mind can be untaught.
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS spam_class text NOT NULL DEFAULT '';
@@ -0,0 +1,10 @@
+-- When a post last stopped being public, by being taken off the site, losing a tag or
+-- being deleted. Feeds and the sitemap only list public posts, so the last update of
+-- what they list isn't enough to tell when they last changed.
+CREATE TABLE IF NOT EXISTS post_removals (
+    id integer PRIMARY KEY DEFAULT 1,
+    removed_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    CONSTRAINT post_removals_single_row CHECK (id = 1)
+);
+
+INSERT INTO post_removals (id) VALUES (1) ON CONFLICT (id) DO NOTHING;
@@ -0,0 +1,8 @@
+-- Form tokens already spent on a submission, kept until they would have expired
+-- anyway, so that each can only be used once.
+CREATE TABLE IF NOT EXISTS used_form_tokens (
+    signature text PRIMARY KEY,
+    expires_at timestamp(0) with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS used_form_tokens_expires_at_idx ON used_form_tokens (expires_at);
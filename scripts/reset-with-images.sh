@@ -26,15 +26,18 @@ echo "Images copied and renamed successfully!"
 PGPASSWORD=pa55word psql -h localhost -p 5435 -U technoprise -d technoprise << 'EOF'
 
 -- Clear existing data
+DELETE FROM post_images;
 DELETE FROM images;
+DELETE FROM image_blobs;
 DELETE FROM posts;
 
 -- Reset sequences
 ALTER SEQUENCE posts_id_seq RESTART WITH 1;
 ALTER SEQUENCE images_id_seq RESTART WITH 1;
+ALTER SEQUENCE image_blobs_id_seq RESTART WITH 1;
 
 -- Insert new posts with better content
-INSERT INTO posts (title, slug, content, excerpt, published_at, created_at, updated_at, version) VALUES 
+INSERT INTO posts (title, slug, content, excerpt, published_at, created_at, updated_at, version, status) VALUES 
 (
     'Welcome to Technoprise Blog',
     'welcome-to-technoprise',
@@ -43,7 +46,8 @@ INSERT INTO posts (title, slug, content, excerpt, published_at, created_at, upda
     '2024-01-01 10:00:00',
     NOW(),
     NOW(),
-    1
+    1,
+    'published'
 ),
 (
     'Getting Started with Go APIs',
@@ -53,7 +57,8 @@ INSERT INTO posts (title, slug, content, excerpt, published_at, created_at, upda
     '2024-01-02 14:30:00',
     NOW(),
     NOW(),
-    1
+    1,
+    'published'
 ),
 (
     'Database Design Best Practices',
@@ -63,7 +68,8 @@ INSERT INTO posts (title, slug, content, excerpt, published_at, created_at, upda
     '2024-01-03 09:15:00',
     NOW(),
     NOW(),
-    1
+    1,
+    'published'
 ),
 (
     'Modern Frontend Development',
@@ -73,7 +79,8 @@ INSERT INTO posts (title, slug, content, excerpt, published_at, created_at, upda
     '2024-01-04 16:45:00',
     NOW(),
     NOW(),
-    1
+    1,
+    'published'
 ),
 (
     'Building Microservices with Docker',
@@ -83,7 +90,8 @@ INSERT INTO posts (title, slug, content, excerpt, published_at, created_at, upda
     '2024-01-05 11:20:00',
     NOW(),
     NOW(),
-    1
+    1,
+    'published'
 ),
 (
     'Advanced React Patterns',
@@ -93,17 +101,35 @@ INSERT INTO posts (title, slug, content, excerpt, published_at, created_at, upda
     '2024-01-06 13:30:00',
     NOW(),
     NOW(),
-    1
+    1,
+    'published'
 );
 
--- Insert images for the posts
-INSERT INTO images (post_id, filename, original_filename, file_path, file_size, mime_type, alt_text, caption, is_featured, sort_order, created_at, updated_at, version) VALUES
-(1, 'blog_1_featured.jpg', 'blog_1_featured.jpg', 'uploads/images/blog_1_featured.jpg', 150000, 'image/jpeg', 'Welcome to Technoprise Blog - Technology and Innovation', 'Featured image for our welcome post showcasing technology and innovation', true, 1, NOW(), NOW(), 1),
-(2, 'blog_2_featured.jpg', 'blog_2_featured.jpg', 'uploads/images/blog_2_featured.jpg', 140000, 'image/jpeg', 'Go Programming Language - Building Robust APIs', 'Illustration representing Go programming and API development', true, 1, NOW(), NOW(), 1),
-(3, 'blog_3_featured.jpg', 'blog_3_featured.jpg', 'uploads/images/blog_3_featured.jpg', 155000, 'image/jpeg', 'Database Design and Architecture', 'Visual representation of database design principles and best practices', true, 1, NOW(), NOW(), 1),
-(4, 'blog_4_featured.jpg', 'blog_4_featured.jpg', 'uploads/images/blog_4_featured.jpg', 145000, 'image/jpeg', 'Modern Frontend Development', 'Illustration showcasing modern frontend frameworks and tools', true, 1, NOW(), NOW(), 1),
-(5, 'blog_5_featured.jpg', 'blog_5_featured.jpg', 'uploads/images/blog_5_featured.jpg', 160000, 'image/jpeg', 'Microservices and Docker Containers', 'Visual representation of microservices architecture with Docker', true, 1, NOW(), NOW(), 1),
-(6, 'blog_6_featured.jpg', 'blog_6_featured.jpg', 'uploads/images/blog_6_featured.jpg', 152000, 'image/jpeg', 'Advanced React Patterns and Hooks', 'Illustration representing advanced React development patterns', true, 1, NOW(), NOW(), 1);
+-- Store the image files and add them to the media library
+INSERT INTO image_blobs (file_path, file_size, mime_type, created_at) VALUES
+('images/blog_1_featured.jpg', 150000, 'image/jpeg', NOW()),
+('images/blog_2_featured.jpg', 140000, 'image/jpeg', NOW()),
+('images/blog_3_featured.jpg', 155000, 'image/jpeg', NOW()),
+('images/blog_4_featured.jpg', 145000, 'image/jpeg', NOW()),
+('images/blog_5_featured.jpg', 160000, 'image/jpeg', NOW()),
+('images/blog_6_featured.jpg', 152000, 'image/jpeg', NOW());
+
+INSERT INTO images (blob_id, filename, original_filename, alt_text, caption, created_at, updated_at, version) VALUES
+(1, 'blog_1_featured.jpg', 'blog_1_featured.jpg', 'Welcome to Technoprise Blog - Technology and Innovation', 'Featured image for our welcome post showcasing technology and innovation', NOW(), NOW(), 1),
+(2, 'blog_2_featured.jpg', 'blog_2_featured.jpg', 'Go Programming Language - Building Robust APIs', 'Illustration representing Go programming and API development', NOW(), NOW(), 1),
+(3, 'blog_3_featured.jpg', 'blog_3_featured.jpg', 'Database Design and Architecture', 'Visual representation of database design principles and best practices', NOW(), NOW(), 1),
+(4, 'blog_4_featured.jpg', 'blog_4_featured.jpg', 'Modern Frontend Development', 'Illustration showcasing modern frontend frameworks and tools', NOW(), NOW(), 1),
+(5, 'blog_5_featured.jpg', 'blog_5_featured.jpg', 'Microservices and Docker Containers', 'Visual representation of microservices architecture with Docker', NOW(), NOW(), 1),
+(6, 'blog_6_featured.jpg', 'blog_6_featured.jpg', 'Advanced React Patterns and Hooks', 'Illustration representing advanced React development patterns', NOW(), NOW(), 1);
+
+-- Feature each image on its post
+INSERT INTO post_images (post_id, image_id, is_featured, sort_order) VALUES
+(1, 1, true, 1),
+(2, 2, true, 1),
+(3, 3, true, 1),
+(4, 4, true, 1),
+(5, 5, true, 1),
+(6, 6, true, 1);
 
 EOF
 